@@ -75,6 +75,29 @@ func (e entry) ID() EntryID          { return e.id }
 func (e entry) Name() EntryName      { return e.name }
 func (e entry) Directory() Directory { return e.dir }
 
+// entryIDKey is the key of an EntryID in the entries' ids map.  Documents and
+// folders can have the same integer IDs, so the kind is part of the key.  The
+// EntryID interface values aren't used as keys directly because then two
+// different implementations with the same ID and kind wouldn't find the same
+// entry.
+type entryIDKey struct {
+	id   int
+	kind EntryKind
+}
+
+func idKey(id EntryID) entryIDKey { return entryIDKey{id.ID(), id.Kind()} }
+
+// entryNameKey is the key of an EntryName in the entries' names map for the
+// same reasons as entryIDKey.
+type entryNameKey struct {
+	name string
+	kind EntryKind
+}
+
+func nameKey(name EntryName) entryNameKey {
+	return entryNameKey{name.Name(), name.Kind()}
+}
+
 // entries contains a collection of entries indexed by their names and IDs.
 type entries struct {
 	// items is all of the entries in the collection.
@@ -82,17 +105,17 @@ type entries struct {
 
 	// names holds a mapping of the entry names to their indexes in the
 	// items slice
-	names map[EntryName]int
+	names map[entryNameKey]int
 
 	// ids holds a mapping of the entry IDs to their indexes in the
 	// items slice
-	ids map[EntryID]int
+	ids map[entryIDKey]int
 }
 
 func (e *entries) Entries() []Entry { return e.items }
 
 func (e *entries) EntryByID(id EntryID) (Entry, bool) {
-	i, ok := e.ids[id]
+	i, ok := e.ids[idKey(id)]
 	if ok {
 		return e.items[i], true
 	}
@@ -100,9 +123,38 @@ func (e *entries) EntryByID(id EntryID) (Entry, bool) {
 }
 
 func (e *entries) EntryByName(name EntryName) (Entry, bool) {
-	i, ok := e.names[name]
-	if !ok {
+	i, ok := e.names[nameKey(name)]
+	if ok {
 		return e.items[i], true
 	}
 	return nil, false
 }
+
+// entryByLocalName gets an entry by its name alone.  A folder with the name
+// is returned before a document with the same name.
+func (e *entries) entryByLocalName(name string) (Entry, bool) {
+	if en, ok := e.EntryByName(FolderName(name)); ok {
+		return en, true
+	}
+	return e.EntryByName(DocumentName(name))
+}
+
+// add adds an entry to the collection.  If an entry with the same ID or name
+// is already in the collection, the entry isn't added and add returns false.
+func (e *entries) add(en Entry) bool {
+	id, name := idKey(en.ID()), nameKey(en.Name())
+	if _, ok := e.ids[id]; ok {
+		return false
+	}
+	if _, ok := e.names[name]; ok {
+		return false
+	}
+	if e.ids == nil {
+		e.ids = make(map[entryIDKey]int)
+		e.names = make(map[entryNameKey]int)
+	}
+	e.ids[id] = len(e.items)
+	e.names[name] = len(e.items)
+	e.items = append(e.items, en)
+	return true
+}
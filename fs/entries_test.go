@@ -0,0 +1,69 @@
+package fs
+
+import "testing"
+
+func TestEntryByName(t *testing.T) {
+	doc := entry{id: DocumentID(1), name: DocumentName("a.txt")}
+	fld := entry{id: FolderID(2), name: FolderName("b")}
+	var e entries
+	e.add(doc)
+	e.add(fld)
+	for _, tc := range []struct {
+		name     EntryName
+		expected Entry
+		ok       bool
+	}{
+		{DocumentName("a.txt"), doc, true},
+		{FolderName("b"), fld, true},
+		{DocumentName("missing"), nil, false},
+		// names of a different kind don't match.
+		{FolderName("a.txt"), nil, false},
+	} {
+		actual, ok := e.EntryByName(tc.name)
+		if ok != tc.ok || actual != tc.expected {
+			t.Errorf(
+				"EntryByName(%#v): expected (%v, %v), actual (%v, %v)",
+				tc.name, tc.expected, tc.ok, actual, ok)
+		}
+	}
+}
+
+// otherID is an EntryID implementation other than DocumentID and FolderID.
+type otherID struct {
+	id   int
+	kind EntryKind
+}
+
+func (o otherID) ID() int         { return o.id }
+func (o otherID) Kind() EntryKind { return o.kind }
+
+func TestEntryByIDSameNumber(t *testing.T) {
+	doc := entry{id: DocumentID(1), name: DocumentName("same")}
+	fld := entry{id: FolderID(1), name: FolderName("same")}
+	var e entries
+	if !e.add(doc) || !e.add(fld) {
+		t.Fatal("a document and folder with the same ID must both be added")
+	}
+	if e.add(entry{id: DocumentID(1), name: DocumentName("dup")}) {
+		t.Fatal("a second document with the same ID must not be added")
+	}
+	for _, tc := range []struct {
+		id       EntryID
+		expected Entry
+	}{
+		{DocumentID(1), doc},
+		{FolderID(1), fld},
+		{otherID{1, DocumentKind}, doc},
+		{otherID{1, FolderKind}, fld},
+	} {
+		actual, ok := e.EntryByID(tc.id)
+		if !ok || actual != tc.expected {
+			t.Errorf(
+				"EntryByID(%#v): expected %v, actual (%v, %v)",
+				tc.id, tc.expected, actual, ok)
+		}
+	}
+	if _, ok := e.EntryByName(FolderName("same")); !ok {
+		t.Error("expected to find the folder by name")
+	}
+}
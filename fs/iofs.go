@@ -0,0 +1,237 @@
+package fs
+
+import (
+	"io"
+	iofs "io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// FS adapts a Library to the standard library's io/fs.FS interface (along
+// with io/fs.ReadDirFS and io/fs.StatFS) so that it can be used with
+// functions like fs.WalkDir and fs.ReadFile.  Paths are relative to the
+// library, like "Documents/report.pdf".
+//
+// Directories are refreshed from ShareBase the first time they're needed and
+// aren't refreshed again after that, so an FS is a snapshot of the library as
+// it's walked.
+type FS struct {
+	c   *web.Client
+	lib *Library
+
+	// mutex protects the library's tree and loaded.
+	mutex  sync.Mutex
+	loaded map[Directory]bool
+}
+
+var (
+	_ iofs.FS          = (*FS)(nil)
+	_ iofs.ReadDirFS   = (*FS)(nil)
+	_ iofs.StatFS      = (*FS)(nil)
+	_ iofs.ReadDirFile = (*dirHandle)(nil)
+	_ iofs.File        = (*File)(nil)
+)
+
+// NewFS creates an FS over the library that uses c to make its requests.
+func NewFS(c *web.Client, lib *Library) *FS {
+	return &FS{c: c, lib: lib, loaded: make(map[Directory]bool)}
+}
+
+// Open implements io/fs.FS.  Documents are opened as copies of their Files
+// so that each one is read independently.
+func (fsys *FS) Open(name string) (iofs.File, error) {
+	en, err := fsys.entryByPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := en.(*File); ok {
+		h := &File{entry: f.entry, Document: f.Document, client: fsys.c}
+		return h, nil
+	}
+	entries, err := fsys.readDir("open", name, en.(Directory))
+	if err != nil {
+		return nil, err
+	}
+	return &dirHandle{info: fileInfo{en, name}, entries: entries}, nil
+}
+
+// ReadDir implements io/fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	en, err := fsys.entryByPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := en.(Directory)
+	if !ok {
+		return nil, &iofs.PathError{
+			Op: "readdir", Path: name,
+			Err: errors.Errorf("not a directory")}
+	}
+	return fsys.readDir("readdir", name, dir)
+}
+
+// Stat implements io/fs.StatFS.
+func (fsys *FS) Stat(name string) (iofs.FileInfo, error) {
+	en, err := fsys.entryByPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{en, name}, nil
+}
+
+// entryByPath resolves a slash-separated path relative to the library.
+func (fsys *FS) entryByPath(op, name string) (Entry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+	var en Entry = fsys.lib
+	if name == "." {
+		return en, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		dir, ok := en.(Directory)
+		if !ok {
+			return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrNotExist}
+		}
+		if err := fsys.load(dir); err != nil {
+			return nil, &iofs.PathError{Op: op, Path: name, Err: err}
+		}
+		if en, ok = dir.EntryByName(part); !ok {
+			return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrNotExist}
+		}
+	}
+	return en, nil
+}
+
+// readDir gets the entries of dir sorted by name.
+func (fsys *FS) readDir(op, name string, dir Directory) ([]iofs.DirEntry, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+	if err := fsys.load(dir); err != nil {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: err}
+	}
+	entries := make([]iofs.DirEntry, len(dir.Entries()))
+	for i, en := range dir.Entries() {
+		entries[i] = iofs.FileInfoToDirEntry(fileInfo{en, en.Name().Name()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// load refreshes dir if it hasn't been refreshed yet.  fsys.mutex must be
+// held.
+func (fsys *FS) load(dir Directory) error {
+	if fsys.loaded[dir] {
+		return nil
+	}
+	var err error
+	switch dir := dir.(type) {
+	case *Library:
+		err = dir.Refresh(fsys.c)
+	case *Folder:
+		err = dir.Refresh(fsys.c)
+	default:
+		err = errors.Errorf("cannot refresh %T", dir)
+	}
+	if err != nil {
+		if _, ok := err.(web.NotFound); ok {
+			return iofs.ErrNotExist
+		}
+		return err
+	}
+	fsys.loaded[dir] = true
+	return nil
+}
+
+// fileInfo implements io/fs.FileInfo for an Entry.
+type fileInfo struct {
+	en   Entry
+	path string
+}
+
+// Name implements io/fs.FileInfo.
+func (fi fileInfo) Name() string {
+	if fi.path == "." {
+		return "."
+	}
+	return fi.en.Name().Name()
+}
+
+// Size implements io/fs.FileInfo.  Directories' sizes are 0.
+func (fi fileInfo) Size() int64 {
+	f, ok := fi.en.(*File)
+	if !ok {
+		return 0
+	}
+	if f.content != nil && f.content.Length >= 0 {
+		return f.content.Length
+	}
+	return f.Size
+}
+
+// Mode implements io/fs.FileInfo.  Everything is read-only.
+func (fi fileInfo) Mode() iofs.FileMode {
+	if fi.IsDir() {
+		return iofs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ModTime implements io/fs.FileInfo.  Only documents have modification times.
+func (fi fileInfo) ModTime() time.Time {
+	if f, ok := fi.en.(*File); ok {
+		return f.DateModified
+	}
+	return time.Time{}
+}
+
+// IsDir implements io/fs.FileInfo.
+func (fi fileInfo) IsDir() bool {
+	_, ok := fi.en.(Directory)
+	return ok
+}
+
+// Sys implements io/fs.FileInfo by returning the Entry.
+func (fi fileInfo) Sys() interface{} { return fi.en }
+
+// dirHandle is an opened folder or library.
+type dirHandle struct {
+	info    fileInfo
+	entries []iofs.DirEntry
+	offset  int
+}
+
+func (h *dirHandle) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{
+		Op: "read", Path: h.info.path,
+		Err: errors.Errorf("is a directory")}
+}
+
+func (h *dirHandle) Close() error                 { return nil }
+func (h *dirHandle) Stat() (iofs.FileInfo, error) { return h.info, nil }
+
+// ReadDir implements io/fs.ReadDirFile.
+func (h *dirHandle) ReadDir(n int) ([]iofs.DirEntry, error) {
+	rest := h.entries[h.offset:]
+	if n <= 0 {
+		h.offset = len(h.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	h.offset += n
+	return rest[:n], nil
+}
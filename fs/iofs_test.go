@@ -0,0 +1,172 @@
+package fs
+
+import (
+	"encoding/json"
+	iofs "io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// serveLibrary serves library 1 with the folder tree:
+//
+//	Documents/
+//		report.txt
+//		Archive/
+//			old.txt
+func serveLibrary(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	contents := map[int]string{
+		1: "quarterly report",
+		2: "old news",
+	}
+	doc := func(id int, name string) web.Document {
+		return web.Document{
+			DocumentID:   id,
+			DocumentName: name,
+			DateModified: modified,
+			Size:         int64(len(contents[id])),
+			Links: web.DocumentLinks{
+				Content: srv.URL + "/content/" + name,
+			},
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Folder{
+			{FolderID: 10, FolderName: "Documents"},
+		})
+	})
+	mux.HandleFunc("/api/folders/10", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(web.Folder{
+			FolderID:   10,
+			FolderName: "Documents",
+			Embedded: web.FolderEmbedded{
+				Folders:   []web.Folder{{FolderID: 11, FolderName: "Archive"}},
+				Documents: []web.Document{doc(1, "report.txt")},
+			},
+		})
+	})
+	mux.HandleFunc("/api/folders/11", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(web.Folder{
+			FolderID:   11,
+			FolderName: "Archive",
+			Embedded: web.FolderEmbedded{
+				Documents: []web.Document{doc(2, "old.txt")},
+			},
+		})
+	})
+	mux.HandleFunc("/content/report.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(contents[1]))
+	})
+	mux.HandleFunc("/content/old.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(contents[2]))
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func newTestFS(t *testing.T, srv *httptest.Server) *FS {
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFS(c, &Library{Library: &web.Library{
+		LibraryID: 1,
+		Links:     web.LibraryLinks{Folders: srv.URL + "/libraries/1/folders"},
+	}})
+}
+
+func TestFS(t *testing.T) {
+	srv := serveLibrary(t)
+	defer srv.Close()
+	fsys := newTestFS(t, srv)
+	if err := fstest.TestFS(fsys, "Documents/report.txt", "Documents/Archive/old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := iofs.ReadFile(fsys, "Documents/Archive/old.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old news" {
+		t.Fatalf("expected %q, got %q", "old news", data)
+	}
+	if _, err = fsys.Open("Documents/missing.txt"); !errorIs(err, iofs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func errorIs(err, target error) bool {
+	pe, ok := err.(*iofs.PathError)
+	return ok && pe.Err == target
+}
+
+func TestFileRead(t *testing.T) {
+	srv := serveLibrary(t)
+	defer srv.Close()
+	fsys := newTestFS(t, srv)
+	if _, err := fsys.Stat("Documents/report.txt"); err != nil {
+		t.Fatal(err)
+	}
+	en, ok := fsys.lib.EntryByName("Documents")
+	if !ok {
+		t.Fatal("expected to find Documents")
+	}
+	en, ok = en.(*Folder).EntryByName("report.txt")
+	if !ok {
+		t.Fatal("expected to find report.txt")
+	}
+	f := en.(*File)
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "quarterly report" {
+		t.Fatalf("expected %q, got %q", "quarterly report", data)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(data)) || info.Name() != "report.txt" {
+		t.Fatalf("unexpected info: %v, %d", info.Name(), info.Size())
+	}
+	if !info.ModTime().Equal(f.DateModified) {
+		t.Fatalf("expected mod time %v, got %v", f.DateModified, info.ModTime())
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(make([]byte, 1)); err != iofs.ErrClosed {
+		t.Fatalf("expected %v after Close, got %v", iofs.ErrClosed, err)
+	}
+}
+
+func TestFileReadAfterClose(t *testing.T) {
+	srv := serveLibrary(t)
+	defer srv.Close()
+	fsys := newTestFS(t, srv)
+	for _, read := range []bool{false, true} {
+		f, err := fsys.Open("Documents/report.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read {
+			if _, err = f.Read(make([]byte, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Read(make([]byte, 1)); err != iofs.ErrClosed {
+			t.Fatalf("read %v: expected %v after Close, got %v", read, iofs.ErrClosed, err)
+		}
+	}
+}
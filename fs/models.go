@@ -1,6 +1,8 @@
 package fs
 
 import (
+	iofs "io/fs"
+
 	"github.com/skillian/sharebase/web"
 )
 
@@ -18,10 +20,56 @@ type Entry interface {
 	Directory() Directory
 }
 
-// File describes a ShareBase document in a folder.
+// File describes a ShareBase document in a folder.  It's also an io.ReadCloser
+// of the document's content: the content is requested with
+// web.Document.Content on the first Read and closed by Close.  After Close,
+// Read returns io/fs.ErrClosed until the File is found again by a refresh of
+// its folder.  A File shouldn't be read from multiple goroutines at the same
+// time.
 type File struct {
 	entry
 	*web.Document
+
+	// client is used to request the document's content.  It's the client
+	// of the refresh that found the File.
+	client *web.Client
+
+	// content is the content being read or nil if it hasn't been
+	// requested yet.
+	content *web.DocumentContent
+}
+
+// Read implements io.Reader.
+func (f *File) Read(p []byte) (int, error) {
+	if f.content == nil {
+		if f.client == nil {
+			return 0, iofs.ErrClosed
+		}
+		content, err := f.Document.Content(f.client)
+		if err != nil {
+			return 0, err
+		}
+		f.content = &content
+	}
+	return f.content.Read(p)
+}
+
+// Close implements io.Closer by closing the content, if it was requested.
+// Reading the File after it's closed returns io/fs.ErrClosed.
+func (f *File) Close() error {
+	f.client = nil
+	if f.content == nil {
+		return nil
+	}
+	err := f.content.Close()
+	f.content = nil
+	return err
+}
+
+// Stat gets the document's io/fs.FileInfo.  Once the content has been
+// requested, its Size is the content's Length if it's known.
+func (f *File) Stat() (iofs.FileInfo, error) {
+	return fileInfo{f, f.Name().Name()}, nil
 }
 
 // Directory is a file system directory
@@ -55,8 +103,93 @@ type Library struct {
 	*web.Library
 
 	// documents has an index of all documents in the library by their IDs
-	documents map[int]*Document
+	documents map[int]*File
 
 	// folders has an index of all folders in the library by their IDs
 	folders map[int]*Folder
 }
+
+// EntryByName implements the Directory interface.  Folders are checked before
+// documents with the same name.
+func (l *Library) EntryByName(name string) (Entry, bool) {
+	return l.entries.entryByLocalName(name)
+}
+
+// Refresh loads the library's top-level folders from ShareBase.
+func (l *Library) Refresh(c *web.Client) error {
+	wfs, err := l.Library.Folders(c)
+	if err != nil {
+		return err
+	}
+	l.entries = l.reconcile(c, l, wfs, nil)
+	return nil
+}
+
+// reconcile builds the entries of dir from the folders and documents that
+// ShareBase returned for it.  Folders and Files that the library already
+// knows about are found by their IDs and updated in place (even if they have
+// moved from another directory) so that existing references to them stay
+// valid.  Entries that aren't in dir anymore are dropped from its entries but
+// are kept in the library's indexes in case a later refresh finds them
+// somewhere else.
+func (l *Library) reconcile(c *web.Client, dir Directory, wfs []web.Folder, wds []web.Document) entries {
+	if l.folders == nil {
+		l.folders = make(map[int]*Folder)
+	}
+	if l.documents == nil {
+		l.documents = make(map[int]*File)
+	}
+	var es entries
+	for _, wf := range wfs {
+		f, ok := l.folders[wf.FolderID]
+		if !ok {
+			f = &Folder{lib: l, Folder: new(web.Folder)}
+			l.folders[wf.FolderID] = f
+		}
+		// Folder listings don't embed the folders' contents, so
+		// keep what was there from the folder's last refresh.
+		embedded := f.Folder.Embedded
+		*f.Folder = wf
+		f.Folder.Embedded = embedded
+		f.entry = entry{
+			id:   FolderID(wf.FolderID),
+			name: FolderName(wf.FolderName),
+			dir:  dir,
+		}
+		es.add(f)
+	}
+	for _, wd := range wds {
+		d, ok := l.documents[wd.DocumentID]
+		if !ok {
+			d = &File{Document: new(web.Document)}
+			l.documents[wd.DocumentID] = d
+		}
+		*d.Document = wd
+		d.client = c
+		d.entry = entry{
+			id:   DocumentID(wd.DocumentID),
+			name: DocumentName(wd.DocumentName),
+			dir:  dir,
+		}
+		es.add(d)
+	}
+	return es
+}
+
+// EntryByName implements the Directory interface.  Folders are checked before
+// documents with the same name.
+func (f *Folder) EntryByName(name string) (Entry, bool) {
+	return f.entries.entryByLocalName(name)
+}
+
+// Refresh loads the folder's subfolders and documents from ShareBase.
+func (f *Folder) Refresh(c *web.Client) error {
+	wf, err := f.Folder.Folder(c, f.FolderID)
+	if err != nil {
+		return err
+	}
+	*f.Folder = wf
+	f.entries = f.lib.reconcile(
+		c, f, wf.Embedded.Folders, wf.Embedded.Documents)
+	return nil
+}
@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// fakeShareBase serves a library with a single folder whose contents can be
+// changed between refreshes.
+type fakeShareBase struct {
+	mutex    sync.Mutex
+	embedded web.FolderEmbedded
+}
+
+func (fsb *fakeShareBase) serve() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Folder{
+			{FolderID: 10, FolderName: "Top", LibraryID: 1},
+		})
+	})
+	mux.HandleFunc("/api/folders/10", func(w http.ResponseWriter, r *http.Request) {
+		fsb.mutex.Lock()
+		defer fsb.mutex.Unlock()
+		json.NewEncoder(w).Encode(web.Folder{
+			FolderID:   10,
+			FolderName: "Top",
+			LibraryID:  1,
+			Embedded:   fsb.embedded,
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRefresh(t *testing.T) {
+	fsb := &fakeShareBase{
+		embedded: web.FolderEmbedded{
+			Folders:   []web.Folder{{FolderID: 1, FolderName: "same"}},
+			Documents: []web.Document{{DocumentID: 1, DocumentName: "same"}},
+		},
+	}
+	srv := fsb.serve()
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := &Library{Library: &web.Library{
+		LibraryID: 1,
+		Links:     web.LibraryLinks{Folders: srv.URL + "/libraries/1/folders"},
+	}}
+	if err = lib.Refresh(c); err != nil {
+		t.Fatal(err)
+	}
+	en, ok := lib.EntryByName("Top")
+	if !ok {
+		t.Fatal("expected to find folder Top in the library")
+	}
+	top := en.(*Folder)
+	if top.Directory() != lib {
+		t.Fatal("expected Top's directory to be the library")
+	}
+	if err = top.Refresh(c); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(top.Entries()); n != 2 {
+		t.Fatalf("expected 2 entries in Top, got %d", n)
+	}
+	doc, ok := top.EntryByID(DocumentID(1))
+	if !ok || doc.(*File).DocumentName != "same" {
+		t.Fatalf("expected document 1, got (%v, %v)", doc, ok)
+	}
+	sub, ok := top.EntryByID(FolderID(1))
+	if !ok || sub.(*Folder).FolderName != "same" {
+		t.Fatalf("expected folder 1, got (%v, %v)", sub, ok)
+	}
+
+	// the document is removed and the folder renamed.
+	fsb.mutex.Lock()
+	fsb.embedded = web.FolderEmbedded{
+		Folders: []web.Folder{{FolderID: 1, FolderName: "renamed"}},
+	}
+	fsb.mutex.Unlock()
+	if err = top.Refresh(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = top.EntryByID(DocumentID(1)); ok {
+		t.Fatal("expected the removed document to be gone")
+	}
+	en, ok = top.EntryByName("renamed")
+	if !ok {
+		t.Fatal("expected to find the renamed folder")
+	}
+	if en != sub {
+		t.Fatal("expected the renamed folder to be the same object")
+	}
+	if _, ok = top.EntryByName("same"); ok {
+		t.Fatal("expected the old folder name to be gone")
+	}
+}
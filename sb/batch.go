@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// batchPair is a source and target read from a -batch file.
+type batchPair struct {
+	// line is the pair's line number in the file, for errors.
+	line int
+
+	source, target string
+}
+
+// readBatch reads the source and target pairs from r.  Each line has a
+// source and a target separated by a tab or, if the line doesn't have any
+// tabs, by spaces.  Paths with spaces in them (like sb:My Library/...) must
+// be separated with a tab.  Blank lines and lines starting with "#" are
+// skipped.
+func readBatch(r io.Reader) ([]batchPair, error) {
+	var pairs []batchPair
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var fields []string
+		if strings.Contains(line, "\t") {
+			for _, f := range strings.Split(line, "\t") {
+				if f = strings.TrimSpace(f); f != "" {
+					fields = append(fields, f)
+				}
+			}
+		} else {
+			fields = strings.Fields(line)
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf(
+				"line %d: expected a source and a target, not %d "+
+					"fields (separate paths with spaces in "+
+					"them with a tab)",
+				n, len(fields))
+		}
+		pairs = append(pairs, batchPair{line: n, source: fields[0], target: fields[1]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.ErrorfWithCause(
+			err, "failed to read batch: %v", err)
+	}
+	return pairs, nil
+}
+
+// runBatch transfers every pair in the s.Batch file in order with the same
+// client and tree, so that the libraries and folders that several pairs
+// share are only looked up once.  The first failed transfer stops the batch.
+func (s *state) runBatch(c *web.Client) (err error) {
+	var r io.Reader = os.Stdin
+	if s.Batch != "-" {
+		var f *os.File
+		if f, err = os.Open(s.Batch); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to open batch file %q: %v", s.Batch, err)
+		}
+		defer errors.WrapDeferred(&err, f.Close)
+		r = f
+	}
+	pairs, err := readBatch(r)
+	if err != nil {
+		return err
+	}
+	for _, p := range pairs {
+		s.Source, s.Target, s.Sources = p.source, p.target, nil
+		if err = s.transfer(c); err != nil {
+			return errors.ErrorfWithCause(
+				err, "line %d: failed to copy %v to %v: %v",
+				p.line, p.source, p.target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestReadBatch(t *testing.T) {
+	pairs, err := readBatch(strings.NewReader(
+		"# uploads\n" +
+			"a.txt sb:my/a.txt\n" +
+			"\n" +
+			"sb:My Library/b c.txt\t./b c.txt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []batchPair{
+		{line: 2, source: "a.txt", target: "sb:my/a.txt"},
+		{line: 4, source: "sb:My Library/b c.txt", target: "./b c.txt"},
+	}
+	if len(pairs) != len(expected) || pairs[0] != expected[0] || pairs[1] != expected[1] {
+		t.Fatalf("expected %+v, got %+v", expected, pairs)
+	}
+	if _, err = readBatch(strings.NewReader("a.txt\n")); err == nil {
+		t.Fatal("expected an error for a line without a target")
+	}
+	if _, err = readBatch(strings.NewReader("a b.txt sb:my/a b.txt\n")); err == nil {
+		t.Fatal("expected an error for paths with spaces separated by spaces")
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	batch := filepath.Join(dir, "batch.txt")
+	if err = ioutil.WriteFile(batch, []byte(
+		"sb:Lib/Top/a.txt\t"+filepath.Join(dir, "a.txt")+"\n"+
+			"sb:Lib/Top/Sub/c.txt\t"+filepath.Join(dir, "c.txt")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot(), Batch: batch, NoMtime: true}
+	if err = s.runBatch(c); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range map[string]int{"a.txt": 1, "c.txt": 3} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != treeContents[id] {
+			t.Fatalf("%v: expected %q, got %q", name, treeContents[id], got)
+		}
+	}
+	// the second pair fails because a.txt now exists.
+	s.Batch = filepath.Join(dir, "again.txt")
+	if err = ioutil.WriteFile(s.Batch, []byte(
+		"sb:Lib/Top/a.txt\t"+filepath.Join(dir, "new.txt")+"\n"+
+			"sb:Lib/Top/a.txt\t"+filepath.Join(dir, "a.txt")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = s.runBatch(c)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected line 2 to fail, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/skillian/errors"
+)
+
+// defaultDataCenter is the data center used when neither the environment nor
+// the configuration file has one.
+const defaultDataCenter = "https://app.sharebase.com/sharebaseapi"
+
+// stdinConfigFilename is the -c value that reads the configuration from stdin
+// instead of a file, so that it can be piped in from a secret manager.
+const stdinConfigFilename = "-"
+
+// envConfigVars are the environment variables that override the
+// configuration file to the settings they override.
+var envConfigVars = []struct {
+	name  string
+	field func(c *Config) *string
+}{
+	{"SHAREBASE_DATACENTER", func(c *Config) *string { return &c.DataCenter }},
+	{"SHAREBASE_TOKEN", func(c *Config) *string { return &c.Token }},
+	{"SHAREBASE_USERNAME", func(c *Config) *string { return &c.Username }},
+	{"SHAREBASE_PASSWORD", func(c *Config) *string { return &c.Password }},
+}
+
+// loadConfig loads the configuration from the JSON file, then overrides it
+// with any of the envConfigVars that aren't empty, and finally fills in defaults
+// for anything that's still missing.  The file doesn't have to exist if any
+// environment variables are set so that secrets don't have to be written to
+// disk.
+func loadConfig(filename string, c *Config) error {
+	if err := loadJSONConfig(filename, c); err != nil {
+		if filename == stdinConfigFilename {
+			return err
+		}
+		if _, statErr := os.Stat(filename); !os.IsNotExist(statErr) || !envConfigSet() {
+			return err
+		}
+		logger.Debug1("%v doesn't exist; using the environment", filename)
+	}
+	applyEnvConfig(c)
+	if c.DataCenter == "" {
+		c.DataCenter = defaultDataCenter
+	}
+	return nil
+}
+
+// envConfigSet checks if any of the envConfigVars aren't empty.
+func envConfigSet() bool {
+	for _, v := range envConfigVars {
+		if os.Getenv(v.name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvConfig overrides c's settings with the envConfigVars that aren't
+// empty.
+func applyEnvConfig(c *Config) {
+	for _, v := range envConfigVars {
+		if value := os.Getenv(v.name); value != "" {
+			*v.field(c) = value
+		}
+	}
+}
+
+// checkConfigStdin checks that nothing else reads stdin when the
+// configuration is read from it with -c -.  The configuration is read
+// before anything else, so an upload from stdin would only get what's left
+// after it.  login is refused too because it saves its token into the
+// configuration file.
+func (s *state) checkConfigStdin() error {
+	if s.ConfigFilename != stdinConfigFilename {
+		return nil
+	}
+	conflict := ""
+	switch {
+	case s.Batch == "-":
+		conflict = "-batch -"
+	case s.Exec && strings.EqualFold(s.Source, loginCommand):
+		conflict = "login"
+	case s.Exec:
+		for _, arg := range s.Args {
+			if arg == "-" {
+				conflict = "-x " + s.Source + " -"
+			}
+		}
+	case s.Source == "-":
+		conflict = "a source of -"
+	}
+	if conflict == "" {
+		return nil
+	}
+	return errors.Errorf(
+		"-c - reads the configuration from stdin, so it can't be used "+
+			"with %v", conflict)
+}
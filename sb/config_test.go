@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// setenv sets the environment variables for the rest of the test.
+func setenv(t *testing.T, vars map[string]string) {
+	for _, v := range envConfigVars {
+		old, ok := os.LookupEnv(v.name)
+		name := v.name
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		os.Unsetenv(name)
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sbconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, defaultConfigFilename)
+	if err = saveJSONConfig(filename, &Config{
+		DataCenter: "https://file.example.com",
+		Username:   "file-user",
+		Token:      "file-token",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, map[string]string{
+		"SHAREBASE_TOKEN":    "env-token",
+		"SHAREBASE_USERNAME": "",
+	})
+	var c Config
+	if err = loadConfig(filename, &c); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		DataCenter: "https://file.example.com",
+		Username:   "file-user",
+		Token:      "env-token",
+	}
+	if c.DataCenter != want.DataCenter || c.Username != want.Username || c.Token != want.Token {
+		t.Fatalf("expected %+v, got %+v", want, c)
+	}
+
+	// without a file, the environment and defaults are used.
+	setenv(t, map[string]string{"SHAREBASE_TOKEN": "env-token"})
+	c = Config{}
+	if err = loadConfig(filepath.Join(dir, "missing.json"), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.DataCenter != defaultDataCenter || c.Token != "env-token" {
+		t.Fatalf("expected the default data center and env token, got %+v", c)
+	}
+
+	// but without either, the missing file is an error.
+	setenv(t, nil)
+	if err = loadConfig(filepath.Join(dir, "missing.json"), &Config{}); err == nil {
+		t.Fatal("expected an error loading a missing file")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	var revoked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/api/authenticate" {
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL.Path)
+		}
+		revoked = append(revoked, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	filename := filepath.Join(t.TempDir(), defaultConfigFilename)
+	if err := saveJSONConfig(filename, &Config{
+		DataCenter: srv.URL,
+		Username:   "file-user",
+		Token:      "file-token",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, nil)
+	s := &state{ConfigFilename: filename}
+	if err := loadConfig(filename, &s.Config); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.logout(); err != nil {
+		t.Fatal(err)
+	}
+	var c Config
+	if err := loadJSONConfig(filename, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "" || c.Username != "file-user" {
+		t.Fatalf("expected only the token to be removed, got %+v", c)
+	}
+	if len(revoked) != 1 || revoked[0] != "PHOENIX-TOKEN file-token" {
+		t.Fatalf("expected file-token to be revoked, got %q", revoked)
+	}
+
+	// a token from the environment is revoked but isn't written into
+	// the file.
+	setenv(t, map[string]string{"SHAREBASE_TOKEN": "env-token"})
+	s = &state{ConfigFilename: filename}
+	if err := loadConfig(filename, &s.Config); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.logout(); err != nil {
+		t.Fatal(err)
+	}
+	c = Config{}
+	if err := loadJSONConfig(filename, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "" || len(revoked) != 2 || revoked[1] != "PHOENIX-TOKEN env-token" {
+		t.Fatalf("expected env-token to be revoked but not saved, got %+v and %q", c, revoked)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if r.URL.Path != "/api/authenticate" || username != "env-user" || password != "env-password" {
+			t.Errorf("unexpected request: %v %v as %q", r.Method, r.URL.Path, username)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(web.AuthToken{Token: "new-token", UserName: username})
+	}))
+	defer srv.Close()
+	filename := filepath.Join(t.TempDir(), defaultConfigFilename)
+	if err := saveJSONConfig(filename, &Config{
+		Username: "file-user",
+		Password: "file-password",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// the environment's settings are used to log in but aren't written
+	// into the file.
+	setenv(t, map[string]string{
+		"SHAREBASE_DATACENTER": srv.URL,
+		"SHAREBASE_USERNAME":   "env-user",
+		"SHAREBASE_PASSWORD":   "env-password",
+	})
+	s := &state{ConfigFilename: filename}
+	if err := loadConfig(filename, &s.Config); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.login(); err != nil {
+		t.Fatal(err)
+	}
+	var c Config
+	if err := loadJSONConfig(filename, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.DataCenter != "" || c.Username != "file-user" || c.Password != "" || c.Token != "new-token" {
+		t.Fatalf("expected only the token and password to change, got %+v", c)
+	}
+}
+
+func TestLoadConfigStdin(t *testing.T) {
+	setenv(t, nil)
+	stdin, err := ioutil.TempFile(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err = stdin.WriteString(`{"Token": "piped-token"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = old }()
+	var c Config
+	if err = loadConfig(stdinConfigFilename, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "piped-token" || c.DataCenter != defaultDataCenter {
+		t.Fatalf("expected the piped token and the default data center, got %+v", c)
+	}
+}
+
+func TestCheckConfigStdin(t *testing.T) {
+	for _, tc := range []struct {
+		state *state
+		ok    bool
+	}{
+		{&state{ConfigFilename: "-", Source: "sb:my/a.txt", Target: "a.txt"}, true},
+		{&state{ConfigFilename: "-", Source: "-", Target: "sb:my/a.txt"}, false},
+		{&state{ConfigFilename: "-", Batch: "-"}, false},
+		{&state{ConfigFilename: "-", Batch: "pairs.txt"}, true},
+		{&state{ConfigFilename: "-", Exec: true, Source: "login"}, false},
+		{&state{ConfigFilename: "-", Exec: true, Source: "verify", Args: []string{"-"}}, false},
+		{&state{ConfigFilename: "-", Exec: true, Source: "ls", Target: "sb:my"}, true},
+		{&state{ConfigFilename: "config.json", Source: "-", Target: "sb:my/a.txt"}, true},
+	} {
+		if err := tc.state.checkConfigStdin(); (err == nil) != tc.ok {
+			t.Fatalf("expected ok: %v for %q %q, got %v", tc.ok, tc.state.Source, tc.state.Batch, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/skillian/errors"
+	"golang.org/x/term"
+)
+
+// isTerminal checks if f is an interactive terminal that the user can answer
+// prompts on.  It's false when f is a pipe or file, like in CI.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// confirm asks the user on the terminal whether to do something destructive
+// after listing exactly what it'll do, one line per action.  what names the
+// action for the error if it isn't confirmed, like "delete".  Nothing is
+// asked with -y.  Without a terminal to ask on, -y is required.
+func (s *state) confirm(what string, actions []string, question string) error {
+	if s.Yes {
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return errors.Errorf(
+			"refusing to %v without confirmation because stdin "+
+				"is not a terminal (use -y)", what)
+	}
+	ok, err := confirmActions(os.Stdin, os.Stderr, actions, question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf(
+			"%v was not confirmed (use -y to %v without "+
+				"confirmation)", what, what)
+	}
+	return nil
+}
+
+// confirmDeletes confirms deleting obs from ShareBase.  recursive says
+// whether the libraries and folders in obs are deleted with everything in
+// them, which isn't always the same as s.Recursive: -x rm only deletes what's
+// in them with -r but sync -mirror always does.
+func (s *state) confirmDeletes(obs []Object, recursive bool) error {
+	return s.confirm(
+		"delete", deleteActions(obs, recursive),
+		fmt.Sprintf("Delete %d objects?", len(obs)))
+}
+
+// deleteActions lists the deletions of obs for confirmDeletes.
+func deleteActions(obs []Object, recursive bool) []string {
+	actions := make([]string, len(obs))
+	for i, o := range obs {
+		actions[i] = fmt.Sprintf("delete %v %v", kindOf(o), PathOf(o))
+		switch o.(type) {
+		case *Library, *Folder:
+			if recursive {
+				actions[i] += " and everything in it"
+			}
+		}
+	}
+	return actions
+}
+
+// confirmLibraryDelete asks the user to type lib's name before it's deleted.
+// Deleting a library can't be undone, so unlike confirm, -y doesn't skip it.
+func (s *state) confirmLibraryDelete(lib *Library) error {
+	if !isTerminal(os.Stdin) {
+		return errors.Errorf(
+			"refusing to delete library %v because stdin is not a "+
+				"terminal to confirm it on", PathOf(lib))
+	}
+	ok, err := confirmName(os.Stdin, os.Stderr, lib.Name())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf(
+			"deleting library %v was not confirmed", PathOf(lib))
+	}
+	return nil
+}
+
+// confirmName asks for name to be typed, reading the answer from r.  Only
+// exactly name confirms it.
+func confirmName(r io.Reader, w io.Writer, name string) (bool, error) {
+	if _, err := fmt.Fprintf(w, "Type the library's name, %q, to delete it: ", name); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, errors.ErrorfWithCause(
+			err, "failed to read confirmation: %v", err)
+	}
+	return strings.TrimRight(answer, "\r\n") == name, nil
+}
+
+// confirmActions lists the actions to w and asks question, reading the
+// answer from r.  Only "y" or "yes" confirms them.
+func confirmActions(r io.Reader, w io.Writer, actions []string, question string) (bool, error) {
+	for _, a := range actions {
+		if _, err := fmt.Fprintln(w, a); err != nil {
+			return false, err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%v [y/N] ", question); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, errors.ErrorfWithCause(
+			err, "failed to read confirmation: %v", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
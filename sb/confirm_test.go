@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestConfirmActions(t *testing.T) {
+	actions := []string{"delete document sb:Lib/a.txt", "delete folder sb:Lib/Top"}
+	for _, tc := range []struct {
+		answer    string
+		confirmed bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	} {
+		var w bytes.Buffer
+		ok, err := confirmActions(strings.NewReader(tc.answer), &w, actions, "Delete 2 objects?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.confirmed {
+			t.Fatalf("%q: expected %v, got %v", tc.answer, tc.confirmed, ok)
+		}
+		expected := strings.Join(actions, "\n") + "\nDelete 2 objects? [y/N] "
+		if w.String() != expected {
+			t.Fatalf("expected prompt %q, got %q", expected, w.String())
+		}
+	}
+}
+
+func TestConfirmName(t *testing.T) {
+	for _, tc := range []struct {
+		answer    string
+		confirmed bool
+	}{
+		{"My Library\n", true},
+		{"My Library\r\n", true},
+		{"my library\n", false},
+		{"y\n", false},
+		{"", false},
+	} {
+		var w bytes.Buffer
+		ok, err := confirmName(strings.NewReader(tc.answer), &w, "My Library")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.confirmed {
+			t.Fatalf("%q: expected %v, got %v", tc.answer, tc.confirmed, ok)
+		}
+	}
+}
+
+func TestDeleteActions(t *testing.T) {
+	lib := newLibrary(NewRoot(), web.Library{LibraryID: 1, LibraryName: "Lib"})
+	f := &Folder{DotDot: lib, Folder: web.Folder{FolderID: 2, FolderName: "Top"}}
+	d := &Document{Folder: f, Document: web.Document{DocumentID: 3, DocumentName: "a.txt"}}
+	obs := []Object{f, d}
+	for _, tc := range []struct {
+		recursive bool
+		expected  []string
+	}{
+		{false, []string{"delete Folder sb:Lib/Top", "delete Document sb:Lib/Top/a.txt"}},
+		{true, []string{"delete Folder sb:Lib/Top and everything in it", "delete Document sb:Lib/Top/a.txt"}},
+	} {
+		if actions := deleteActions(obs, tc.recursive); !reflect.DeepEqual(actions, tc.expected) {
+			t.Errorf("recursive %v: expected %q, got %q", tc.recursive, tc.expected, actions)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// dedupeIndex finds documents that already have a local file's content so
+// that -dedupe can copy them server-side instead of uploading the file
+// again.  It indexes the documents in the folders being uploaded into and
+// the documents uploaded earlier in the same run.
+//
+// ShareBase only reports SHA-1 hashes, so that's what local files are hashed
+// with to compare them.  Two identical files uploaded at the same time by
+// different workers might both be uploaded.
+//
+// A nil *dedupeIndex is valid and doesn't find anything.
+type dedupeIndex struct {
+	// mutex protects the rest of the fields.
+	mutex sync.Mutex
+
+	// docs are the known documents by their hex-encoded SHA-1 hashes.
+	docs map[string]web.Document
+
+	// folders are the folders whose documents were already indexed.
+	folders map[*Folder]bool
+
+	// unsupported is set after ShareBase refuses a server-side copy so
+	// that the rest of the matches are uploaded without asking again.
+	unsupported bool
+
+	copies int
+	saved  int64
+}
+
+func newDedupeIndex() *dedupeIndex {
+	return &dedupeIndex{
+		docs:    make(map[string]web.Document),
+		folders: make(map[*Folder]bool),
+	}
+}
+
+// addFolder indexes the documents currently in f's children.  It must be
+// called from the goroutine that updates the tree.
+func (x *dedupeIndex) addFolder(f *Folder) {
+	if x == nil {
+		return
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.folders[f] {
+		return
+	}
+	x.folders[f] = true
+	for _, ch := range f.Children() {
+		if d, ok := ch.(*Document); ok && len(d.Hash) > 0 {
+			x.addLocked(getHex(d.Hash), d.Document)
+		}
+	}
+}
+
+// add indexes d by sum, the hex encoding of its SHA-1 hash.
+func (x *dedupeIndex) add(sum string, d web.Document) {
+	if x == nil {
+		return
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.addLocked(sum, d)
+}
+
+func (x *dedupeIndex) addLocked(sum string, d web.Document) {
+	if _, ok := x.docs[sum]; !ok {
+		x.docs[sum] = d
+	}
+}
+
+// lookup finds a document with the given hash and size.  It doesn't find
+// anything after a server-side copy was refused.
+func (x *dedupeIndex) lookup(sum string, size int64) (web.Document, bool) {
+	if x == nil {
+		return web.Document{}, false
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	d, ok := x.docs[sum]
+	if !ok || x.unsupported || d.Size != size {
+		return web.Document{}, false
+	}
+	return d, true
+}
+
+// copied records that size bytes didn't have to be uploaded.
+func (x *dedupeIndex) copied(size int64) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.copies++
+	x.saved += size
+}
+
+// setUnsupported records that ShareBase doesn't support server-side copies
+// and reports whether it was already known.
+func (x *dedupeIndex) setUnsupported() (known bool) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	known, x.unsupported = x.unsupported, true
+	return known
+}
+
+// report writes how many files were copied instead of uploaded and how many
+// bytes that saved.  Nothing is written if no files were copied.
+func (x *dedupeIndex) report(w io.Writer) error {
+	if x == nil {
+		return nil
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.copies == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(
+		w, "deduplicated: %d, saved: %v\n",
+		x.copies, web.Size(x.saved).Human())
+	return err
+}
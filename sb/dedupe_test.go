@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestDedupe(t *testing.T) {
+	const same = "same content"
+	sum := sha1.Sum([]byte(same))
+	for _, tc := range []struct {
+		name     string
+		copies   bool
+		attempts int
+		uploads  int
+	}{
+		// b.txt and a.txt are both copies of dup.txt; c.txt is new.
+		{"copy", true, 2, 1},
+		// the first refused copy stops the rest from being tried.
+		{"unsupported", false, 1, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mutex    sync.Mutex
+				attempts int
+				uploads  int
+				srv      *httptest.Server
+			)
+			encode := func(w http.ResponseWriter, v interface{}) {
+				if err := json.NewEncoder(w).Encode(v); err != nil {
+					t.Error(err)
+				}
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/libraries", func(w http.ResponseWriter, r *http.Request) {
+				encode(w, []web.Library{{
+					LibraryID:   1,
+					LibraryName: "Lib",
+					Links:       web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+				}})
+			})
+			mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+				encode(w, []web.Folder{{FolderID: 10, FolderName: "Top", LibraryID: 1}})
+			})
+			mux.HandleFunc("/api/folders/10", func(w http.ResponseWriter, r *http.Request) {
+				encode(w, web.Folder{
+					FolderID: 10, FolderName: "Top", LibraryID: 1,
+					Links: web.FolderLinks{Documents: srv.URL + "/api/folders/10/documents"},
+					Embedded: web.FolderEmbedded{
+						Documents: []web.Document{{
+							DocumentID: 5, DocumentName: "dup.txt", FolderID: 10,
+							Size: int64(len(same)), Hash: sum[:],
+							Links: web.DocumentLinks{Self: srv.URL + "/api/documents/5"},
+						}},
+					},
+				})
+			})
+			mux.HandleFunc("/api/documents/5/copy", func(w http.ResponseWriter, r *http.Request) {
+				var req web.CopyDocumentRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				mutex.Lock()
+				attempts++
+				id := 100 + attempts
+				mutex.Unlock()
+				if !tc.copies {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if req.FolderID != 10 {
+					t.Errorf("expected copy into folder 10, got %d", req.FolderID)
+				}
+				encode(w, web.Document{DocumentID: id, DocumentName: req.DocumentName, FolderID: 10})
+			})
+			mux.HandleFunc("/api/folders/10/documents", func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				uploads++
+				id := 200 + uploads
+				mutex.Unlock()
+				encode(w, web.Document{DocumentID: id, FolderID: 10})
+			})
+			srv = httptest.NewServer(mux)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			for name, content := range map[string]string{
+				"a.txt": same, "b.txt": same, "c.txt": "other content",
+			} {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := &state{
+				Root:       NewRoot(),
+				ClientPool: web.NewClientPool(),
+				Config:     Config{DataCenter: srv.URL, Token: "token"},
+				Dedupe:     true,
+				Jobs:       1,
+			}
+			defer s.ClientPool.Close()
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lib, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			source, err := os.Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.Close()
+			if err = s.localDirToShareBaseDir(c, source, lib.(*Library), "Top"); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != tc.attempts || uploads != tc.uploads {
+				t.Fatalf(
+					"expected %d copies and %d uploads, got %d and %d",
+					tc.attempts, tc.uploads, attempts, uploads)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestResumeFileToLocalFile(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	for _, tc := range []struct {
+		name    string
+		partial string
+		ranges  bool
+	}{
+		{"resume", "0123456789", true},
+		{"ignoredRange", "0123456789", false},
+		{"tooLarge", string(content) + "extra", true},
+		{"complete", string(content), true},
+		{"empty", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requested []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, r.Header.Get("Range"))
+				if !tc.ranges {
+					r.Header.Del("Range")
+				}
+				http.ServeContent(w, r, "doc.txt", time.Time{}, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lib := newLibrary(NewRoot(), web.Library{LibraryName: "Lib"})
+			d := &Document{
+				Folder: newFolder(lib, web.Folder{FolderName: "Folder"}),
+				Document: web.Document{
+					DocumentName: "doc.txt",
+					Size:         int64(len(content)),
+					Links:        web.DocumentLinks{Content: srv.URL},
+				},
+			}
+			filename := filepath.Join(t.TempDir(), "doc.txt")
+			if err = ioutil.WriteFile(filename, []byte(tc.partial), 0666); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &state{NoMtime: true}
+			err = s.resumeFileToLocalFile(c, d, f)
+			if err2 := f.Close(); err == nil {
+				err = err2
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("expected %q, got %q", content, got)
+			}
+			if tc.name == "resume" && (len(requested) != 1 || requested[0] != "bytes=10-") {
+				t.Fatalf("expected one request for bytes=10-, got %q", requested)
+			}
+			if tc.name == "complete" && len(requested) != 0 {
+				t.Fatalf("expected no requests, got %q", requested)
+			}
+		})
+	}
+}
+
+func TestDownloadDocuments(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "out")
+	s := &state{
+		Root:       NewRoot(),
+		ClientPool: web.NewClientPool(),
+		Config:     Config{DataCenter: srv.URL, Token: "token"},
+		Jobs:       2,
+		NoMtime:    true,
+		Target:     dir,
+	}
+	defer s.ClientPool.Close()
+	sources := []string{"sb:Lib/Top/*.txt", "sb:Lib/Top/Sub/c.txt"}
+	if err = s.shareBasePathsToLocal(c, sources); err != nil {
+		t.Fatal(err)
+	}
+	for id, name := range map[int]string{1: "a.txt", 2: "b.txt", 3: "c.txt"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != treeContents[id] {
+			t.Fatalf("%v: expected %q, got %q", name, treeContents[id], got)
+		}
+	}
+
+	// without -overwrite, the existing files fail but don't stop the
+	// others.
+	if err = os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	s.Target = dir
+	err = s.shareBasePathsToLocal(c, sources)
+	derr, ok := err.(downloadErrors)
+	if !ok || derr.total != 3 || len(derr.errs) != 2 {
+		t.Fatalf("expected 2 of 3 downloads to fail, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	sum := sha1.Sum(content)
+	for _, tc := range []struct {
+		name    string
+		partial string
+		hash    []byte
+		ok      bool
+	}{
+		{"fresh", "", sum[:], true},
+		{"resume", "0123456789", sum[:], true},
+		{"complete", string(content), sum[:], true},
+		{"corrupt", "", []byte{1}, false},
+		// the beginning was written by an earlier, corrupted download.
+		{"corruptResume", "01234xxxxx", sum[:], false},
+		{"corruptComplete", string(content), []byte{1}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "doc.txt", time.Time{}, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lib := newLibrary(NewRoot(), web.Library{LibraryName: "Lib"})
+			d := &Document{
+				Folder: newFolder(lib, web.Folder{FolderName: "Folder"}),
+				Document: web.Document{
+					DocumentName: "doc.txt",
+					Size:         int64(len(content)),
+					Hash:         tc.hash,
+					Links:        web.DocumentLinks{Content: srv.URL},
+				},
+			}
+			filename := filepath.Join(t.TempDir(), "doc.txt")
+			if err = ioutil.WriteFile(filename, []byte(tc.partial), 0666); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			s := &state{NoMtime: true, Verify: true}
+			err = s.resumeFileToLocalFile(c, d, f)
+			if tc.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if _, ok := err.(HashMismatch); !ok {
+				t.Fatalf("expected a HashMismatch, got %v (type: %T)", err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// usage is the cumulative size of a document, folder, or library found by
+// the du command.
+type usage struct {
+	o    Object
+	path ShareBasePath
+	size int64
+}
+
+// diskUsage writes the cumulative size of every folder under o, sorted by
+// path, followed by o's own total, like Unix du.  The tree is updated all the
+// way down to find every document's size.
+//
+// Documents whose sizes weren't listed by ShareBase aren't counted; a warning
+// with how many there were is logged instead of getting each one's metadata.
+func (s *state) diskUsage(c *web.Client, o Object) error {
+	usages, unknown, err := s.usageOf(c, o)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get size of %v: %v", PathOf(o), err)
+	}
+	if unknown > 0 {
+		logger.Warn(
+			"%d documents under %v have unknown sizes and weren't "+
+				"counted", unknown, PathOf(o))
+	}
+	return s.writeUsages(os.Stdout, usages)
+}
+
+// usageOf gets the usage of every folder under o and then o itself, which
+// is always last.  unknown is the number of documents that weren't counted
+// because their sizes are unknown.
+func (s *state) usageOf(c *web.Client, o Object) (usages []usage, unknown int, err error) {
+	var size int64
+	switch o := o.(type) {
+	case *Document:
+		wd := o.Document
+		if documentSizeUnknown(wd) {
+			if wd, err = o.Document.Metadata(c); err != nil {
+				return nil, 0, err
+			}
+		}
+		size = wd.Size
+	case *Folder:
+		size, err = s.parentUsage(c, o, &usages, &unknown)
+	case *Library:
+		size, err = s.parentUsage(c, o, &usages, &unknown)
+	default:
+		return nil, 0, errors.Errorf("cannot get the size of %T", o)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return lessPath(usages[i].path, usages[j].path)
+	})
+	return append(usages, usage{o, PathOf(o), size}), unknown, nil
+}
+
+// parentUsage updates p and adds up the sizes of its documents and the
+// cumulative sizes of its folders.  Each folder under p is appended to usages.
+func (s *state) parentUsage(c *web.Client, p Parent, usages *[]usage, unknown *int) (int64, error) {
+	if err := p.update(s.Root, c); err != nil {
+		return 0, errors.ErrorfWithCause(
+			err, "failed to update %v", PathOf(p))
+	}
+	var total int64
+	for _, ch := range p.Children() {
+		switch ch := ch.(type) {
+		case *Document:
+			if documentSizeUnknown(ch.Document) {
+				*unknown++
+				continue
+			}
+			total += ch.Document.Size
+		case *Folder:
+			size, err := s.parentUsage(c, ch, usages, unknown)
+			if err != nil {
+				return 0, err
+			}
+			*usages = append(*usages, usage{ch, PathOf(ch), size})
+			total += size
+		}
+	}
+	return total, nil
+}
+
+// documentSizeUnknown checks if ShareBase left wd's size out of a listing.
+// A size other than 0 is always real, but a 0 is only trusted if wd's
+// metadata was loaded by the same rule web.Document.Metadata uses;
+// otherwise the document might not actually be empty.
+func documentSizeUnknown(wd web.Document) bool {
+	return wd.Size == 0 && !wd.MetadataLoaded()
+}
+
+// lessPath orders paths element by element so that folders come right before
+// their contents.
+func lessPath(a, b ShareBasePath) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// writeUsages writes usages as lines of size and path or, with -json, as
+// newline-delimited JSON objects.
+func (s *state) writeUsages(w io.Writer, usages []usage) error {
+	enc := json.NewEncoder(w)
+	for _, u := range usages {
+		var err error
+		if s.JSON {
+			info := makeObjectInfo(u.o)
+			info.Path = u.path.String()
+			info.Size = &u.size
+			err = enc.Encode(info)
+		} else {
+			_, err = fmt.Fprintf(
+				w, "%s\t%v\n", formatSize(u.size, s.Human), u.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestDiskUsage(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usages, unknown, err := s.usageOf(c, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown != 1 {
+		t.Fatalf("expected 1 document with an unknown size, got %d", unknown)
+	}
+	var buf bytes.Buffer
+	if err = s.writeUsages(&buf, usages); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "15\tsb:Lib/Top\n5\tsb:Lib/Top/Sub\n15\tsb:Lib\n"
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestDocumentSizeUnknown(t *testing.T) {
+	for _, tc := range []struct {
+		wd      web.Document
+		unknown bool
+	}{
+		{web.Document{Size: 3}, false},
+		{web.Document{Size: 3, Hash: []byte{1}}, false},
+		// an empty document's listing is only trusted with its hash,
+		// like web.Document.Metadata.
+		{web.Document{Hash: []byte{1}}, false},
+		{web.Document{}, true},
+	} {
+		if unknown := documentSizeUnknown(tc.wd); unknown != tc.unknown {
+			t.Errorf("%+v: expected %v, got %v", tc.wd, tc.unknown, unknown)
+		}
+	}
+}
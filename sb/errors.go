@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ChildNotFound is returned when the requested child object is not found.
 type ChildNotFound struct {
@@ -26,3 +29,81 @@ func (c ChildNotFound) Error() string {
 	}
 	return fmt.Sprintf("child not found: %v", key)
 }
+
+// isChildNotFound checks if err or any of the errors that it was wrapped
+// around is a ChildNotFound.
+func isChildNotFound(err error) bool {
+	for ; err != nil; err = unwrapError(err) {
+		if _, ok := err.(ChildNotFound); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetExists is returned when a download would replace an existing local
+// file and overwriting wasn't allowed.
+type TargetExists struct {
+	// Path is the local path that already exists.
+	Path string
+}
+
+// Error implements the Go error interface.
+func (t TargetExists) Error() string {
+	return fmt.Sprintf("refusing to overwrite existing target %q", t.Path)
+}
+
+// HashMismatch is returned with -verify when the SHA-1 hash of a downloaded
+// file doesn't match the hash that ShareBase reports for its document.
+type HashMismatch struct {
+	// Document is the path of the downloaded document.
+	Document string
+
+	// File is the local file it was downloaded into.
+	File string
+
+	// Expected is the hash reported by ShareBase.
+	Expected []byte
+
+	// Actual is the hash of the downloaded file.
+	Actual []byte
+}
+
+// Error implements the Go error interface.
+func (h HashMismatch) Error() string {
+	return fmt.Sprintf(
+		"%v failed verification: the SHA-1 hash of %v is %x but "+
+			"ShareBase reports %x",
+		h.Document, h.File, h.Actual, h.Expected)
+}
+
+// UnsafeTarEntry is returned when a tar entry's name is an absolute path or
+// climbs out of the folder that the tar is extracted into with "..".
+type UnsafeTarEntry struct {
+	// Name is the entry's name from its tar header.
+	Name string
+}
+
+// Error implements the Go error interface.
+func (u UnsafeTarEntry) Error() string {
+	return fmt.Sprintf(
+		"refusing to extract tar entry %q outside of the target folder",
+		u.Name)
+}
+
+// AmbiguousName is returned when a path element matches more than one child
+// because their names only differ by case.
+type AmbiguousName struct {
+	// Name is the path element that was looked up.
+	Name string
+
+	// Matches are the names of the children it matched.
+	Matches []string
+}
+
+// Error implements the Go error interface.
+func (a AmbiguousName) Error() string {
+	return fmt.Sprintf(
+		"%q matches more than one child: %v",
+		a.Name, strings.Join(a.Matches, ", "))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// Exit codes that sb exits with so that scripts can tell why it failed.
+// They're documented in the README.
+const (
+	// exitError is for every error that doesn't have its own code.
+	exitError = 1
+
+	// exitNotFound is for a ShareBase or local source that doesn't
+	// exist.
+	exitNotFound = 2
+
+	// exitAuth is for a missing, invalid, or expired token or a user
+	// that isn't allowed to do what was asked.
+	exitAuth = 3
+
+	// exitNetwork is for failing to reach ShareBase or a request that
+	// timed out.
+	exitNetwork = 4
+
+	// exitExists is for refusing to overwrite an existing local target
+	// without -f.
+	exitExists = 5
+)
+
+// exitCode gets the exit code for err.  The errors that err was wrapped
+// around are checked too, so a "failed to get ..." error caused by an
+// Unauthorized response still exits with exitAuth.
+func exitCode(err error) int {
+	for ; err != nil; err = unwrapError(err) {
+		switch err := err.(type) {
+		case web.NotFound, ChildNotFound:
+			return exitNotFound
+		case web.Unauthorized, web.Forbidden:
+			return exitAuth
+		case TargetExists:
+			return exitExists
+		case downloadErrors:
+			return err.exitCode()
+		case net.Error:
+			return exitNetwork
+		}
+		if err == context.DeadlineExceeded {
+			return exitNetwork
+		}
+		if os.IsNotExist(err) {
+			return exitNotFound
+		}
+	}
+	return exitError
+}
+
+// requestIDOf gets the ShareBase request ID of the first error in err's chain
+// that has one or "" if none of them do.
+func requestIDOf(err error) string {
+	for ; err != nil; err = unwrapError(err) {
+		if r, ok := err.(interface{ RequestID() string }); ok && r.RequestID() != "" {
+			return r.RequestID()
+		}
+	}
+	return ""
+}
+
+// unwrapError gets the error that err was wrapped around or nil if it
+// wasn't wrapped around anything.
+func unwrapError(err error) error {
+	switch err := err.(type) {
+	case interface{ Unwrap() error }:
+		return err.Unwrap()
+	case interface{ Cause() error }:
+		return err.Cause()
+	}
+	return nil
+}
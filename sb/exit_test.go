@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+func TestExitCode(t *testing.T) {
+	_, statErr := os.Stat("definitely/not/here")
+	for _, tc := range []struct {
+		err      error
+		expected int
+	}{
+		{errors.New("something else"), exitError},
+		{web.NotFound{Kind: web.FolderKind, ID: 1}, exitNotFound},
+		{ChildNotFound{Name: "x"}, exitNotFound},
+		{statErr, exitNotFound},
+		{web.ErrUnauthorized, exitAuth},
+		{fmt.Errorf("failed to get libraries: %w", web.Forbidden{}), exitAuth},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, exitNetwork},
+		{fmt.Errorf("failed: %w", context.DeadlineExceeded), exitNetwork},
+		{TargetExists{Path: "a.txt"}, exitExists},
+		{downloadErrors{total: 3, errs: []error{
+			TargetExists{Path: "a.txt"}, TargetExists{Path: "b.txt"},
+		}}, exitExists},
+		{downloadErrors{total: 3, errs: []error{
+			TargetExists{Path: "a.txt"}, web.ErrUnauthorized,
+		}}, exitError},
+	} {
+		if got := exitCode(tc.err); got != tc.expected {
+			t.Errorf("%v: expected %d, got %d", tc.err, tc.expected, got)
+		}
+	}
+}
+
+func TestRequestIDOf(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Libraries()
+	err = errors.ErrorfWithCause(err, "failed to get libraries: %v", err)
+	if got := requestIDOf(err); got != "req-1" {
+		t.Fatalf("expected request ID req-1, got %q", got)
+	}
+	if got := requestIDOf(errors.New("something else")); got != "" {
+		t.Fatalf("expected no request ID, got %q", got)
+	}
+}
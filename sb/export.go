@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// exportNode is how export writes each library, folder, and document.  Its
+// fields (including objectInfo's) are export's format, so they may be added
+// to but not renamed or removed:
+//
+//	{
+//	  "name": "Lib", "path": "sb:Lib", "id": 1, "kind": "Library",
+//	  "children": [
+//	    {"name": "a.txt", "id": 1, "kind": "Document", "size": 10,
+//	     "dateModified": "2020-01-02T03:04:05Z", "hash": "..."},
+//	    ...
+//	  ]
+//	}
+//
+// Only the root has a path.  Documents only have a size if ShareBase listed
+// one and a date modified if it's known.  Children are sorted by name and are
+// only present for libraries and folders.
+type exportNode struct {
+	objectInfo
+
+	Children []exportNode `json:"children,omitempty"`
+}
+
+// exportTree writes the tree under o to stdout as a single JSON document.
+// See (*state).writeExport.
+func (s *state) exportTree(c *web.Client, o Object) error {
+	return s.writeExport(c, os.Stdout, o)
+}
+
+// writeExport updates the whole tree under o and then writes it to w as an
+// exportNode.  The JSON is written one object at a time as the tree is
+// walked instead of being marshaled all at once, so exporting a huge library
+// only needs memory for the tree itself.
+func (s *state) writeExport(c *web.Client, w io.Writer, o Object) (err error) {
+	if p, ok := asParent(o); ok {
+		if err = s.updateTree(c, p, nil); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	defer errors.WrapDeferred(&err, bw.Flush)
+	info := makeExportInfo(o)
+	info.Path = PathOf(o).String()
+	if err = writeExportNode(bw, o, info); err != nil {
+		return err
+	}
+	return bw.WriteByte('\n')
+}
+
+// makeExportInfo is like makeObjectInfo but leaves out documents' sizes and
+// dates modified when they're unknown instead of writing zeros.
+func makeExportInfo(o Object) objectInfo {
+	info := makeObjectInfo(o)
+	if d, ok := o.(*Document); ok {
+		if documentSizeUnknown(d.Document) {
+			info.Size = nil
+		}
+		if d.DateModified.IsZero() {
+			info.DateModified = nil
+		}
+	}
+	return info
+}
+
+// writeExportNode writes o's exportNode with info as its objectInfo and its
+// children written depth-first.
+func writeExportNode(w *bufio.Writer, o Object, info objectInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	p, ok := asParent(o)
+	if !ok {
+		_, err = w.Write(b)
+		return err
+	}
+	children := append([]Object(nil), p.Children()...)
+	if len(children) == 0 {
+		_, err = w.Write(b)
+		return err
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	// objectInfo always marshals to an object, so its closing brace is
+	// replaced with the children.
+	w.Write(b[:len(b)-1])
+	w.WriteString(`,"children":[`)
+	for i, ch := range children {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err = writeExportNode(w, ch, makeExportInfo(ch)); err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteString("]}")
+	return err
+}
+
+// updateTree updates root and every library and folder under it so that the
+// whole tree is known.  If f isn't nil, it's called with every object under
+// root after its parent is updated.
+func (s *state) updateTree(c *web.Client, root Parent, f func(o Object) error) error {
+	return WalkObjects(root, c, func(_ ShareBasePath, o Object) error {
+		if o == Object(root) || f == nil {
+			return nil
+		}
+		return f(o)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestExport(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	lib, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = s.writeExport(c, &buf, lib); err != nil {
+		t.Fatal(err)
+	}
+	var root exportNode
+	if err = json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if root.Path != "sb:Lib" || root.Kind != web.LibraryKind || len(root.Children) != 1 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	top := root.Children[0]
+	if top.Name != "Top" || top.ID != 10 || top.Path != "" || len(top.Children) != 3 {
+		t.Fatalf("unexpected Top: %+v", top)
+	}
+	sub, a, b := top.Children[0], top.Children[1], top.Children[2]
+	if sub.Name != "Sub" || len(sub.Children) != 1 || sub.Children[0].Name != "c.txt" {
+		t.Fatalf("unexpected Sub: %+v", sub)
+	}
+	if a.Name != "a.txt" || a.Size == nil || *a.Size != 10 || a.Hash != "01" || a.DateModified != nil {
+		t.Fatalf("unexpected a.txt: %+v", a)
+	}
+	if b.Name != "b.txt" || b.Size != nil || b.Children != nil {
+		t.Fatalf("unexpected b.txt: %+v", b)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// fieldsFlag collects -field name=value flags into a map.
+type fieldsFlag map[string]string
+
+// Set implements flag.Value.
+func (f *fieldsFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i < 1 {
+		return errors.Errorf("expected a field as name=value, not %q", v)
+	}
+	if *f == nil {
+		*f = make(fieldsFlag)
+	}
+	(*f)[v[:i]] = v[i+1:]
+	return nil
+}
+
+// String implements flag.Value.
+func (f *fieldsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	fields := make([]string, 0, len(*f))
+	for k, v := range *f {
+		fields = append(fields, k+"="+v)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// checkFields checks that the -field flags have values for all of the fields
+// that the library at the start of target requires, so that an upload into a
+// library with a document type fails before any content is sent.  Libraries
+// that don't exist yet (and libraries that ShareBase doesn't report field
+// definitions for) aren't checked.
+func (s *state) checkFields(c *web.Client, target Path) error {
+	if target.Len() == 0 {
+		return nil
+	}
+	o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{target.Elem(0)})
+	if err != nil {
+		if isChildNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	lib, ok := o.(*Library)
+	if !ok {
+		return errors.NewUnexpectedType(lib, o)
+	}
+	defs, err := lib.Library.FieldDefinitions(c)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get the fields of %v: %v", PathOf(lib), err)
+	}
+	if err = web.CheckFields(defs, s.Fields); err != nil {
+		return errors.ErrorfWithCause(
+			err, "cannot upload into %v: %v (set them with -field)",
+			PathOf(lib), err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestFieldsFlag(t *testing.T) {
+	var f fieldsFlag
+	for _, v := range []string{"Vendor=ACME", "Invoice=42", "Notes=a=b"} {
+		if err := f.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, expected := f.String(), "Invoice=42,Notes=a=b,Vendor=ACME"; got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	for _, v := range []string{"", "novalue", "=x"} {
+		if err := f.Set(v); err == nil {
+			t.Fatalf("expected an error for %q", v)
+		}
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/libraries", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Library{{
+			LibraryID:   1,
+			LibraryName: "Lib",
+			Links:       web.LibraryLinks{Self: srv.URL + "/api/libraries/1"},
+		}})
+	})
+	mux.HandleFunc("/api/libraries/1/fields", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.FieldDefinition{{FieldName: "Invoice", Required: true}})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	if err = s.checkFields(c, ShareBasePath{"Lib", "Top"}); err == nil {
+		t.Fatal("expected Invoice to be required")
+	}
+	s.Fields = fieldsFlag{"Invoice": "42"}
+	if err = s.checkFields(c, ShareBasePath{"Lib", "Top"}); err != nil {
+		t.Fatal(err)
+	}
+	// libraries that don't exist yet aren't checked.
+	if err = s.checkFields(c, ShareBasePath{"Other"}); err != nil {
+		t.Fatal(err)
+	}
+}
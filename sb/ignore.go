@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/skillian/errors"
+)
+
+// ignoreFilename is the name of the file in the root of an uploaded directory
+// (or tar) that lists patterns of files and directories not to upload.
+const ignoreFilename = ".sharebaseignore"
+
+// stringsFlag is a flag.Value that can be specified multiple times to collect
+// all of its values.
+type stringsFlag []string
+
+// Set implements flag.Value.
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// String implements flag.Value.
+func (f *stringsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+// excludeRule is a single parsed exclude pattern.
+type excludeRule struct {
+	// pattern is matched with path.Match.
+	pattern string
+
+	// negate re-includes paths excluded by an earlier rule.
+	negate bool
+
+	// dirOnly rules only match directories.
+	dirOnly bool
+
+	// anchored rules match against the whole path relative to the upload
+	// root instead of only the base name.
+	anchored bool
+}
+
+// excluder decides which paths relative to the root of an upload are
+// excluded.  Patterns follow a subset of .gitignore's rules:
+//
+//   - Blank lines and lines starting with # are ignored.
+//   - A leading ! re-includes a path excluded by an earlier pattern.
+//   - A trailing / only matches directories.
+//   - A pattern with a / anywhere else matches the whole path relative to
+//     the upload root.  Otherwise, it matches base names at any depth.
+//
+// When multiple patterns match a path, the last one wins.
+type excluder struct {
+	rules []excludeRule
+}
+
+// addPattern parses and adds a single pattern.
+func (e *excluder) addPattern(p string) {
+	p = strings.TrimSpace(p)
+	if p == "" || strings.HasPrefix(p, "#") {
+		return
+	}
+	var r excludeRule
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if strings.Contains(p, "/") {
+		r.anchored = true
+		p = strings.TrimLeft(p, "/")
+	}
+	if p == "" {
+		return
+	}
+	r.pattern = p
+	e.rules = append(e.rules, r)
+}
+
+// addPatterns adds every pattern read from r, one per line.
+func (e *excluder) addPatterns(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		e.addPattern(sc.Text())
+	}
+	return sc.Err()
+}
+
+// newExcluder creates an excluder with the patterns read from ignore (if it's
+// not nil) followed by the -exclude patterns so that the patterns from the
+// command line win.
+func (s *state) newExcluder(ignore io.Reader) (*excluder, error) {
+	e := new(excluder)
+	if ignore != nil {
+		if err := e.addPatterns(ignore); err != nil {
+			return nil, errors.ErrorfWithCause(
+				err, "failed to read %v: %v", ignoreFilename, err)
+		}
+	}
+	for _, p := range s.Exclude {
+		e.addPattern(p)
+	}
+	return e, nil
+}
+
+// newDirExcluder creates an excluder for uploading the local directory dir
+// with the patterns from its ignore file, if it has one.
+func (s *state) newDirExcluder(dir string) (e *excluder, err error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s.newExcluder(nil)
+		}
+		return nil, errors.ErrorfWithCause(
+			err, "failed to open %v in %v: %v",
+			ignoreFilename, dir, err)
+	}
+	defer errors.WrapDeferred(&err, f.Close)
+	return s.newExcluder(f)
+}
+
+// excluded checks if the path rel (relative to the upload root and separated
+// with forward slashes) is excluded.  Only rel itself is checked, not its
+// parent directories.
+func (e *excluder) excluded(rel string, isDir bool) bool {
+	excluded := false
+	for _, r := range e.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		name := rel
+		if !r.anchored {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(r.pattern, name); ok {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// excludedPath is like excluded but also checks every parent directory of
+// rel.  It's for when paths aren't found by walking down from the root, such
+// as the entries in a tar.
+func (e *excluder) excludedPath(rel string, isDir bool) bool {
+	elems := strings.Split(rel, "/")
+	for i := 1; i < len(elems); i++ {
+		if e.excluded(path.Join(elems[:i]...), true) {
+			return true
+		}
+	}
+	return e.excluded(rel, isDir)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// uploadJob is a single local file to upload into a ShareBase folder.
+type uploadJob struct {
+	// source is the path to the local file.
+	source string
+
+	// size is the size of the local file.
+	size int64
+
+	// folder is a copy of the target folder's state so that workers
+	// don't read the tree while it's being updated.
+	folder web.Folder
+
+	// target is the full ShareBase path of the new document.
+	target Path
+
+	// parent is the target folder in the tree.  Workers must not use
+	// it; it's only for adding the new document to the tree after
+	// they're done.
+	parent *Folder
+
+	// replaces is a copy of the existing document that the new document
+	// replaces, if any.  It's deleted after the new document is
+	// uploaded so that both aren't left in the folder with the same
+	// name.
+	replaces *web.Document
+
+	// replaced is replaces' document in the tree.  Like parent, workers
+	// must not use it.
+	replaced *Document
+}
+
+// uploaded is a document created by an uploader's worker.
+type uploaded struct {
+	parent *Folder
+	doc    web.Document
+
+	// copied is set when the document was copied with -dedupe instead
+	// of uploaded.
+	copied bool
+
+	// replaced is the document that doc replaced, if any.  It's
+	// removed from the tree before doc is added.
+	replaced *Document
+}
+
+// uploader uploads local files into ShareBase with a bounded number of
+// workers that each borrow their own client from the state's ClientPool.
+// The first error from any worker cancels the other uploads, including the
+// ones already in progress.
+type uploader struct {
+	s      *state
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan uploadJob
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+
+	// mutex protects added.
+	mutex sync.Mutex
+	added []uploaded
+
+	// dedupe is only set with -dedupe.
+	dedupe *dedupeIndex
+}
+
+// newUploader starts an uploader with n workers.  n values less than 1 are
+// treated as 1.
+func (s *state) newUploader(ctx context.Context, n int) *uploader {
+	if n < 1 {
+		n = 1
+	}
+	u := &uploader{
+		s:    s,
+		jobs: make(chan uploadJob),
+	}
+	if s.Dedupe {
+		u.dedupe = newDedupeIndex()
+	}
+	u.ctx, u.cancel = context.WithCancel(ctx)
+	u.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go u.work()
+	}
+	return u
+}
+
+// schedule waits for a worker to take the job.  If a previous upload failed,
+// the job is dropped and the context's error is returned.
+func (u *uploader) schedule(j uploadJob) error {
+	select {
+	case u.jobs <- j:
+		return nil
+	case <-u.ctx.Done():
+		return u.ctx.Err()
+	}
+}
+
+// wait stops accepting jobs, waits for the workers to finish, and returns the
+// first error from any of them.  The documents that were uploaded are added
+// to the tree, even if an error occurred.  With -dedupe, the number of
+// bytes saved by copying documents instead of uploading them is written to
+// stdout.
+func (u *uploader) wait() error {
+	close(u.jobs)
+	u.wg.Wait()
+	u.cancel()
+	for _, a := range u.added {
+		if a.replaced != nil {
+			u.s.Root.remove(a.replaced)
+		}
+		u.s.Root.addDocument(a.parent, a.doc)
+	}
+	if u.err != nil {
+		return u.err
+	}
+	return u.dedupe.report(os.Stdout)
+}
+
+func (u *uploader) work() {
+	defer u.wg.Done()
+	for j := range u.jobs {
+		if u.ctx.Err() != nil {
+			// drain the rest after the first failure.
+			continue
+		}
+		if err := u.upload(j); err != nil {
+			u.once.Do(func() {
+				u.err = err
+				u.cancel()
+			})
+		}
+	}
+}
+
+func (u *uploader) upload(j uploadJob) (err error) {
+	c, err := u.s.ClientPool.ClientContext(
+		u.ctx, u.s.Config.DataCenter, u.s.Config.Token)
+	if err != nil {
+		return err
+	}
+	defer u.s.ClientPool.Cache(c)
+	var sum string
+	if u.dedupe != nil {
+		h, err := sha1File(j.source)
+		if err != nil {
+			return err
+		}
+		sum = getHex(h)
+		if ok, err := u.copyDuplicate(c, j, sum); err != nil || ok {
+			return err
+		}
+	}
+	u.s.status.printf("copying %v to %v...", j.source, j.target)
+	file, err := os.Open(j.source)
+	if err != nil {
+		return err
+	}
+	defer errors.WrapDeferred(&err, file.Close)
+	d, err := u.s.uploadDocument(
+		u.ctx, c, file, j.size, j.folder, j.target)
+	if err != nil || u.s.DryRun {
+		return err
+	}
+	u.dedupe.add(sum, d)
+	if err = u.replace(c, j); err != nil {
+		return err
+	}
+	u.add(uploaded{parent: j.parent, doc: d, replaced: j.replaced})
+	return nil
+}
+
+// replace deletes the document that j's new document replaces, if any.
+func (u *uploader) replace(c *web.Client, j uploadJob) error {
+	if j.replaces == nil {
+		return nil
+	}
+	if err := j.replaces.Delete(c); err != nil {
+		return errors.ErrorfWithCause(
+			err, "uploaded %v but failed to delete the document "+
+				"it replaces (ID: %d): %v",
+			j.target, j.replaces.DocumentID, err)
+	}
+	return nil
+}
+
+func (u *uploader) add(a uploaded) {
+	u.mutex.Lock()
+	u.added = append(u.added, a)
+	u.mutex.Unlock()
+}
+
+// uploads gets how many documents were uploaded, not counting the ones that
+// were copied with -dedupe.  It's only accurate after wait.
+func (u *uploader) uploads() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	n := 0
+	for _, a := range u.added {
+		if !a.copied {
+			n++
+		}
+	}
+	return n
+}
+
+// copyDuplicate copies a document that already has the content of j's file
+// (whose SHA-1 hash is sum) into j's folder.  ok is false if there's no such
+// document or ShareBase can't copy it, so the file has to be uploaded.
+func (u *uploader) copyDuplicate(c *web.Client, j uploadJob, sum string) (ok bool, err error) {
+	orig, found := u.dedupe.lookup(sum, j.size)
+	if !found {
+		return false, nil
+	}
+	if u.s.DryRun {
+		u.s.status.printf(
+			"dry run: would copy %v to %v instead of uploading %v",
+			orig, j.target, j.source)
+		u.dedupe.copied(j.size)
+		return true, nil
+	}
+	u.s.status.printf(
+		"copying %v to %v instead of uploading %v...",
+		orig, j.target, j.source)
+	d, err := orig.CopyOnServer(c, &j.folder, Basename(j.target))
+	if err == web.ErrServerCopyUnsupported {
+		if !u.dedupe.setUnsupported() {
+			logger.Warn(
+				"ShareBase doesn't support server-side copies, " +
+					"so duplicate files will be uploaded")
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = fmt.Fprintf(
+		os.Stdout, "%v\tID: %d\n", j.target, d.DocumentID); err != nil {
+		return false, err
+	}
+	u.dedupe.copied(j.size)
+	if err = u.replace(c, j); err != nil {
+		return false, err
+	}
+	u.add(uploaded{parent: j.parent, doc: d, copied: true, replaced: j.replaced})
+	return true, nil
+}
+
+// downloadErrors are the errors from downloadDocuments when some of its
+// downloads failed.
+type downloadErrors struct {
+	total int
+	errs  []error
+}
+
+// exitCode gets the exit code that all of the failed downloads have in
+// common or exitError if they failed for different reasons.
+func (err downloadErrors) exitCode() int {
+	code := exitError
+	for i, e := range err.errs {
+		c := exitCode(e)
+		if i > 0 && c != code {
+			return exitError
+		}
+		code = c
+	}
+	return code
+}
+
+func (err downloadErrors) Error() string {
+	msgs := make([]string, len(err.errs))
+	for i, e := range err.errs {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf(
+		"%d of %d downloads failed:\n\t%s",
+		len(err.errs), err.total, strings.Join(msgs, "\n\t"))
+}
+
+// downloadDocuments downloads docs into the local directory dir, which is
+// created if it doesn't exist.  Files are named from their content's
+// Content-Disposition headers (or the documents' names with -continue).  Up
+// to s.Jobs documents are downloaded at the same time, each with a client
+// borrowed from the state's ClientPool.
+//
+// Unlike the uploader, a failed download doesn't stop the others.  All of
+// the documents are attempted and the errors are returned together as a
+// downloadErrors.  The tree must not be changed until downloadDocuments
+// returns.
+func (s *state) downloadDocuments(ctx context.Context, docs []*Document, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to create target directory %q: %v", dir, err)
+	}
+	n := s.Jobs
+	if n > len(docs) {
+		n = len(docs)
+	}
+	if n < 1 {
+		n = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+		// names are the files claimed so far so that two documents
+		// with the same filename don't overwrite each other.
+		names = make(map[string]*Document, len(docs))
+	)
+	claim := func(d *Document, name string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if other, ok := names[name]; ok {
+			return errors.Errorf(
+				"%v and %v would both be written to %v",
+				PathOf(other), PathOf(d), name)
+		}
+		names[name] = d
+		return nil
+	}
+	jobs := make(chan *Document)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				if err := s.downloadDocument(ctx, d, dir, claim); err != nil {
+					mutex.Lock()
+					errs = append(errs, err)
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, d := range docs {
+		jobs <- d
+	}
+	close(jobs)
+	wg.Wait()
+	if len(errs) > 0 {
+		return downloadErrors{total: len(docs), errs: errs}
+	}
+	return nil
+}
+
+// downloadDocument downloads d into dir for downloadDocuments.  claim is
+// called with the file's path before anything is written to it.
+func (s *state) downloadDocument(ctx context.Context, d *Document, dir string, claim func(d *Document, name string) error) (err error) {
+	c, err := s.ClientPool.ClientContext(
+		ctx, s.Config.DataCenter, s.Config.Token)
+	if err != nil {
+		return err
+	}
+	defer s.ClientPool.Cache(c)
+	if s.Continue {
+		name := filepath.Join(dir, d.Name())
+		if err = claim(d, name); err != nil {
+			return err
+		}
+		var target *os.File
+		if target, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0666); err != nil {
+			return err
+		}
+		defer errors.WrapDeferred(&err, target.Close)
+		return s.resumeFileToLocalFile(c, d, target)
+	}
+	content, err := d.Document.Content(c)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get content of %v: %v", PathOf(d), err)
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	name := filepath.Join(dir, content.Filename())
+	if err = claim(d, name); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !s.Overwrite {
+		flags |= os.O_EXCL
+	}
+	target, err := os.OpenFile(name, flags, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return TargetExists{Path: name}
+		}
+		return err
+	}
+	defer errors.WrapDeferred(&err, target.Close)
+	return s.shareBaseFileToLocalFile(c, d, content, target)
+}
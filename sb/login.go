@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+	"golang.org/x/term"
+)
+
+// loginCommand is the -x command that creates a token and saves it in the
+// configuration file.  Unlike the other commands, it doesn't take a ShareBase
+// target.
+const loginCommand = "login"
+
+// logoutCommand is the -x command that invalidates the token and removes it
+// from the configuration file.  Like login, it doesn't take a target.
+const logoutCommand = "logout"
+
+// login creates a token for the configured username and saves it into the
+// configuration file, removing the password from the file.  The username and
+// password are prompted for if they're not configured.  Only the token and
+// password in the file are changed, so settings from the environment aren't
+// saved into it.
+func (s *state) login() (err error) {
+	if s.Config.Username == "" {
+		if s.Config.Username, err = promptLine("Username: "); err != nil {
+			return err
+		}
+	}
+	password := s.Config.Password
+	if password == "" {
+		if password, err = promptPassword(s.Config.Username); err != nil {
+			return err
+		}
+	}
+	tok, err := web.AuthTokenForUsernameAndPassword(
+		s.Config.DataCenter, s.Config.Username, password)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err,
+			"failed to create ShareBase authorization token for "+
+				"username: %v: %v",
+			s.Config.Username, err)
+	}
+	s.Config.Token = tok.Token
+	s.Config.Password = ""
+	// like in logout, the file is loaded again without the environment.
+	var fc Config
+	if err = loadJSONConfig(s.ConfigFilename, &fc); err != nil {
+		if _, statErr := os.Stat(s.ConfigFilename); !os.IsNotExist(statErr) {
+			return err
+		}
+	}
+	fc.Token = tok.Token
+	fc.Password = ""
+	if err = saveJSONConfig(s.ConfigFilename, &fc); err != nil {
+		return err
+	}
+	logger.Info1("saved token to %v", s.ConfigFilename)
+	return nil
+}
+
+// logout invalidates the configured token in ShareBase and then removes it
+// from the configuration file, if that's where it came from.  A token from
+// SHAREBASE_TOKEN is invalidated, but the variable has to be unset
+// separately.
+func (s *state) logout() error {
+	if s.Config.Token == "" {
+		return errors.Errorf("not logged in: there's no token to invalidate")
+	}
+	c, err := web.NewClient(s.Config.DataCenter, s.Config.Token)
+	if err != nil {
+		return err
+	}
+	if err = c.Logout(); err != nil {
+		return err
+	}
+	if s.ConfigFilename == stdinConfigFilename {
+		// there's no file to remove the token from.
+		return nil
+	}
+	// the file is loaded again without the environment so that none of
+	// the environment's settings are written into it.
+	var fc Config
+	if err = loadJSONConfig(s.ConfigFilename, &fc); err != nil {
+		if _, statErr := os.Stat(s.ConfigFilename); os.IsNotExist(statErr) {
+			return nil
+		}
+		return err
+	}
+	if fc.Token != s.Config.Token {
+		return nil
+	}
+	fc.Token = ""
+	if err = saveJSONConfig(s.ConfigFilename, &fc); err != nil {
+		return err
+	}
+	logger.Info1("removed token from %v", s.ConfigFilename)
+	return nil
+}
+
+// promptLine writes prompt to stderr and reads a line from stdin.
+func promptLine(prompt string) (string, error) {
+	if _, err := fmt.Fprint(os.Stderr, prompt); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.ErrorfWithCause(
+			err, "failed to read %q: %v", strings.TrimSpace(prompt), err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptPassword reads username's password from the terminal without echoing
+// it.
+func promptPassword(username string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.Errorf(
+			"cannot prompt for %v's password because stdin is not "+
+				"a terminal", username)
+	}
+	if _, err := fmt.Fprintf(os.Stderr, "Password for %v: ", username); err != nil {
+		return "", err
+	}
+	password, err := term.ReadPassword(fd)
+	// the newline after the password wasn't echoed either.
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", errors.ErrorfWithCause(
+			err, "failed to read password: %v", err)
+	}
+	return string(password), nil
+}
+
+// saveJSONConfig writes the configuration to filename.  The file is created
+// readable only by the current user because it holds the token.
+func saveJSONConfig(filename string, c *Config) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to format JSON configuration: %v", err)
+	}
+	if err = ioutil.WriteFile(filename, append(data, '\n'), 0600); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to write JSON configuration file: %v", err)
+	}
+	return nil
+}
@@ -3,18 +3,23 @@ package main
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"net/http"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
-	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/skillian/sharebase/web"
 
@@ -48,12 +53,12 @@ func init() {
 func main() {
 	s := state{}
 
-	var configFilename, logLevelString string
+	var logLevelString string
 
 	flag.StringVar(
-		&configFilename, "c",
+		&s.ConfigFilename, "c",
 		filepath.Join(my.HomeDir, defaultConfigFilename),
-		"ShareBase configuration file")
+		"ShareBase configuration file, or - to read it from stdin")
 
 	flag.StringVar(
 		&logLevelString, "l", "",
@@ -71,10 +76,182 @@ func main() {
 			"ShareBase (useful if the source is coming from a "+
 			"stream).")
 
+	const overwriteUsage = "Overwrite existing local targets.  An existing " +
+		"target file is replaced.  An existing target directory is " +
+		"copied into, replacing files with the same names as the " +
+		"source documents."
+
+	flag.BoolVar(&s.Overwrite, "f", false, overwriteUsage)
+
+	flag.BoolVar(&s.Overwrite, "overwrite", false, overwriteUsage)
+
+	const dryRunUsage = "Show the uploads and downloads that would be done " +
+		"without actually doing them or creating any folders or files."
+
+	flag.BoolVar(&s.DryRun, "n", false, dryRunUsage)
+
+	flag.BoolVar(&s.DryRun, "dry-run", false, dryRunUsage)
+
+	flag.BoolVar(
+		&s.Quiet, "q", false,
+		"Don't write which files are being copied or deleted to "+
+			"stderr.  Warnings and errors are still logged.")
+
+	flag.BoolVar(
+		&s.Verbose, "v", false,
+		"Also write which files are excluded, resumed, or already "+
+			"complete to stderr.")
+
+	const jobsUsage = "Number of files to upload at the same time when " +
+		"uploading a directory or to download at the same time " +
+		"when downloading several documents."
+
+	flag.IntVar(&s.Jobs, "j", 1, jobsUsage)
+
+	flag.IntVar(&s.Jobs, "jobs", 1, jobsUsage)
+
+	flag.Var(
+		&s.Exclude, "exclude",
+		"Glob pattern of files and directories not to upload from a "+
+			"directory or tar, relative to its root.  Can be "+
+			"specified multiple times and is added to the "+
+			"patterns in the root's "+ignoreFilename+" file.")
+
+	flag.BoolVar(
+		&s.Mirror, "mirror", false,
+		"Make the sync command delete documents and folders in "+
+			"ShareBase that don't exist in the local directory "+
+			"(excluded files are never deleted).  The deletions "+
+			"must be confirmed unless -y is also given.")
+
+	const yesUsage = "Don't ask for confirmation before deleting or " +
+		"overwriting anything.  Without a terminal to ask on, " +
+		"nothing is deleted or overwritten unless this is given."
+
+	flag.BoolVar(&s.Yes, "y", false, yesUsage)
+
+	flag.BoolVar(&s.Yes, "yes", false, yesUsage)
+
+	flag.BoolVar(&s.Yes, "force", false, "The same as -y.")
+
+	flag.BoolVar(
+		&s.Checksum, "checksum", false,
+		"Make the sync command compare the hashes of files whose "+
+			"sizes and modification times match their documents.")
+
+	flag.BoolVar(
+		&s.NoMtime, "no-mtime", false,
+		"Leave downloaded files' modification times as the time they "+
+			"were downloaded instead of setting them to the "+
+			"documents' modification times in ShareBase.")
+
+	flag.BoolVar(
+		&s.Verify, "verify", false,
+		"Check the SHA-1 hashes of downloaded files against the "+
+			"hashes that ShareBase reports for their documents.")
+
+	flag.BoolVar(
+		&s.Continue, "continue", false,
+		"Resume downloading documents into existing local files that "+
+			"were only partially downloaded by only getting the "+
+			"rest of their content.")
+
+	flag.BoolVar(
+		&s.Dedupe, "dedupe", false,
+		"Before uploading each file, look for a document with the "+
+			"same SHA-1 hash in the folders being uploaded into "+
+			"(or uploaded earlier in the same run) and copy it "+
+			"server-side instead of uploading the file again.")
+
+	flag.Var(
+		&s.Fields, "field",
+		"An index field value to create uploaded documents with, as "+
+			"name=value.  Repeat it to set more than one field.  "+
+			"Uploads into libraries with document types fail "+
+			"early if their required fields aren't given.")
+
+	flag.BoolVar(
+		&s.Preserve, "preserve", false,
+		"Save uploaded files' modes and modification times in their "+
+			"documents' index fields and restore them when the "+
+			"documents are downloaded.")
+
+	flag.StringVar(
+		&s.Batch, "batch", "",
+		"Read source and target pairs from a file (or \"-\" for "+
+			"stdin), one pair per line, and transfer them in "+
+			"order instead of a single source and target.")
+
 	flag.BoolVar(
 		&s.Exec, "x", false,
 		"The [source] parameter is a command to execute instead of "+
-			"a source file/directory.")
+			"a source file/directory.  \"-x login\" creates a "+
+			"token for the configured username and saves it in "+
+			"the configuration file in place of the password.  "+
+			"\"-x logout\" invalidates the token and removes it "+
+			"from the configuration file.")
+
+	flag.BoolVar(
+		&s.JSON, "json", false,
+		"Write command output such as from ls as newline-delimited "+
+			"JSON objects.")
+
+	flag.BoolVar(
+		&s.Human, "h", false,
+		"Write sizes in human-readable units (e.g. 4.2M) instead of "+
+			"bytes.")
+
+	flag.BoolVar(
+		&s.CreateLibraries, "create-library", false,
+		"Create the target library if it doesn't exist when creating "+
+			"folders (e.g. with mkdir, sync, or uploading a "+
+			"directory).")
+
+	flag.BoolVar(
+		&s.Recursive, "r", false,
+		"Allow the rm command to delete folders and libraries that "+
+			"aren't empty.")
+
+	flag.Var(
+		&s.LimitRate, "limit-rate",
+		"Maximum bytes per second to upload or download (e.g. 500K "+
+			"or 2M).  The limit is shared by all of the transfers, "+
+			"so uploading with -j 4 -limit-rate 1M uploads 1M per "+
+			"second in total, not 1M per second per file.")
+
+	flag.Var(
+		&s.SmallFileCutoff, "small-file-cutoff",
+		"Upload files smaller than this size (e.g. 1M or 20M) with "+
+			"ShareBase's small file upload method and larger ones "+
+			"with its large file upload method.  The default is "+
+			web.SmallFileCutoff.Human()+".")
+
+	flag.IntVar(
+		&s.TreeDepth, "L", 0,
+		"How many levels of folders the tree command descends into "+
+			"(the default, 0, means there's no limit).")
+
+	flag.BoolVar(
+		&s.DirsOnly, "d", false,
+		"Make the tree command only list folders.")
+
+	flag.BoolVar(
+		&s.IgnoreCase, "i", false,
+		"Match ShareBase paths' names regardless of case, so "+
+			"sb:my/documents finds \"Documents\".  A name that "+
+			"matches more than one child that way is an error.")
+
+	flag.DurationVar(
+		&s.CacheTTL, "cache-ttl", 0,
+		"How long folders' contents are trusted after they're "+
+			"retrieved before looking for a missing document or "+
+			"folder retrieves them again (the default, 0, always "+
+			"retrieves them again).")
+
+	flag.DurationVar(
+		&s.ShareExpiration, "expire", 0,
+		"How long a link created by the share command works for "+
+			"(the default, 0, means the link never expires).")
 
 	flag.Usage = func() {
 		defaultUsage()
@@ -82,7 +259,8 @@ func main() {
 Positional parameters:
   [source] string
         The source file to read from.  Can be either a ShareBase URL or a local
-        path.
+        path.  Several ShareBase sources can be given to copy all of them
+        into the target directory.
   [target] string
         The target file to write to.  Can be either a ShareBase URL or a local
 	path.
@@ -95,18 +273,39 @@ Positional parameters:
 
 	switch len(args) {
 	case 0:
-		die(errors.Errorf("Source must be specified"))
+		if s.Batch == "" {
+			die(errors.Errorf("Source must be specified"))
+		}
 	case 1:
 		s.Source = args[0]
 		s.Target = path.Base(s.Source)
+		if isGlob(s.Target) {
+			// copy the matches into the current directory.
+			s.Target = "."
+		}
 	case 2:
 		s.Source = args[0]
 		s.Target = args[1]
 	default:
-		die(errors.Errorf("Too many arguments specified!"))
+		if s.Exec {
+			// commands can take their own arguments after the
+			// target.
+			s.Source = args[0]
+			s.Target = args[1]
+			s.Args = args[2:]
+		} else if sources := args[:len(args)-1]; allShareBaseLocs(sources) {
+			// several ShareBase sources are all copied into
+			// the target directory.
+			s.Source = args[0]
+			s.Sources = sources
+			s.Target = args[len(args)-1]
+		} else {
+			die(errors.Errorf("Too many arguments specified!"))
+		}
 	}
 
-	dieOnError(loadJSONConfig(configFilename, &s.Config))
+	dieOnError(s.checkConfigStdin())
+	dieOnError(loadConfig(s.ConfigFilename, &s.Config))
 
 	if level, ok := logging.ParseLevel(logLevelString); ok {
 		logger.SetLevel(level)
@@ -121,6 +320,14 @@ type Config struct {
 	Username   string `json:"username"`
 	Password   string `json:"password"`
 	Token      string `json:"token"`
+
+	// PersonalLibrary is the name of the library that paths starting
+	// with "sb:my/" refer to.  It defaults to "My Library".
+	PersonalLibrary string `json:"personalLibrary"`
+
+	// LibraryAliases maps other shorthand names that can start paths
+	// to the libraries they refer to (e.g. "it": "IT Library").
+	LibraryAliases map[string]string `json:"libraryAliases"`
 }
 
 type state struct {
@@ -128,14 +335,131 @@ type state struct {
 	*Root
 	Config
 
+	// ConfigFilename is where Config was loaded from.
+	ConfigFilename string
+
 	Overwrite bool
 
+	// DryRun reports the transfers that would happen without doing them.
+	DryRun bool
+
+	// Quiet silences status.
+	Quiet bool
+
+	// Verbose adds the less important messages to status.
+	Verbose bool
+
+	// status writes which files are being transferred, separately from
+	// the logger.
+	status *statusWriter
+
+	// Jobs is the number of files uploaded concurrently.
+	Jobs int
+
+	// Exclude holds patterns of local files not to upload.
+	Exclude stringsFlag
+
+	// Mirror makes sync delete the documents and folders in ShareBase
+	// that don't exist locally.
+	Mirror bool
+
+	// Yes skips the confirmations before deleting or overwriting
+	// anything.
+	Yes bool
+
+	// Checksum makes sync compare the hashes of files that otherwise
+	// look unchanged.
+	Checksum bool
+
+	// Continue resumes partial downloads into existing local files.
+	Continue bool
+
+	// Dedupe copies documents that already have a file's content
+	// instead of uploading the file.
+	Dedupe bool
+
+	// Fields are the index field values that uploaded documents are
+	// created with.
+	Fields fieldsFlag
+
+	// Preserve saves local files' modes and modification times in
+	// their documents' index fields and restores them when they're
+	// downloaded.
+	Preserve bool
+
+	// fieldsWarning warns once when -preserve can't use index fields.
+	fieldsWarning sync.Once
+
+	// NoMtime keeps downloaded files' modification times as the time
+	// they were downloaded instead of the documents' modification times.
+	NoMtime bool
+
+	// Verify checks downloaded files against the hashes that ShareBase
+	// reports for their documents.
+	Verify bool
+
 	Source string
 	Target string
 
+	// Sources are all of the sources when more than one ShareBase
+	// source is given.  Source is the first of them.
+	Sources []string
+
+	// Args holds any additional arguments passed to a command.
+	Args []string
+
+	// Batch is the file with the source and target pairs to transfer
+	// instead of Source and Target.  "-" reads them from stdin.
+	Batch string
+
 	Tar   bool
 	Untar bool
 	Exec  bool
+
+	// JSON selects newline-delimited JSON output for commands that
+	// list objects.
+	JSON bool
+
+	// Human formats sizes in human-readable units instead of bytes.
+	Human bool
+
+	// Recursive allows commands like rm to operate on folders and
+	// everything in them.
+	Recursive bool
+
+	// ShareExpiration is how long links created by the share command
+	// last.
+	ShareExpiration time.Duration
+
+	// TreeDepth limits how deep the tree command goes.  0 means there's
+	// no limit.
+	TreeDepth int
+
+	// DirsOnly makes the tree command leave out documents.
+	DirsOnly bool
+
+	// IgnoreCase sets the Root's IgnoreCase.
+	IgnoreCase bool
+
+	// CacheTTL is the Root's TTL.
+	CacheTTL time.Duration
+
+	// CreateLibraries sets the Root's CreateLibraries.
+	CreateLibraries bool
+
+	// LimitRate is the maximum bytes per second of all of the uploads
+	// and downloads together.  0 means there's no limit.
+	LimitRate sizeFlag
+
+	// limiter enforces LimitRate when it's set.
+	limiter *web.RateLimiter
+
+	// SmallFileCutoff is the size under which uploads use ShareBase's
+	// small file upload method.  0 means web.SmallFileCutoff.
+	SmallFileCutoff sizeFlag
+
+	// summary counts what a transfer copied.  It's nil for commands.
+	summary *transferSummary
 }
 
 func (s *state) client() (*web.Client, error) {
@@ -144,12 +468,24 @@ func (s *state) client() (*web.Client, error) {
 }
 
 func (s *state) init() error {
-	if s.Config.Username != "" {
+	if s.Quiet && s.Verbose {
+		return errors.Errorf("-q and -v can't be used together")
+	}
+	s.status = newStatusWriter(os.Stderr, s.Quiet, s.Verbose)
+	setLibraryAliases(s.Config)
+	if s.Config.Username != "" && s.Config.Token == "" {
 		logger.Debug1(
 			"Config w/ username %q specified.  Creating token...",
 			s.Config.Username)
+		password := s.Config.Password
+		if password == "" {
+			var err error
+			if password, err = promptPassword(s.Config.Username); err != nil {
+				return err
+			}
+		}
 		tok, err := web.AuthTokenForUsernameAndPassword(
-			s.Config.DataCenter, s.Username, s.Password)
+			s.Config.DataCenter, s.Username, password)
 		if err != nil {
 			return errors.ErrorfWithCause(
 				err,
@@ -162,12 +498,33 @@ func (s *state) init() error {
 			s.Config.Username, tok.Token)
 		s.Config.Token = tok.Token
 	}
+	if s.LimitRate > 0 {
+		var err error
+		if s.limiter, err = web.NewRateLimiter(web.Size(s.LimitRate)); err != nil {
+			return err
+		}
+	}
 	s.ClientPool = web.NewClientPool()
 	s.Root = NewRoot()
+	s.Root.DryRun = s.DryRun
+	s.Root.TTL = s.CacheTTL
+	s.Root.IgnoreCase = s.IgnoreCase
+	s.Root.Prefetch = web.MaxConcurrentFolderListings
+	s.Root.CreateLibraries = s.CreateLibraries
 	return nil
 }
 
 func (s *state) execute() error {
+	if s.Batch != "" && (s.Exec || s.Source != "") {
+		return errors.Errorf(
+			"-batch doesn't take a source, target, or command")
+	}
+	if s.Exec && strings.EqualFold(s.Source, loginCommand) {
+		return s.login()
+	}
+	if s.Exec && strings.EqualFold(s.Source, logoutCommand) {
+		return s.logout()
+	}
 	var err error
 	if err = s.init(); err != nil {
 		return err
@@ -177,19 +534,61 @@ func (s *state) execute() error {
 		return err
 	}
 	if s.Exec {
+		if fn, ok := clientCommands[strings.ToLower(s.Source)]; ok {
+			return fn(s, c)
+		}
 		if !isShareBaseLoc(s.Target) {
 			return errors.Errorf(
 				"commands must execute on ShareBase objects.")
 		}
 		p := ShareBasePathFromString(s.Target)
-		o, err := s.Root.ObjectByPath(c, nil, p)
-		if err != nil {
-			return errors.ErrorfWithCause(
-				err,
-				"failed to get %v", p)
+		if fn, ok := pathCommands[strings.ToLower(s.Source)]; ok {
+			return fn(s, c, p)
+		}
+		var obs []Object
+		if HasGlob(p) {
+			if obs, err = s.Root.ObjectsByPath(c, nil, p); err != nil {
+				return errors.ErrorfWithCause(
+					err,
+					"no objects match %v: %v", p, err)
+			}
+		} else {
+			o, err := s.Root.ObjectByPath(c, nil, p)
+			if err != nil {
+				return errors.ErrorfWithCause(
+					err,
+					"failed to get %v", p)
+			}
+			obs = []Object{o}
+		}
+		if strings.EqualFold(s.Source, "rm") {
+			// everything that matched is confirmed at once.
+			if err = s.confirmDeletes(obs, s.Recursive); err != nil {
+				return err
+			}
+		}
+		for _, o := range obs {
+			if err = s.execCommand(c, o); err != nil {
+				return err
+			}
 		}
-		return s.execCommand(c, o)
+		return nil
+	}
+	s.summary = newTransferSummary(s.ClientPool)
+	if s.Batch != "" {
+		err = s.runBatch(c)
+	} else {
+		err = s.transfer(c)
+	}
+	if err != nil {
+		return err
 	}
+	s.reportSummary()
+	return nil
+}
+
+// transfer copies s.Source (or s.Sources) to s.Target.
+func (s *state) transfer(c *web.Client) error {
 	if isShareBaseLoc(s.Source) {
 		if isShareBaseLoc(s.Target) {
 			return errors.Errorf(
@@ -199,7 +598,14 @@ func (s *state) execute() error {
 			return errors.Errorf(
 				"cannot untar from ShareBase source.")
 		}
-		p, base, err := s.Root.ParentByPath(c, nil, ShareBasePathFromString(s.Source))
+		if len(s.Sources) > 1 {
+			return s.shareBasePathsToLocal(c, s.Sources)
+		}
+		path := ShareBasePathFromString(s.Source)
+		if HasGlob(path) {
+			return s.shareBaseGlobToLocal(c, path)
+		}
+		p, base, err := s.Root.ParentByPath(c, nil, path)
 		if err != nil {
 			return err
 		}
@@ -235,8 +641,180 @@ func (s *state) execCommand(c *web.Client, o Object) error {
 }
 
 var commands = map[string]func(s *state, c *web.Client, o Object) error{
-	"hash": (*state).hashDocument,
-	"ls":   (*state).listDirectory,
+	"du":       (*state).diskUsage,
+	"export":   (*state).exportTree,
+	"hash":     (*state).hashDocument,
+	"find":     (*state).findDocuments,
+	"ls":       (*state).listDirectory,
+	"manifest": (*state).writeManifest,
+	"rm":       (*state).removeObject,
+	"share":    (*state).shareObject,
+	"stat":     (*state).statObject,
+	"tree":     (*state).showTree,
+	"verify":   (*state).verifyManifest,
+}
+
+// pathCommands are commands that operate on a ShareBase path that might not
+// exist yet instead of on an existing object.
+var pathCommands = map[string]func(s *state, c *web.Client, p ShareBasePath) error{
+	"mkdir": (*state).makeDirectory,
+	"sync":  (*state).syncDirectory,
+}
+
+// clientCommands are commands that don't operate on anything in ShareBase, so
+// they don't take a target.
+var clientCommands = map[string]func(s *state, c *web.Client) error{
+	"whoami": (*state).whoami,
+}
+
+// whoami writes the username, user ID, and token expiration of the user that
+// the client is authenticated as.
+func (s *state) whoami(c *web.Client) error {
+	tok, err := c.Whoami()
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get the authenticated user: %v", err)
+	}
+	if s.JSON {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			UserName       string    `json:"userName"`
+			UserID         int       `json:"userId"`
+			ExpirationDate time.Time `json:"expirationDate"`
+		}{tok.UserName, tok.UserID, tok.ExpirationDate})
+	}
+	_, err = fmt.Fprintf(
+		os.Stdout, "%v\tID: %d\texpires: %v\n",
+		tok.UserName, tok.UserID, tok.ExpirationDate.Format(time.RFC3339))
+	return err
+}
+
+// makeDirectory creates the folder at path p along with any missing parent
+// folders and writes its path to stdout.  If the folder already exists,
+// nothing is written.
+func (s *state) makeDirectory(c *web.Client, p ShareBasePath) error {
+	if p.Len() < 2 {
+		return errors.Errorf(
+			"mkdir requires a library and folder path, not %v", p)
+	}
+	o, err := s.Root.ObjectByPath(c, s.Root, p)
+	if err == nil {
+		if _, ok := o.(*Folder); !ok {
+			return errors.Errorf(
+				"%v already exists but is a %T", p, o)
+		}
+		return nil
+	}
+	f, err := s.Root.GetOrCreateFolder(c, s.Root, p)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to create folder %v: %v", p, err)
+	}
+	_, err = fmt.Fprintln(os.Stdout, PathOf(f))
+	return err
+}
+
+// removeObject deletes a document or folder from ShareBase.  Folders that
+// aren't empty are only deleted if s.Recursive is set.
+func (s *state) removeObject(c *web.Client, o Object) error {
+	var err error
+	switch o := o.(type) {
+	case *Document:
+		err = o.Document.Delete(c)
+	case *Folder:
+		if err = o.update(s.Root, c); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to update %v", PathOf(o))
+		}
+		if len(o.Children()) > 0 && !s.Recursive {
+			return errors.Errorf(
+				"refusing to delete non-empty folder %v "+
+					"without -r", PathOf(o))
+		}
+		err = o.Folder.Delete(c)
+	case *Library:
+		if err = s.confirmLibraryDelete(o); err != nil {
+			return err
+		}
+		err = c.DeleteLibrary(o.ID(), s.Recursive)
+		if _, ok := err.(web.NotEmpty); ok {
+			return errors.Errorf(
+				"refusing to delete non-empty library %v "+
+					"without -r", PathOf(o))
+		}
+	default:
+		return errors.Errorf("cannot remove %T", o)
+	}
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to delete %v: %v", PathOf(o), err)
+	}
+	s.Root.remove(o)
+	return nil
+}
+
+// findDocuments searches a library for documents matching the command's
+// arguments and writes their paths to stdout.
+func (s *state) findDocuments(c *web.Client, o Object) error {
+	lib, ok := o.(*Library)
+	if !ok {
+		return errors.Errorf(
+			"find must search a library, not %T", o)
+	}
+	if len(s.Args) == 0 {
+		return errors.Errorf("find requires a search query")
+	}
+	query := strings.Join(s.Args, " ")
+	wds, err := lib.Library.Search(c, query)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to search %v for %q: %v",
+			PathOf(lib), query, err)
+	}
+	for _, wd := range wds {
+		f, err := s.Root.FolderByID(c, lib, wd.FolderID)
+		if err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to locate folder of %v", wd)
+		}
+		p := PathOf(f).Join(wd.DocumentName)
+		if _, err = fmt.Fprintln(os.Stdout, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareObject creates a public share link to a document or folder and
+// writes it to stdout.
+func (s *state) shareObject(c *web.Client, o Object) error {
+	opts := web.ShareOptions{}
+	if s.ShareExpiration > 0 {
+		expires := time.Now().Add(s.ShareExpiration)
+		opts.ExpirationDate = &expires
+	}
+	var share web.Share
+	var err error
+	switch o := o.(type) {
+	case *Document:
+		share, err = o.Document.CreateShare(c, opts)
+	case *Folder:
+		share, err = o.Folder.CreateShare(c, opts)
+	default:
+		return errors.Errorf(
+			"only documents and folders can be shared, not %T", o)
+	}
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to share %v: %v", PathOf(o), err)
+	}
+	if share.ExpirationDate.IsZero() {
+		_, err = fmt.Fprintln(os.Stdout, share.URL)
+	} else {
+		_, err = fmt.Fprintf(
+			os.Stdout, "%s\texpires: %s\n",
+			share.URL, share.ExpirationDate.Format(time.RFC3339))
+	}
+	return err
 }
 
 func (s *state) hashDocument(c *web.Client, o Object) error {
@@ -245,74 +823,221 @@ func (s *state) hashDocument(c *web.Client, o Object) error {
 		return errors.Errorf(
 			"only %T can be hashed, not %T", d, o)
 	}
-	return writeObjectToList(d, os.Stdout)
+	wd, err := d.Document.Metadata(c)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get metadata of %v", PathOf(d))
+	}
+	d.Document = wd
+	if len(wd.Hash) == 0 {
+		return errors.Errorf(
+			"ShareBase did not report a hash for %v", PathOf(d))
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s  %s\n", getHex(wd.Hash), d.Name())
+	return err
 }
 
 func (s *state) listDirectory(c *web.Client, o Object) error {
-	p, ok := o.(Parent)
+	p, ok := asParent(o)
 	if !ok {
 		return errors.Errorf(
-			"%v is not a parent (it's a %T)", PathOf(p), p)
+			"%v is not a parent (it's a %T)", PathOf(o), o)
 	}
 	if err := p.update(s.Root, c); err != nil {
 		return errors.ErrorfWithCause(
 			err,
 			"failed to update ShareBase Folder %v", PathOf(p))
 	}
+	ow := s.newObjectWriter(os.Stdout)
 	for _, ch := range p.Children() {
-		if err := writeObjectToList(ch, os.Stdout); err != nil {
+		if err := ow.WriteObject(ch); err != nil {
 			return err
 		}
 	}
-	return nil
+	return ow.Flush()
+}
+
+// objectWriter writes descriptions of ShareBase objects in some format.
+type objectWriter interface {
+	// WriteObject writes a description of a single object.
+	WriteObject(o Object) error
+
+	// Flush writes out anything buffered by the objectWriter.
+	Flush() error
+}
+
+// newObjectWriter creates an objectWriter that writes to w in the output
+// format selected by the command line flags.
+func (s *state) newObjectWriter(w io.Writer) objectWriter {
+	if s.JSON {
+		return jsonObjectWriter{json.NewEncoder(w)}
+	}
+	return textObjectWriter{
+		Writer: tabwriter.NewWriter(w, 0, 8, 1, ' ', 0),
+		human:  s.Human,
+	}
+}
+
+// textObjectWriter writes objects as lines of text with aligned columns.
+type textObjectWriter struct {
+	*tabwriter.Writer
+	human bool
+}
+
+// WriteObject implements objectWriter.
+func (w textObjectWriter) WriteObject(o Object) error {
+	return writeObjectToList(o, w.Writer, w.human)
+}
+
+// jsonObjectWriter writes objects as newline-delimited JSON objects.
+type jsonObjectWriter struct {
+	*json.Encoder
+}
+
+// WriteObject implements objectWriter.
+func (w jsonObjectWriter) WriteObject(o Object) error {
+	return w.Encode(makeObjectInfo(o))
+}
+
+// Flush implements objectWriter.  Every object is written out as soon as it
+// is encoded, so there's nothing to flush.
+func (w jsonObjectWriter) Flush() error { return nil }
+
+// objectInfo is the JSON representation of an Object written by the
+// jsonObjectWriter.
+type objectInfo struct {
+	Name         string     `json:"name"`
+	Path         string     `json:"path,omitempty"`
+	ID           int        `json:"id"`
+	Kind         web.Kind   `json:"kind"`
+	Size         *int64     `json:"size,omitempty"`
+	DateModified *time.Time `json:"dateModified,omitempty"`
+	Hash         string     `json:"hash,omitempty"`
+}
+
+func makeObjectInfo(o Object) objectInfo {
+	info := objectInfo{
+		Name: o.Name(),
+		ID:   o.ID(),
+		Kind: kindOf(o),
+	}
+	if d, ok := o.(*Document); ok {
+		info.Size = &d.Size
+		info.DateModified = &d.DateModified
+		info.Hash = getHex(d.Hash)
+	}
+	return info
+}
+
+// kindOf gets the kind of ShareBase object o is.
+func kindOf(o Object) web.Kind {
+	switch o.(type) {
+	case *Library:
+		return web.LibraryKind
+	case *Folder:
+		return web.FolderKind
+	case *Document:
+		return web.DocumentKind
+	}
+	return ""
 }
 
-func writeObjectToList(o Object, w io.Writer) error {
+func writeObjectToList(o Object, w io.Writer, human bool) error {
 	var err error
-	t := reflect.TypeOf(o)
 	switch o := o.(type) {
 	case *Document:
 		_, err = fmt.Fprintf(
 			w,
-			"%s\tID: %d\t%s\t%s\t%s\n",
+			"%s\tID: %d\t%s\t%s\t%s\t%s\n",
 			o.Name(),
 			o.ID(),
-			t.Name(),
+			kindOf(o),
+			formatSize(o.Size, human),
 			o.DateModified.Format("2006-01-02 03:04:05 PM EST"),
 			getHex(o.Hash))
-		//	case *Folder:
-		//		_, err = fmt.Fprintf(
-		//			w, "%s\tID: %d\t%s\n", o.Name(), o.ID(), t.Name())
 	default:
 		_, err = fmt.Fprintf(
-			w, "%s\tID: %d\t%s\n", o.Name(), o.ID(), t.Name())
+			w, "%s\tID: %d\t%s\t\t\t\n", o.Name(), o.ID(), kindOf(o))
 	}
 	return err
 }
 
-func (s *state) localToShareBase(wc *web.Client, p Parent, name string) (err error) {
-	//logger.Debug2("parent: %v, name: %q", PathOf(p), name)
-	var source *os.File
-	if s.Source == "" || s.Source == "-" {
-		source = os.Stdin
-	} else {
-		source, err = os.Open(s.Source)
-		if err != nil {
-			return err
-		}
-		defer errors.WrapDeferred(&err, source.Close)
-	}
-	st, err := source.Stat()
+// sizeFlag is a flag.Value for a size like "500K" or "2M".
+type sizeFlag web.Size
+
+// Set implements flag.Value.
+func (f *sizeFlag) Set(v string) error {
+	size, err := web.ParseSize(v)
 	if err != nil {
-		return errors.Errorf(
-			"failed to stat source: %v: %v", s.Source, err)
+		return err
 	}
-	if source != os.Stdin {
+	*f = sizeFlag(size)
+	return nil
+}
+
+// String implements flag.Value.
+func (f *sizeFlag) String() string {
+	if f == nil || *f == 0 {
+		return ""
+	}
+	return web.Size(*f).Human()
+}
+
+// smallFileCutoff gets the -small-file-cutoff size or web.SmallFileCutoff if
+// it wasn't given.
+func (s *state) smallFileCutoff() web.Size {
+	if s.SmallFileCutoff > 0 {
+		return web.Size(s.SmallFileCutoff)
+	}
+	return web.SmallFileCutoff
+}
+
+// limitReader limits how fast r can be read to -limit-rate, if it was
+// given.  Every transfer shares the same limiter, so the limit applies to all
+// of them together and not to each one separately.
+func (s *state) limitReader(ctx context.Context, r io.Reader) io.Reader {
+	if s.limiter == nil {
+		return r
+	}
+	return s.limiter.Reader(ctx, r)
+}
+
+// formatSize formats a size in bytes either as a raw number of bytes or, if
+// human is true, in human-readable units.
+func formatSize(size int64, human bool) string {
+	if human {
+		return web.Size(size).Human()
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+func (s *state) localToShareBase(wc *web.Client, p Parent, name string) (err error) {
+	//logger.Debug2("parent: %v, name: %q", PathOf(p), name)
+	if err = s.checkFields(wc, PathOf(p).Join(
+		ShareBasePathFromString(name)...)); err != nil {
+		return err
+	}
+	var source *os.File
+	if s.Source == "" || s.Source == "-" {
+		source = os.Stdin
+	} else {
+		source, err = os.Open(s.Source)
+		if err != nil {
+			return err
+		}
+		defer errors.WrapDeferred(&err, source.Close)
+	}
+	st, err := source.Stat()
+	if err != nil {
+		return errors.Errorf(
+			"failed to stat source: %v: %v", s.Source, err)
+	}
+	if source != os.Stdin {
 		// Don't try the if-dir-exists behavior if we're reading
 		// from stdin.
 		if c, err := s.Root.ObjectByPath(wc, p, ShareBasePathFromString(name)); err == nil {
 			logger.Debug2("parent %q has child %q", PathOf(p), name)
-			if c, ok := c.(Parent); ok {
+			if c, ok := asParent(c); ok {
 				logger.Debug1("child %q is itself a parent", PathOf(c))
 				p = c
 				name = path.Base(source.Name())
@@ -338,21 +1063,71 @@ func (s *state) localToShareBase(wc *web.Client, p Parent, name string) (err err
 	if err != nil {
 		return err
 	}
-	err = s.localFileToShareBaseDir(wc, source, f, name)
+	size := st.Size()
+	if source == os.Stdin {
+		// stdin could be a pipe, so stream it instead of trusting
+		// its size.
+		size = -1
+	}
+	_, err = s.localFileToShareBaseDir(wc, source, size, f, name)
 	return err
 }
 
 // localDirToShareBaseDir copies a local directory into a ShareBase directory.
+// Up to s.Jobs files are uploaded at the same time.
+func (s *state) localDirToShareBaseDir(wc *web.Client, source *os.File, p Parent, name string) error {
+	e, err := s.newDirExcluder(source.Name())
+	if err != nil {
+		return err
+	}
+	u := s.newUploader(context.Background(), s.Jobs)
+	err = s.scheduleLocalDir(wc, u, e, source, p, name, "")
+	if err2 := u.wait(); err2 != nil {
+		// A failed upload is the reason scheduling stopped.
+		return err2
+	}
+	if err != nil {
+		return err
+	}
+	return s.reportUploads(u.uploads())
+}
+
+// reportUploads writes how many documents a directory or tar upload created
+// to stdout after the paths and IDs of the documents themselves.  Nothing
+// is written for a dry run.
+func (s *state) reportUploads(n int) error {
+	if s.DryRun {
+		return nil
+	}
+	_, err := fmt.Fprintf(os.Stdout, "uploaded: %d\n", n)
+	return err
+}
+
+// scheduleLocalDir creates the ShareBase folder for a local directory and
+// schedules the uploads of the files in it.  The folder is created before
+// any of its files or subdirectories are scheduled.  rel is the path of the
+// directory relative to the root of the upload and is used to check entries
+// against the excluder.
 //
 // Currently, it uses recursion, so this could be a problem for very deep
 // folder structures.
-func (s *state) localDirToShareBaseDir(wc *web.Client, source *os.File, p Parent, name string) error {
+func (s *state) scheduleLocalDir(wc *web.Client, u *uploader, e *excluder, source *os.File, p Parent, name, rel string) error {
 	logger.Debug2("parent: %v, name: %q", PathOf(p), name)
 	f, err := s.Root.GetOrCreateFolder(wc, p, ShareBasePathFromString(name))
 	if err != nil {
 		return errors.ErrorfWithCause(
 			err, "failed to create ShareBase folder")
 	}
+	s.summary.addFolder()
+	if u.dedupe != nil {
+		// the documents already in f aren't known unless it's
+		// been listed.
+		if err = f.update(s.Root, wc); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to update %v", PathOf(f))
+		}
+		u.dedupe.addFolder(f)
+	}
 	for {
 		infos, err := source.Readdir(128)
 		if err != nil && err != io.EOF {
@@ -362,20 +1137,33 @@ func (s *state) localDirToShareBaseDir(wc *web.Client, source *os.File, p Parent
 				source.Name(), err)
 		}
 		for _, fi := range infos {
-			name := fi.Name()
-			file, err := os.Open(path.Join(source.Name(), name))
-			name = path.Base(name)
-			if err != nil {
-				return err
+			name := path.Base(fi.Name())
+			sourcePath := path.Join(source.Name(), name)
+			relPath := path.Join(rel, name)
+			if e.excluded(relPath, fi.IsDir()) {
+				s.status.verbosef("excluding %v", sourcePath)
+				continue
 			}
-			if fi.IsDir() {
-				err = s.localDirToShareBaseDir(wc, file, f, name)
-			} else {
-				err = s.localFileToShareBaseDir(wc, file, f, name)
+			if !fi.IsDir() {
+				if err := u.schedule(uploadJob{
+					source: sourcePath,
+					size:   fi.Size(),
+					parent: f,
+					folder: f.Folder,
+					target: PathOf(f).Join(name),
+				}); err != nil {
+					return err
+				}
+				continue
 			}
+			file, err := os.Open(sourcePath)
 			if err != nil {
 				return err
 			}
+			if err = s.scheduleLocalDir(wc, u, e, file, f, name, relPath); err != nil {
+				file.Close()
+				return err
+			}
 			if err = file.Close(); err != nil {
 				return err
 			}
@@ -387,13 +1175,71 @@ func (s *state) localDirToShareBaseDir(wc *web.Client, source *os.File, p Parent
 	return nil
 }
 
-func (s *state) localFileToShareBaseDir(c *web.Client, r io.Reader, f *Folder, name string) error {
-	logger.Info2("copying %v to %v...", name, PathOf(f))
-	// Don't need to worry about updating Root.  It'll find out about the
-	// new document the next time it's refreshed.  No need to rack up
-	// possibly unecessary requests.  Plus, we don't know what the
-	// new doc's ID is without re-requesting from the API.
-	return f.Folder.NewDocument(c, name, r)
+// localFileToShareBaseDir uploads r into f as a new document with the given
+// name and adds it to the tree.  The new document is returned so that
+// callers can report it, except for a dry run, when it's nil.
+func (s *state) localFileToShareBaseDir(c *web.Client, r io.Reader, size int64, f *Folder, name string) (*Document, error) {
+	s.status.printf("copying %v to %v...", name, PathOf(f))
+	d, err := s.uploadDocument(
+		context.Background(), c, r, size, f.Folder,
+		PathOf(f).Join(name))
+	if err != nil || s.DryRun {
+		return nil, err
+	}
+	return s.Root.addDocument(f, d), nil
+}
+
+// uploadDocument uploads r into folder wf as a new document.  size is the
+// size of r or -1 if it's unknown.  target is the full path of the new
+// document and its last element is the document's name.  The new document's
+// path and ID are written to stdout.  Canceling ctx cancels the upload,
+// including while it's being throttled by -limit-rate.
+//
+// It only uses its parameters (and not the tree) so that it can be called
+// from multiple goroutines.  It's up to the caller to add the new document to
+// the tree.
+func (s *state) uploadDocument(ctx context.Context, c *web.Client, r io.Reader, size int64, wf web.Folder, target Path) (web.Document, error) {
+	name := Basename(target)
+	if s.DryRun {
+		method := "large"
+		if web.IsSmallDocumentWithCutoff(size, s.smallFileCutoff()) {
+			method = "small"
+		}
+		source := name
+		if file, ok := r.(*os.File); ok {
+			source = file.Name()
+		}
+		s.status.printf(
+			"dry run: would upload %v to %v as a %v document",
+			source, target, method)
+		return web.Document{}, nil
+	}
+	options := []web.DocumentOption{
+		web.WithContext(ctx),
+		web.WithSmallFileCutoff(s.smallFileCutoff()),
+	}
+	if len(s.Fields) > 0 {
+		options = append(options, web.WithFields(s.Fields))
+	}
+	d, err := wf.NewDocumentWithSize(
+		c, name, s.limitReader(ctx, r), size, options...)
+	if err != nil {
+		return web.Document{}, err
+	}
+	if size < 0 {
+		size = d.Size
+	}
+	s.summary.addFile(size)
+	if file, ok := r.(*os.File); ok && s.Preserve && file != os.Stdin {
+		if err = s.preserveFileInfo(c, &d, file); err != nil {
+			return web.Document{}, err
+		}
+	}
+	if _, err = fmt.Fprintf(
+		os.Stdout, "%v\tID: %d\n", target, d.DocumentID); err != nil {
+		return web.Document{}, err
+	}
+	return d, nil
 }
 
 func (s *state) localTarToShareBaseDir(wc *web.Client, r io.Reader, origin Parent, name string) error {
@@ -402,7 +1248,12 @@ func (s *state) localTarToShareBaseDir(wc *web.Client, r io.Reader, origin Paren
 		return errors.ErrorfWithCause(
 			err, "failed to create ShareBase folder")
 	}
+	e, err := s.newExcluder(nil)
+	if err != nil {
+		return err
+	}
 	t := tar.NewReader(r)
+	uploads := 0
 	for {
 		h, err := t.Next()
 		if err != nil {
@@ -412,24 +1263,49 @@ func (s *state) localTarToShareBaseDir(wc *web.Client, r io.Reader, origin Paren
 			return errors.ErrorfWithCause(
 				err, "failure while reading tar")
 		}
+		p, err := tarEntryPath(h.Name)
+		if err != nil {
+			return err
+		}
+		rel := strings.Join(p, "/")
+		if e.excludedPath(rel, h.Typeflag == tar.TypeDir) {
+			s.status.verbosef("excluding %v", h.Name)
+			continue
+		}
+		var content io.Reader = t
+		if rel == ignoreFilename && h.Typeflag == tar.TypeReg {
+			// an ignore file at the root of the tar applies to the
+			// entries after it.  It's still uploaded like any other
+			// file.
+			data, err := ioutil.ReadAll(t)
+			if err != nil {
+				return errors.ErrorfWithCause(
+					err, "failed to read %v from tar: %v",
+					h.Name, err)
+			}
+			if e, err = s.newExcluder(bytes.NewReader(data)); err != nil {
+				return err
+			}
+			content = bytes.NewReader(data)
+		}
 		switch h.Typeflag {
 		case tar.TypeDir:
-			_, err = s.Root.GetOrCreateFolder(wc, f, LocalPathFromString(h.Name))
+			_, err = s.Root.GetOrCreateFolder(wc, f, p)
 			if err != nil {
 				return errors.ErrorfWithCause(
 					err,
 					"failed to create subdirectory")
 			}
+			s.summary.addFolder()
 		case tar.TypeReg:
-			path := LocalPathFromString(h.Name)
-			f2, err := s.Root.GetOrCreateFolder(wc, f, path.Dir())
+			f2, err := s.Root.GetOrCreateFolder(wc, f, p.Dir())
 			if err != nil {
 				return errors.ErrorfWithCause(
 					err,
 					"failed to get target directory %v: %v",
-					path.Dir(), err)
+					p.Dir(), err)
 			}
-			err = s.localFileToShareBaseDir(wc, t, f2, Basename(path))
+			d, err := s.localFileToShareBaseDir(wc, content, h.Size, f2, Basename(p))
 			if err != nil {
 				return errors.ErrorfWithCause(
 					err,
@@ -437,32 +1313,241 @@ func (s *state) localTarToShareBaseDir(wc *web.Client, r io.Reader, origin Paren
 						"into ShareBase Document: %v",
 					err)
 			}
+			if d != nil {
+				uploads++
+			}
 		}
 	}
-	return nil
+	return s.reportUploads(uploads)
 }
 
-func (s *state) shareBaseToLocal(wc *web.Client, p Parent, name string) (err error) {
-	var o Object
-	o, err = s.Root.ObjectByPath(wc, p, ShareBasePathFromString(name))
+func (s *state) shareBaseToLocal(wc *web.Client, p Parent, name string) error {
+	o, err := s.Root.ObjectByPath(wc, p, ShareBasePathFromString(name))
 	if err != nil {
 		return errors.ErrorfWithCause(
 			err,
 			"failed to get source ShareBase document or folder.")
 	}
-	p2, ok := o.(Parent)
-	target, err := s.getLocalTarget(ok && !s.Tar, name)
+	return s.shareBaseObjectToLocal(wc, o)
+}
+
+// shareBaseGlobToLocal copies every ShareBase object matching pattern to the
+// local target.  See (*state).shareBaseObjectsToLocal.
+func (s *state) shareBaseGlobToLocal(wc *web.Client, pattern ShareBasePath) error {
+	obs, err := s.Root.ObjectsByPath(wc, nil, pattern)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "no ShareBase objects match %v: %v", pattern, err)
+	}
+	return s.shareBaseObjectsToLocal(wc, obs, pattern)
+}
+
+// shareBasePathsToLocal copies the ShareBase objects at all of the sources,
+// which can have globs, to the local target.  See
+// (*state).shareBaseObjectsToLocal.
+func (s *state) shareBasePathsToLocal(wc *web.Client, sources []string) error {
+	var obs []Object
+	for _, source := range sources {
+		p := ShareBasePathFromString(source)
+		if HasGlob(p) {
+			matches, err := s.Root.ObjectsByPath(wc, nil, p)
+			if err != nil {
+				return errors.ErrorfWithCause(
+					err, "no ShareBase objects match %v: %v", p, err)
+			}
+			obs = append(obs, matches...)
+			continue
+		}
+		o, err := s.Root.ObjectByPath(wc, nil, p)
+		if err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to get %v: %v", p, err)
+		}
+		obs = append(obs, o)
+	}
+	return s.shareBaseObjectsToLocal(
+		wc, obs, ShareBasePathFromString(sources[0]))
+}
+
+// shareBaseObjectsToLocal copies obs to the local target.  Documents are
+// copied as files and folders as directories.  With -t, all of them are
+// written into a single tar under their own names (named after source if the
+// target is a directory).  Otherwise, if there is more than one, the target
+// must be a directory (it's created if it doesn't exist) and each one is
+// copied into it.  The documents are downloaded in parallel by up to -j
+// workers; see (*state).downloadDocuments.
+func (s *state) shareBaseObjectsToLocal(wc *web.Client, obs []Object, source ShareBasePath) (err error) {
+	if s.DryRun && len(obs) > 1 {
+		for _, o := range obs {
+			p, _ := asParent(o)
+			if s.Tar {
+				err = s.dryRunToLocal(
+					wc, o, o.Parent(),
+					s.dryRunTarget(Basename(source)), true)
+			} else {
+				err = s.dryRunToLocal(
+					wc, o, p, path.Join(s.Target, o.Name()), false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.Tar {
+		var target *os.File
+		target, err = s.getLocalTarget(false, Basename(source))
+		if err != nil {
+			return err
+		}
+		defer errors.WrapDeferred(&err, target.Close)
+		return s.shareBaseObjectsToLocalTar(wc, obs, target)
+	}
+	if len(obs) == 1 {
+		return s.shareBaseObjectToLocal(wc, obs[0])
+	}
+	if s.Target == "" || s.Target == "-" {
+		return errors.Errorf(
+			"%d objects can only be written to stdout as a tar "+
+				"(use -t)",
+			len(obs))
+	}
+	if err = os.MkdirAll(s.Target, 0777); err != nil {
+		return errors.ErrorfWithCause(
+			err,
+			"failed to create target directory %q: %v",
+			s.Target, err)
+	}
+	target := s.Target
+	var docs []*Document
+	for _, o := range obs {
+		if d, ok := o.(*Document); ok {
+			docs = append(docs, d)
+			continue
+		}
+		// getLocalTarget changes s.Target to the path within the
+		// target directory.
+		s.Target = target
+		if err = s.shareBaseObjectToLocal(wc, o); err != nil {
+			return err
+		}
+	}
+	s.Target = target
+	return s.downloadDocuments(context.Background(), docs, target)
+}
+
+// shareBaseObjectToLocal copies a single ShareBase document or folder to the
+// local target.
+func (s *state) shareBaseObjectToLocal(wc *web.Client, o Object) (err error) {
+	p2, ok := asParent(o)
+	if s.DryRun {
+		return s.dryRunToLocal(
+			wc, o, p2, s.dryRunTarget(o.Name()), ok && s.Tar)
+	}
+	if !ok {
+		return s.shareBaseFileToLocal(wc, o)
+	}
+	target, err := s.getLocalTarget(!s.Tar, o.Name())
 	if err != nil {
 		return err
 	}
 	defer errors.WrapDeferred(&err, target.Close)
-	if ok {
-		if s.Tar {
-			return s.shareBaseDirToLocalTar(wc, p2)
+	if s.Tar {
+		return s.shareBaseDirToLocalTar(wc, p2, target)
+	}
+	return s.shareBaseDirToLocalDir(wc, p2, LocalPathFromString(target.Name()))
+}
+
+// shareBaseFileToLocal copies the document o to the local target.  If the
+// target is a directory, the file is named with the filename from the
+// content's Content-Disposition header.  With -continue, it's named with the
+// document's name instead because the target has to be found before the
+// content is requested to know where to resume from.
+func (s *state) shareBaseFileToLocal(wc *web.Client, o Object) (err error) {
+	d, ok := o.(*Document)
+	if !ok {
+		return errors.NewUnexpectedType(d, o)
+	}
+	if s.Continue {
+		var target *os.File
+		if target, err = s.getLocalTarget(false, d.Name()); err != nil {
+			return err
 		}
-		return s.shareBaseDirToLocalDir(wc, p2, LocalPathFromString(target.Name()))
+		defer errors.WrapDeferred(&err, target.Close)
+		return s.resumeFileToLocalFile(wc, d, target)
 	}
-	return s.shareBaseFileToLocalFile(wc, o, target)
+	content, err := d.Document.Content(wc)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get content of %v", PathOf(d))
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	target, err := s.getLocalTarget(false, content.Filename())
+	if err != nil {
+		return err
+	}
+	defer errors.WrapDeferred(&err, target.Close)
+	return s.shareBaseFileToLocalFile(wc, d, content, target)
+}
+
+// dryRunToLocal logs where o or the documents under it would be copied to
+// without getting their content or creating any local files.  Documents are
+// named relative to root within the local target.  If tar is true, the
+// documents would be written into a tar at target instead of into separate
+// files.
+func (s *state) dryRunToLocal(wc *web.Client, o Object, root Parent, target string, tar bool) error {
+	logCopy := func(d *Document) {
+		switch {
+		case tar:
+			s.status.printf(
+				"dry run: would write %v into tar %v as %v",
+				PathOf(d), target,
+				path.Join(RelativePathOf(root, d)...))
+		case Object(d) == o:
+			s.status.printf(
+				"dry run: would copy %v to %v", PathOf(d), target)
+		default:
+			s.status.printf(
+				"dry run: would copy %v to %v", PathOf(d),
+				filepath.Join(
+					target,
+					LocalPath(RelativePathOf(root, d)).String()))
+		}
+	}
+	p, ok := asParent(o)
+	if !ok {
+		if d, ok := o.(*Document); ok {
+			logCopy(d)
+		}
+		return nil
+	}
+	if err := p.update(s.Root, wc); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to update %v", PathOf(p))
+	}
+	return Traverse(p, func(_ Parent, c Object) error {
+		if d, ok := c.(*Document); ok {
+			logCopy(d)
+			return nil
+		}
+		if err := c.update(s.Root, wc); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to update %v", PathOf(c))
+		}
+		return nil
+	})
+}
+
+// dryRunTarget gets the local target path that getLocalTarget would use for
+// name without creating anything.
+func (s *state) dryRunTarget(name string) string {
+	if s.Target == "" || s.Target == "-" {
+		return "stdout"
+	}
+	if st, err := os.Stat(s.Target); err == nil && st.IsDir() {
+		return path.Join(s.Target, name)
+	}
+	return s.Target
 }
 
 func (s *state) shareBaseDirToLocalDir(wc *web.Client, p Parent, target LocalPath) error {
@@ -491,15 +1576,300 @@ func (s *state) shareBaseDirToLocalDir(wc *web.Client, p Parent, target LocalPat
 	return errors.Errorf("not implemented")
 }
 
-func (s *state) shareBaseDirToLocalTar(wc *web.Client, p Parent) error {
-	panic("not implemented")
+// shareBaseDirToLocalTar writes all of the documents under p into a tar
+// written to w.  The documents' names within the tar are relative to p.
+func (s *state) shareBaseDirToLocalTar(wc *web.Client, p Parent, w io.Writer) (err error) {
+	tw := tar.NewWriter(w)
+	defer errors.WrapDeferred(&err, tw.Close)
+	return s.shareBaseDirToTar(wc, tw, p, p)
+}
+
+// shareBaseObjectsToLocalTar writes all of the given documents and the
+// documents under the given folders into a tar written to w.  Each object is
+// named in the tar relative to its own parent.
+func (s *state) shareBaseObjectsToLocalTar(wc *web.Client, obs []Object, w io.Writer) (err error) {
+	tw := tar.NewWriter(w)
+	defer errors.WrapDeferred(&err, tw.Close)
+	for _, o := range obs {
+		if d, ok := o.(*Document); ok {
+			err = s.shareBaseFileToTar(wc, tw, ShareBasePath{d.Name()}, d)
+		} else if p, ok := asParent(o); ok {
+			err = s.shareBaseDirToTar(wc, tw, o.Parent(), p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareBaseDirToTar writes all of the documents and folders under p into tw,
+// named relative to root.  If p isn't root, p itself is written, too.  Every
+// folder gets its own entry so that empty folders are extracted.
+func (s *state) shareBaseDirToTar(wc *web.Client, tw *tar.Writer, root, p Parent) error {
+	if err := p.update(s.Root, wc); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to update %v", PathOf(p))
+	}
+	if p != root {
+		if err := shareBaseFolderToTar(tw, RelativePathOf(root, p)); err != nil {
+			return err
+		}
+		s.summary.addFolder()
+	}
+	return Traverse(p, func(_ Parent, c Object) error {
+		if d, ok := c.(*Document); ok {
+			return s.shareBaseFileToTar(wc, tw, RelativePathOf(root, d), d)
+		}
+		// update before Traverse gets to the children.
+		if err := c.update(s.Root, wc); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to update %v", PathOf(c))
+		}
+		if err := shareBaseFolderToTar(tw, RelativePathOf(root, c)); err != nil {
+			return err
+		}
+		s.summary.addFolder()
+		return nil
+	})
+}
+
+// shareBaseFolderToTar writes a directory entry for a folder into the tar
+// writer with the given name.  ShareBase doesn't report when folders were
+// modified, so the directory's time is the time it's written.
+func shareBaseFolderToTar(tw *tar.Writer, name ShareBasePath) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     path.Join(name...) + "/",
+		Mode:     0755,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to write tar header for %v", name)
+	}
+	return nil
 }
 
-func (s *state) shareBaseFileToLocalFile(wc *web.Client, o Object, target *os.File) error {
-	panic("not implemented")
+// shareBaseFileToTar writes a single document into the tar writer with the
+// given name.
+func (s *state) shareBaseFileToTar(wc *web.Client, tw *tar.Writer, name ShareBasePath, d *Document) (err error) {
+	s.status.printf("copying %v to %v...", PathOf(d), name)
+	content, err := d.Document.Content(wc)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get content of %v", PathOf(d))
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	r := s.limitReader(context.Background(), content)
+	size := content.Length
+	if size < 0 {
+		// tar headers need the size up front, so content of unknown
+		// length has to be spooled to find out what it is.
+		var spool *os.File
+		if spool, size, err = spoolContent(r); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to spool content of %v", PathOf(d))
+		}
+		defer os.Remove(spool.Name())
+		defer errors.WrapDeferred(&err, spool.Close)
+		r = spool
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(name...),
+		Size:     size,
+		Mode:     0644,
+		ModTime:  d.DateModified,
+	}); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to write tar header for %v", PathOf(d))
+	}
+	if _, err = io.Copy(tw, r); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to write %v into tar", PathOf(d))
+	}
+	s.summary.addFile(size)
+	return nil
 }
 
-// getLocalTarget gets the local target file or directory.
+// spoolContent copies r into a temporary file and returns the file, rewound
+// to its beginning, along with its size.  The caller must close and remove
+// the file.
+func spoolContent(r io.Reader) (*os.File, int64, error) {
+	f, err := ioutil.TempFile("", "sb-spool-")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// resumeFileToLocalFile finishes downloading d into target, which might
+// already hold the beginning of d's content from an interrupted download.
+// Only the rest of the content is requested and appended to target.  If the
+// server doesn't honor the range request, or target doesn't look like the
+// beginning of d (it's larger than d), d is downloaded again from the start.
+func (s *state) resumeFileToLocalFile(wc *web.Client, d *Document, target *os.File) (err error) {
+	var offset int64
+	if target != os.Stdout {
+		st, err := target.Stat()
+		if err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to stat %v: %v", target.Name(), err)
+		}
+		offset = st.Size()
+	}
+	size := d.Document.Size
+	switch {
+	case offset == 0:
+		return s.redownloadToLocalFile(wc, d, target)
+	case size > 0 && offset == size:
+		s.status.verbosef(
+			"%v is already complete in %v", PathOf(d), target.Name())
+		if err = s.verifyLocalFile(wc, d, target); err != nil {
+			return err
+		}
+		return s.setLocalFileInfo(wc, d, target)
+	case size > 0 && offset > size:
+		logger.Info2(
+			"%v is larger than %v; downloading it again",
+			target.Name(), PathOf(d))
+		return s.redownloadToLocalFile(wc, d, target)
+	}
+	content, err := d.Document.ContentRange(wc, offset, -1)
+	if err != nil {
+		if se, ok := err.(web.StatusError); ok && se.Code == http.StatusRequestedRangeNotSatisfiable {
+			// the document's size wasn't known and the target
+			// is at least as big as it.
+			return s.redownloadToLocalFile(wc, d, target)
+		}
+		return errors.ErrorfWithCause(
+			err, "failed to get content of %v from offset %d: %v",
+			PathOf(d), offset, err)
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	if content.Start != offset {
+		logger.Info1(
+			"ShareBase ignored the range request for %v; "+
+				"downloading all of it again", PathOf(d))
+		if err = truncateFile(target); err != nil {
+			return err
+		}
+	} else {
+		s.status.verbosef(
+			"resuming %v from offset %d", PathOf(d), offset)
+		if _, err = target.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	return s.shareBaseFileToLocalFile(wc, d, content, target)
+}
+
+// redownloadToLocalFile replaces target's content with all of d's.
+func (s *state) redownloadToLocalFile(wc *web.Client, d *Document, target *os.File) (err error) {
+	if target != os.Stdout {
+		if err = truncateFile(target); err != nil {
+			return err
+		}
+	}
+	content, err := d.Document.Content(wc)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get content of %v", PathOf(d))
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	return s.shareBaseFileToLocalFile(wc, d, content, target)
+}
+
+// truncateFile empties f and rewinds it.
+func truncateFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to truncate %v: %v", f.Name(), err)
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// shareBaseFileToLocalFile writes the content of the document d into target.
+// Unless s.NoMtime is set, target's modification time is then set to the
+// document's so that later transfers can tell if it changed.
+func (s *state) shareBaseFileToLocalFile(wc *web.Client, d *Document, content io.Reader, target *os.File) error {
+	s.status.printf("copying %v to %v...", PathOf(d), target.Name())
+	expected, h, err := s.downloadHash(wc, d, target)
+	if err != nil {
+		return err
+	}
+	r := s.limitReader(context.Background(), content)
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	n, err := io.Copy(target, r)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to write %v into %v", PathOf(d), target.Name())
+	}
+	s.summary.addFile(n)
+	if h != nil {
+		if err = checkDownloadHash(d, target, expected, h.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	return s.setLocalFileInfo(wc, d, target)
+}
+
+// setLocalFileInfo sets target's modification time to d's unless s.NoMtime
+// is set.  With -preserve, the mode and modification time that were saved
+// when the file was uploaded are restored instead.  It has to be called after
+// all of the content is written because writing updates the modification
+// time.
+func (s *state) setLocalFileInfo(wc *web.Client, d *Document, target *os.File) error {
+	if target == os.Stdout {
+		return nil
+	}
+	mtime := d.DateModified
+	if s.Preserve {
+		mode, preserved, ok, err := s.preservedFileInfo(wc, d)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err = target.Chmod(mode); err != nil {
+				return errors.ErrorfWithCause(
+					err, "failed to set mode of %v: %v",
+					target.Name(), err)
+			}
+			if !preserved.IsZero() {
+				mtime = preserved
+			}
+		}
+	}
+	if s.NoMtime || mtime.IsZero() {
+		return nil
+	}
+	if err := os.Chtimes(target.Name(), mtime, mtime); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to set modification time of %v: %v",
+			target.Name(), err)
+	}
+	return nil
+}
+
+// getLocalTarget gets the local target file or directory.  If the target is
+// an existing directory, the target becomes name within that directory.
+//
+// Existing targets are only used if s.Overwrite is set.  For file targets,
+// the existing file is truncated and replaced.  For directory (container)
+// targets, the existing directory is used as-is and only the files within it
+// with the same names as the source documents are replaced.
 func (s *state) getLocalTarget(container bool, name string) (*os.File, error) {
 	if s.Target == "" || s.Target == "-" {
 		return os.Stdout, nil
@@ -533,19 +1903,54 @@ func (s *state) getLocalTarget(container bool, name string) (*os.File, error) {
 				"failed to stat %q: %v", s.Target, err)
 		}
 	}
-	if !s.Overwrite && (err == nil || os.IsExist(err)) {
-		return nil, errors.ErrorfWithCause(
-			err,
-			"refusing to overwrite existing target %q")
+	exists := err == nil
+	if exists && s.Continue && !container && !st.IsDir() {
+		// the existing file is resumed, not overwritten.
+		return os.OpenFile(s.Target, os.O_WRONLY, 0)
+	}
+	if exists && !s.Overwrite {
+		return nil, TargetExists{Path: s.Target}
+	}
+	if container {
+		if !exists {
+			if err = os.Mkdir(s.Target, 0777); err != nil {
+				return nil, errors.ErrorfWithCause(
+					err,
+					"failed to create target directory "+
+						"%q: %v",
+					s.Target, err)
+			}
+		} else if !st.IsDir() {
+			return nil, errors.Errorf(
+				"target %q exists but is not a directory",
+				s.Target)
+		}
+		return os.Open(s.Target)
+	}
+	if exists && st.IsDir() {
+		return nil, errors.Errorf(
+			"cannot overwrite directory %q with a file", s.Target)
+	}
+	if exists {
+		if err = s.confirm(
+			"overwrite", []string{"overwrite " + s.Target},
+			"Overwrite 1 file?"); err != nil {
+			return nil, err
+		}
 	}
 	return os.Create(s.Target)
 }
 
-// die reports the given error and terminates the program with a non-0 return
-// code.
+// die reports the given error and terminates the program with the exit code
+// for it (see exitCode).
 func die(err error) {
 	logger.LogErr(err)
-	os.Exit(-1)
+	if id := requestIDOf(err); id != "" {
+		fmt.Fprintf(
+			os.Stderr, "ShareBase request ID: %v (include it when "+
+				"contacting support)\n", id)
+	}
+	os.Exit(exitCode(err))
 }
 
 // dieOnError calls die if the given error is not nil.
@@ -559,15 +1964,29 @@ func isShareBaseLoc(v string) bool {
 	return strings.HasPrefix(v, shareBaseURIScheme)
 }
 
+// allShareBaseLocs checks if every one of vs is a ShareBase location.
+func allShareBaseLocs(vs []string) bool {
+	for _, v := range vs {
+		if !isShareBaseLoc(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadJSONConfig loads c from the JSON file, or from stdin if filename is
+// stdinConfigFilename.
 func loadJSONConfig(filename string, c *Config) (err error) {
 	const loadJSONConfigErrFmt = "failed to %v JSON configuration file: %v"
-	f, err := os.Open(filename)
-	if err != nil {
-		return errors.ErrorfWithCause(
-			err,
-			loadJSONConfigErrFmt, "open", err)
+	f := os.Stdin
+	if filename != stdinConfigFilename {
+		if f, err = os.Open(filename); err != nil {
+			return errors.ErrorfWithCause(
+				err,
+				loadJSONConfigErrFmt, "open", err)
+		}
+		defer errors.WrapDeferred(&err, f.Close)
 	}
-	defer errors.WrapDeferred(&err, f.Close)
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		return errors.ErrorfWithCause(
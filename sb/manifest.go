@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// manifestEntry is a single document in a checksum manifest.
+type manifestEntry struct {
+	// Path is the document's path relative to the manifest's root,
+	// separated with forward slashes.
+	Path string `json:"path"`
+
+	// Size is the size of the document's content.  It's -1 when it
+	// isn't known because it was read from a text manifest.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex-encoded SHA-256 hash of the content.
+	SHA256 string `json:"sha256"`
+
+	// SHA1 is the hex-encoded SHA-1 hash that ShareBase reported for
+	// the document, if it did.
+	SHA1 string `json:"sha1,omitempty"`
+}
+
+// writeManifest writes a checksum manifest of every document under o to
+// stdout.  See (*state).writeManifestTo.
+func (s *state) writeManifest(c *web.Client, o Object) error {
+	return s.writeManifestTo(c, os.Stdout, o)
+}
+
+// writeManifestTo writes a line to w for every document under o (or for o
+// itself if it's a document) with the SHA-256 hash of its content and its
+// path relative to o, sorted by path.  The lines are in sha256sum's format
+// so that sha256sum -c can check a local copy of the documents:
+//
+//	<hex hash>  <path>
+//
+// With -json, the lines are JSON objects that also have each document's size
+// and the SHA-1 hash reported by ShareBase, which lets verify check documents
+// without downloading them.
+//
+// ShareBase only reports SHA-1 hashes, so every document's content is read to
+// compute its SHA-256 hash.
+func (s *state) writeManifestTo(c *web.Client, w io.Writer, o Object) error {
+	root, docs, err := s.manifestDocuments(c, o)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, d := range docs {
+		e := manifestEntry{
+			Path: manifestPath(root, d),
+			SHA1: getHex(d.Hash),
+		}
+		if e.SHA256, e.Size, err = s.sha256Document(c, d); err != nil {
+			return err
+		}
+		if s.JSON {
+			err = enc.Encode(e)
+		} else {
+			_, err = fmt.Fprintln(bw, formatManifestLine(e))
+		}
+		if err == nil {
+			// write each line as soon as its document is hashed.
+			err = bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestDocuments gets the documents under o sorted by their paths
+// relative to the returned root.  The tree under o is updated all the way
+// down.
+func (s *state) manifestDocuments(c *web.Client, o Object) (root Parent, docs []*Document, err error) {
+	if d, ok := o.(*Document); ok {
+		return d.Parent(), []*Document{d}, nil
+	}
+	root, ok := asParent(o)
+	if !ok {
+		return nil, nil, errors.Errorf("cannot make a manifest of %T", o)
+	}
+	err = s.updateTree(c, root, func(o Object) error {
+		if d, ok := o.(*Document); ok {
+			docs = append(docs, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return lessPath(RelativePathOf(root, docs[i]), RelativePathOf(root, docs[j]))
+	})
+	return root, docs, nil
+}
+
+// manifestPath gets d's path relative to root as it's written in manifests.
+func manifestPath(root Parent, d *Document) string {
+	return path.Join(RelativePathOf(root, d)...)
+}
+
+// sha256Document reads all of d's content to get its SHA-256 hash and size.
+func (s *state) sha256Document(c *web.Client, d *Document) (sum string, size int64, err error) {
+	logger.Info1("hashing %v...", PathOf(d))
+	content, err := d.Document.Content(c)
+	if err != nil {
+		return "", 0, errors.ErrorfWithCause(
+			err, "failed to get content of %v: %v", PathOf(d), err)
+	}
+	defer errors.WrapDeferred(&err, content.Close)
+	h := sha256.New()
+	if size, err = io.Copy(h, s.limitReader(context.Background(), content)); err != nil {
+		return "", 0, errors.ErrorfWithCause(
+			err, "failed to read content of %v: %v", PathOf(d), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// manifestEscaper and manifestUnescaper escape the characters in paths the
+// same way sha256sum does so that every entry fits on one line.
+var (
+	manifestEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+	manifestUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+)
+
+// formatManifestLine formats e like sha256sum does.  Like sha256sum, lines
+// with escaped paths start with a backslash.
+func formatManifestLine(e manifestEntry) string {
+	escaped := manifestEscaper.Replace(e.Path)
+	if escaped != e.Path {
+		return `\` + e.SHA256 + "  " + escaped
+	}
+	return e.SHA256 + "  " + e.Path
+}
+
+// readManifest reads the entries of a manifest written by writeManifest in
+// either its text or JSON format.  Blank lines are skipped.
+func readManifest(r io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, err := parseManifestLine(line)
+		if err != nil {
+			return nil, errors.ErrorfWithCause(
+				err, "invalid manifest line %d: %v", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// parseManifestLine parses a single JSON or sha256sum style manifest line.
+// sha256sum's binary mode marker ("*" before the path) is accepted too.
+func parseManifestLine(line string) (manifestEntry, error) {
+	if strings.HasPrefix(line, "{") {
+		e := manifestEntry{Size: -1}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return manifestEntry{}, err
+		}
+		if e.Path == "" || e.SHA256 == "" {
+			return manifestEntry{}, errors.Errorf(
+				"entry needs a path and a sha256 hash")
+		}
+		return e, nil
+	}
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+	i := strings.IndexByte(line, ' ')
+	if i != 2*sha256.Size || len(line) < i+2 || (line[i+1] != ' ' && line[i+1] != '*') {
+		return manifestEntry{}, errors.Errorf(
+			"expected a SHA-256 hash and a path, not %q", line)
+	}
+	if _, err := hex.DecodeString(line[:i]); err != nil {
+		return manifestEntry{}, err
+	}
+	e := manifestEntry{Path: line[i+2:], Size: -1, SHA256: strings.ToLower(line[:i])}
+	if escaped {
+		e.Path = manifestUnescaper.Replace(e.Path)
+	}
+	return e, nil
+}
+
+// verifyManifest checks the documents under o against the manifest named by
+// the command's argument (or stdin if there isn't one or it's "-").  See
+// (*state).verifyManifestFrom.
+func (s *state) verifyManifest(c *web.Client, o Object) (err error) {
+	var r io.Reader = os.Stdin
+	if len(s.Args) > 0 && s.Args[0] != "-" {
+		var f *os.File
+		if f, err = os.Open(s.Args[0]); err != nil {
+			return err
+		}
+		defer errors.WrapDeferred(&err, f.Close)
+		r = f
+	}
+	failed, err := s.verifyManifestFrom(c, os.Stdout, o, r)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.Errorf(
+			"%d documents under %v don't match the manifest",
+			failed, PathOf(o))
+	}
+	return nil
+}
+
+// verifyManifestFrom checks that every document in the manifest read from r
+// still exists under o and matches it, writing "<path>: OK" or
+// "<path>: FAILED (<reason>)" lines to w like sha256sum -c does.  It returns
+// how many of them didn't match.
+//
+// Documents are first compared by size.  If the manifest has a document's
+// SHA-1 hash and ShareBase lists one, they're compared instead of
+// downloading the document; otherwise its content is read to compare its
+// SHA-256 hash.
+func (s *state) verifyManifestFrom(c *web.Client, w io.Writer, o Object, r io.Reader) (failed int, err error) {
+	root, ok := asParent(o)
+	if !ok {
+		return 0, errors.Errorf(
+			"manifests must be verified against a folder or library, "+
+				"not %T", o)
+	}
+	entries, err := readManifest(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		problem, err := s.verifyEntry(c, root, e)
+		if err != nil {
+			return failed, err
+		}
+		result := "OK"
+		if problem != "" {
+			failed++
+			result = "FAILED (" + problem + ")"
+		}
+		if _, err = fmt.Fprintf(w, "%s: %s\n", e.Path, result); err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// verifyEntry checks a single manifest entry and describes the problem if
+// the document doesn't match it.
+func (s *state) verifyEntry(c *web.Client, root Parent, e manifestEntry) (problem string, err error) {
+	o, err := s.Root.ObjectByPath(c, root, ShareBasePath(strings.Split(e.Path, "/")))
+	if err != nil {
+		if isChildNotFound(err) {
+			return "missing", nil
+		}
+		return "", err
+	}
+	d, ok := o.(*Document)
+	if !ok {
+		return fmt.Sprintf("not a document but a %v", kindOf(o)), nil
+	}
+	if e.Size >= 0 && !documentSizeUnknown(d.Document) && d.Document.Size != e.Size {
+		return fmt.Sprintf("size %d, expected %d", d.Document.Size, e.Size), nil
+	}
+	if e.SHA1 != "" && len(d.Hash) > 0 {
+		if !strings.EqualFold(getHex(d.Hash), e.SHA1) {
+			return "SHA-1 mismatch", nil
+		}
+		return "", nil
+	}
+	sum, size, err := s.sha256Document(c, d)
+	if err != nil {
+		return "", err
+	}
+	if e.Size >= 0 && size != e.Size {
+		return fmt.Sprintf("size %d, expected %d", size, e.Size), nil
+	}
+	if !strings.EqualFold(sum, e.SHA256) {
+		return "SHA-256 mismatch", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestManifest(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	top, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = s.writeManifestTo(c, &buf, top); err != nil {
+		t.Fatal(err)
+	}
+	expected := sha256Hex(treeContents[3]) + "  Sub/c.txt\n" +
+		sha256Hex(treeContents[1]) + "  a.txt\n" +
+		sha256Hex(treeContents[2]) + "  b.txt\n"
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// break one entry and add one that doesn't exist.
+	manifest := strings.Replace(
+		buf.String(), sha256Hex(treeContents[2]), sha256Hex("x"), 1)
+	manifest += sha256Hex("") + "  missing.txt\n"
+	var out bytes.Buffer
+	failed, err := s.verifyManifestFrom(c, &out, top, strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const results = "Sub/c.txt: OK\n" +
+		"a.txt: OK\n" +
+		"b.txt: FAILED (SHA-256 mismatch)\n" +
+		"missing.txt: FAILED (missing)\n"
+	if failed != 2 || out.String() != results {
+		t.Fatalf("expected 2 failures:\n%s\ngot %d:\n%s", results, failed, out.String())
+	}
+}
+
+func TestManifestJSON(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot(), JSON: true}
+	top, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = s.writeManifestTo(c, &buf, top); err != nil {
+		t.Fatal(err)
+	}
+	manifest := buf.String()
+	entries, err := readManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[1].Path != "a.txt" || entries[1].Size != 10 || entries[1].SHA1 != "01" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	// a.txt and c.txt have SHA-1 hashes to compare, so only b.txt has
+	// to be downloaded.
+	n := c.NumRequests()
+	var out bytes.Buffer
+	failed, err := s.verifyManifestFrom(c, &out, top, strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 {
+		t.Fatalf("expected no failures, got:\n%s", out.String())
+	}
+	if got := c.NumRequests() - n; got != 1 {
+		t.Fatalf("expected 1 request, got %d", got)
+	}
+}
+
+func TestParseManifestLine(t *testing.T) {
+	e := manifestEntry{Path: "odd\\name\nhere.txt", Size: -1, SHA256: sha256Hex("")}
+	line := formatManifestLine(e)
+	if !strings.HasPrefix(line, `\`) || strings.Contains(line, "\n") {
+		t.Fatalf("expected an escaped line, got %q", line)
+	}
+	got, err := parseManifestLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != e {
+		t.Fatalf("expected %+v, got %+v", e, got)
+	}
+	if _, err = parseManifestLine("abc  file.txt"); err == nil {
+		t.Fatal("expected an error for a short hash")
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/skillian/errors"
 	"github.com/skillian/sharebase/web"
@@ -9,7 +15,18 @@ import (
 
 // Root is a ShareBase filesystem root that keeps track of the top-level
 // libraries inside.
+//
+// Root's methods can be called from multiple goroutines: lookups take a read
+// lock on the tree and updates take the write lock only while they change it,
+// not while they wait on ShareBase.  The objects' own methods (Children,
+// ChildByName, etc.) don't lock, so they should only be used when nothing
+// else is updating the tree, and slices borrowed from Children() shouldn't be
+// kept across updates.
 type Root struct {
+	// mutex protects the whole tree: idCache, missing, and every
+	// library, folder, and document's children and state.
+	mutex sync.RWMutex
+
 	// objects contains the collection of libraries known by a client.
 	objects
 
@@ -24,6 +41,41 @@ type Root struct {
 	// letting it be reclaimed by GC, it's put into the missing map so
 	// the existing object can be updated
 	missing map[int]libFldDoc
+
+	// updated holds when each library or folder (or the Root itself)
+	// last had its children updated.
+	updated map[Parent]time.Time
+
+	// TTL is how long a parent's children are considered fresh after
+	// they're updated.  While a parent is fresh, looking up a child that
+	// isn't in it returns ChildNotFound instead of updating the parent
+	// again.  Use ForceRefresh to update a parent anyway.  0 (the
+	// default) disables caching so that every miss updates the parent.
+	TTL time.Duration
+
+	// CreateLibraries lets GetOrCreateFolder create the library at the
+	// start of the path if it doesn't exist instead of failing.
+	CreateLibraries bool
+
+	// IgnoreCase makes path lookups match children whose names only
+	// differ from the path's elements by case, so sb:my/documents finds
+	// "Documents".  A child with exactly the same name is still
+	// preferred, and if several children only differ by case from an
+	// element, the lookup fails with an AmbiguousName error instead of
+	// choosing one.  Patterns are still matched case-sensitively.
+	IgnoreCase bool
+
+	// Prefetch is how many parents ObjectsByPath updates at the same
+	// time.  When a pattern matches several folders, their children are
+	// updated concurrently before the next element is matched against
+	// them instead of one after another.  0 or 1 (the default) updates
+	// them one at a time.
+	Prefetch int
+
+	// DryRun keeps GetOrCreateFolder from actually creating folders.
+	// Instead, it logs the folders it would create and returns
+	// placeholders for them.
+	DryRun bool
 }
 
 // NewRoot creates a new ShareBase root.
@@ -32,22 +84,16 @@ func NewRoot() *Root {
 	r.objects.init(8)
 	r.idCache = make(map[int]libFldDoc)
 	r.missing = make(map[int]libFldDoc)
+	r.updated = make(map[Parent]time.Time)
 	return r
 }
 
 // ParentByPath retrieves a parent library or folder for the given full path as
 // well as the name of the last element within the path.  If origin is not nil,
 // path is a relative path to origin, otherwise path is an absolute path
-// including the library name.
-//
-// For example:
-//
-//     p, base, err := r.ParentByPath(c, nil, "sb:my/Documents/test.txt")
-//     fmt.Printf("%q, %q, %v\n", PathOf(p), base, err)
-//
-// Output:
-//
-//     "My Library/Documents", "test.txt", nil
+// including the library name.  For example, the absolute path
+// "sb:my/Documents/test.txt" gets the "My Library/Documents" folder and
+// "test.txt".
 //
 // The last child isn't retrieved in case it is the target of an upload
 // operation and doesn't exist yet.
@@ -56,11 +102,14 @@ func (r *Root) ParentByPath(c *web.Client, origin Parent, path Path) (p Parent,
 	logger.Debug1("dir: %v", dir)
 	o, err := r.ObjectByPath(c, origin, path.Dir())
 	if err != nil {
-		return nil, "", errors.Errorf(
+		// keep err as the cause so that callers can still tell if
+		// the parent is missing with isChildNotFound.
+		return nil, "", errors.ErrorfWithCause(
+			err,
 			"failed to get parent directory %q of path %q: %v",
 			dir, path, err)
 	}
-	p, ok := o.(Parent)
+	p, ok := asParent(o)
 	if !ok {
 		return nil, "", errors.Errorf(
 			"object %v exists but is not a parent", path)
@@ -69,9 +118,13 @@ func (r *Root) ParentByPath(c *web.Client, origin Parent, path Path) (p Parent,
 }
 
 // GetOrCreateFolder attempts to get an existing folder with the given path
-// but creates it if necessary.
+// but creates it if necessary.  Like ObjectByPath, if origin is nil, path
+// must be a full path, including the library name.
 func (r *Root) GetOrCreateFolder(c *web.Client, origin Parent, path Path) (f *Folder, err error) {
-	logger.Debug2("origin: %#v, path: %#v", PathOf(origin), path)
+	if origin == nil {
+		origin = r
+	}
+	logger.Debug2("origin: %#v, path: %#v", r.pathOf(origin), path)
 	o, err := r.ObjectByPath(c, origin, path)
 	if err == nil {
 		f, ok := o.(*Folder)
@@ -79,14 +132,21 @@ func (r *Root) GetOrCreateFolder(c *web.Client, origin Parent, path Path) (f *Fo
 			return f, nil
 		}
 		return nil, errors.NewUnexpectedType(f, o)
-	} else if _, ok := err.(ChildNotFound); !ok {
+	} else if !isChildNotFound(err) {
 		return nil, errors.ErrorfWithCause(
 			err,
 			"error while checking for existing folder %v",
 			Basename(path))
 	}
-	fullPath := ShareBasePathFromPaths(PathOf(origin), path)
+	fullPath := ShareBasePathFromPaths(r.pathOf(origin), path)
+	if r.DryRun {
+		logger.Info1("dry run: would create folder %v", fullPath)
+		return r.placeholderFolder(c, origin, path)
+	}
 	lib, err := r.LibraryByName(fullPath.Elem(0))
+	if isChildNotFound(err) && r.CreateLibraries {
+		lib, err = r.createLibrary(c, fullPath.Elem(0))
+	}
 	if err != nil {
 		return nil, errors.ErrorfWithCause(
 			err,
@@ -97,6 +157,9 @@ func (r *Root) GetOrCreateFolder(c *web.Client, origin Parent, path Path) (f *Fo
 			err,
 			"failed to create folder %v: %v", fullPath, err)
 	}
+	// the new folders' parents must be updated to find them, even if
+	// they're otherwise fresh.
+	r.expire()
 	// now we need to refresh the tree up from the origin to build up the
 	// full path.
 	o, err = r.ObjectByPath(c, origin, path)
@@ -114,9 +177,69 @@ func (r *Root) GetOrCreateFolder(c *web.Client, origin Parent, path Path) (f *Fo
 	return f, nil
 }
 
+// createLibrary creates a shared library and adds it to the tree.  If another
+// client created the library first, that library is used.
+func (r *Root) createLibrary(c *web.Client, name string) (*Library, error) {
+	logger.Info1("creating library %v...", name)
+	if _, err := c.NewLibrary(name, false); err != nil {
+		if _, ok := err.(web.AlreadyExists); !ok {
+			return nil, errors.ErrorfWithCause(
+				err, "failed to create library %q: %v", name, err)
+		}
+	}
+	if err := r.ForceRefresh(c, r); err != nil {
+		return nil, err
+	}
+	return r.LibraryByName(name)
+}
+
+// placeholderFolder gets a folder for path relative to origin where any
+// folders in the path that don't exist yet are replaced with placeholders.
+// Placeholders have no ID and aren't added to the tree.  Missing libraries
+// are only replaced with placeholders if r.CreateLibraries is set.
+func (r *Root) placeholderFolder(c *web.Client, origin Parent, path Path) (*Folder, error) {
+	if origin == nil {
+		origin = r
+	}
+	p := origin
+	for i := 0; i < path.Len(); i++ {
+		part := path.Elem(i)
+		o, err := r.ObjectByPath(c, p, ShareBasePath{part})
+		if err == nil {
+			var ok bool
+			if p, ok = asParent(o); !ok {
+				return nil, errors.Errorf(
+					"%v exists but is not a folder", r.pathOf(o))
+			}
+			continue
+		}
+		if !isChildNotFound(err) {
+			return nil, err
+		}
+		if _, ok := p.(*Root); ok {
+			if !r.CreateLibraries {
+				return nil, err
+			}
+			logger.Info1("dry run: would create library %v", part)
+			p = newLibrary(r, web.Library{LibraryName: part})
+			continue
+		}
+		p = newFolder(p, web.Folder{FolderName: part})
+	}
+	f, ok := p.(*Folder)
+	if !ok {
+		return nil, errors.NewUnexpectedType(f, p)
+	}
+	return f, nil
+}
+
 // ObjectByPath retrieves an Object from the ShareBase API by its path,
 // relative to the origin.  If origin is nil, path must be a full path,
 // including the library name.
+//
+// Parents that don't have the next element yet are updated one level at a
+// time: ShareBase gets folders by ID and each ID is only known after its
+// parent is listed, so there's nothing to get ahead of time.
 func (r *Root) ObjectByPath(c *web.Client, origin Parent, path Path) (Object, error) {
 	if origin == nil {
 		origin = r
@@ -125,33 +248,345 @@ func (r *Root) ObjectByPath(c *web.Client, origin Parent, path Path) (Object, er
 	//for _, part := range path {
 	for i := 0; i < path.Len(); i++ {
 		part := path.Elem(i)
-		logger.Debug2("Getting child %q from parent %v...", part, PathOf(o))
-		p, ok := o.(Parent)
+		p, ok := asParent(o)
 		if !ok {
 			return nil, errors.Errorf(
 				"expected folder or library, not %T", o)
 		}
+		r.mutex.RLock()
+		logger.Debug2("Getting child %q from parent %v...", part, PathOf(p))
 		o, ok = p.ChildByName(part)
+		r.mutex.RUnlock()
 		if !ok {
-			if err := p.update(r, c); err != nil {
+			if err := r.refresh(c, p); err != nil {
 				return nil, err
 			}
-			// Try again.
-			if o, ok = p.ChildByName(part); !ok {
+			// Try again.  Children whose names only differ by
+			// case aren't considered until the parent is up to
+			// date so that an exact match always wins.
+			var err error
+			if o, err = r.findChild(p, part); err != nil {
 				// No handling if it fails after update.
-				return nil, ChildNotFound{Name: part}
+				return nil, err
 			}
 		}
 	}
 	return o, nil
 }
 
+// ForceRefresh updates p's children from ShareBase even if they're still
+// fresh.
+func (r *Root) ForceRefresh(c *web.Client, p Parent) error {
+	return p.update(r, c)
+}
+
+// refresh updates p's children unless they're still fresh.
+func (r *Root) refresh(c *web.Client, p Parent) error {
+	if r.fresh(p) {
+		logger.Debug1("%v is fresh; not updating it", r.pathOf(p))
+		return nil
+	}
+	return p.update(r, c)
+}
+
+// fresh checks if p's children were updated within the TTL.
+func (r *Root) fresh(p Parent) bool {
+	if r.TTL <= 0 {
+		return false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	t, ok := r.updated[p]
+	return ok && time.Since(t) < r.TTL
+}
+
+// expire makes every parent stale so that the next lookups update them.
+func (r *Root) expire() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.updated = make(map[Parent]time.Time)
+}
+
+// childByName gets p's child with the read lock held.
+func (r *Root) childByName(p Parent, name string) (Object, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return p.ChildByName(name)
+}
+
+// findChild gets p's child with the given name.  If r.IgnoreCase is set and
+// none of p's children has exactly that name, the child whose name only
+// differs from it by case is returned instead.  If more than one child
+// matches that way, an AmbiguousName error naming all of them is returned.
+// If nothing matches, the error is a ChildNotFound.
+func (r *Root) findChild(p Parent, name string) (Object, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if o, ok := p.ChildByName(name); ok {
+		return o, nil
+	}
+	if !r.IgnoreCase {
+		return nil, ChildNotFound{Name: name}
+	}
+	var (
+		found Object
+		names []string
+	)
+	for _, ch := range p.Children() {
+		if strings.EqualFold(ch.Name(), name) {
+			found = ch
+			names = append(names, ch.Name())
+		}
+	}
+	switch len(names) {
+	case 0:
+		return nil, ChildNotFound{Name: name}
+	case 1:
+		return found, nil
+	}
+	sort.Strings(names)
+	return nil, AmbiguousName{Name: name, Matches: names}
+}
+
+// childrenOf gets a copy of p's children with the read lock held.
+func (r *Root) childrenOf(p Parent) []Object {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]Object(nil), p.Children()...)
+}
+
+// ObjectsByPath is like ObjectByPath but the elements of pattern can be glob
+// patterns (see path.Match) that expand to every matching child.  Elements
+// that aren't patterns must still match a child's name exactly.
+//
+// Matched documents are only returned if they match the last element of
+// pattern; documents that match earlier elements are skipped because they
+// cannot have children.  The last element can match both folders and
+// documents.  If nothing matches an element, a ChildNotFound error with that
+// element's name is returned.
+func (r *Root) ObjectsByPath(c *web.Client, origin Parent, pattern Path) ([]Object, error) {
+	if origin == nil {
+		origin = r
+	}
+	obs := []Object{origin}
+	for i := 0; i < pattern.Len(); i++ {
+		part := pattern.Elem(i)
+		prefetched, err := r.prefetch(c, obs, part)
+		if err != nil {
+			return nil, err
+		}
+		var matches []Object
+		for _, o := range obs {
+			p, ok := asParent(o)
+			if !ok {
+				continue
+			}
+			if prefetched[p] && !isGlob(part) {
+				ch, err := r.findChild(p, part)
+				if err != nil {
+					if isChildNotFound(err) {
+						continue
+					}
+					return nil, err
+				}
+				matches = append(matches, ch)
+				continue
+			}
+			if !isGlob(part) {
+				ch, err := r.ObjectByPath(c, p, ShareBasePath{part})
+				if err != nil {
+					if isChildNotFound(err) {
+						continue
+					}
+					return nil, err
+				}
+				matches = append(matches, ch)
+				continue
+			}
+			// The children might only be partially known, so
+			// update before matching against them unless they're
+			// fresh.
+			if !prefetched[p] {
+				if err := r.refresh(c, p); err != nil {
+					return nil, err
+				}
+			}
+			for _, ch := range r.childrenOf(p) {
+				ok, err := path.Match(part, r.nameOf(ch))
+				if err != nil {
+					return nil, errors.ErrorfWithCause(
+						err,
+						"invalid pattern %q: %v", part, err)
+				}
+				if ok {
+					matches = append(matches, ch)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, ChildNotFound{Name: part}
+		}
+		obs = matches
+	}
+	return obs, nil
+}
+
+// prefetch updates the parents in obs that have to be updated before part
+// can be matched against their children, up to r.Prefetch of them at a time.
+// It returns the parents it updated so that they aren't updated again.  If
+// r.Prefetch is less than 2 or only one parent has to be updated, nothing is
+// prefetched.
+//
+// The parents are all from the same level of the path, so none of them is
+// another's ancestor and their updates don't overlap.  Each update still
+// goes through its parent's update method and updateObjects, so the tree
+// ends up the same as if they had been updated one at a time.
+func (r *Root) prefetch(c *web.Client, obs []Object, part string) (map[Parent]bool, error) {
+	if r.Prefetch < 2 {
+		return nil, nil
+	}
+	var ps []Parent
+	for _, o := range obs {
+		p, ok := asParent(o)
+		if !ok || r.fresh(p) {
+			continue
+		}
+		if !isGlob(part) {
+			if _, ok := r.childByName(p, part); ok {
+				continue
+			}
+		}
+		ps = append(ps, p)
+	}
+	if len(ps) < 2 {
+		return nil, nil
+	}
+	logger.Debug2("prefetching %d parents to match %q", len(ps), part)
+	var (
+		sem  = make(chan struct{}, r.Prefetch)
+		errs = make([]error, len(ps))
+		wg   sync.WaitGroup
+	)
+	for i, p := range ps {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, p Parent) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = p.update(r, c)
+		}(i, p)
+	}
+	wg.Wait()
+	prefetched := make(map[Parent]bool, len(ps))
+	for i, p := range ps {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		prefetched[p] = true
+	}
+	return prefetched, nil
+}
+
+// FolderByID gets a folder within the library by its ID.  If the folder
+// hasn't been loaded into the tree yet, the library's folders are updated
+// breadth-first until it is found.
+func (r *Root) FolderByID(c *web.Client, lib *Library, id int) (*Folder, error) {
+	r.mutex.RLock()
+	lfd, ok := r.idCache[id]
+	r.mutex.RUnlock()
+	if ok && lfd.Folder != nil {
+		return lfd.Folder, nil
+	}
+	ps := []Parent{lib}
+	for len(ps) > 0 {
+		p := ps[0]
+		ps = ps[1:]
+		if err := r.refresh(c, p); err != nil {
+			return nil, err
+		}
+		for _, ch := range r.childrenOf(p) {
+			f, ok := ch.(*Folder)
+			if !ok {
+				continue
+			}
+			if r.idOf(f) == id {
+				return f, nil
+			}
+			ps = append(ps, f)
+		}
+	}
+	return nil, ChildNotFound{ID: id}
+}
+
+// addDocument adds a document that was just created in f to the tree so that
+// f doesn't have to be updated to find it.
+func (r *Root) addDocument(f *Folder, wd web.Document) *Document {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	lfd := r.idCache[wd.DocumentID]
+	if lfd.Document == nil {
+		lfd.Document = &Document{Folder: f}
+	}
+	lfd.Document.Document = wd
+	f.objects.add(lfd.Document)
+	r.idCache[wd.DocumentID] = lfd
+	return lfd.Document
+}
+
+// remove removes a deleted library, folder, or document from the tree.
+func (r *Root) remove(o Object) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch p := o.Parent().(type) {
+	case *Root:
+		p.objects.del(o)
+	case *Library:
+		p.folders.objects.del(o)
+	case *Folder:
+		p.objects.del(o)
+	}
+	id := o.ID()
+	lfd := r.idCache[id]
+	switch o := o.(type) {
+	case *Library:
+		lfd.Library = nil
+		delete(r.updated, o)
+	case *Folder:
+		lfd.Folder = nil
+		delete(r.updated, o)
+	case *Document:
+		lfd.Document = nil
+	}
+	lfd.updateMap(r.idCache, id)
+}
+
+// idOf gets o's ID with the read lock held.
+func (r *Root) idOf(o Object) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return o.ID()
+}
+
+// pathOf gets o's full path with the read lock held.
+func (r *Root) pathOf(o Object) ShareBasePath {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return PathOf(o)
+}
+
+// nameOf gets o's name with the read lock held.
+func (r *Root) nameOf(o Object) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return o.Name()
+}
+
 // ID is a "dummy" function just to implement the Object interface.
 func (r *Root) ID() int { return 0 }
 
 // LibraryByName retrieves a library by its given name.
 func (r *Root) LibraryByName(name string) (*Library, error) {
-	c, ok := r.objects.ChildByName(name)
+	c, ok := r.childByName(r, name)
 	if !ok {
 		return nil, ChildNotFound{Name: name}
 	}
@@ -176,6 +611,8 @@ func (r *Root) update(r2 *Root, c *web.Client) error {
 	if err != nil {
 		return err
 	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	libs := objects{}
 	libs.init(len(wls))
 	for _, wl := range wls {
@@ -206,12 +643,16 @@ func (r *Root) update(r2 *Root, c *web.Client) error {
 		}
 	}
 	r.objects = libs
+	r.updated[r] = time.Now()
 	return nil
 }
 
 // updateObjects should only be called by Library and Folder's update
-// implementations.
+// implementations after they get the new state from ShareBase.  It takes the
+// write lock, so the caller must not hold the lock.
 func (r *Root) updateObjects(p Parent, obs *objects, wfs []web.Folder, wds []web.Document) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	logger.Debug2("%v current children: %#v", p.Name(), p.Children())
 	objects := new(objects)
 	objects.init(len(wfs) + len(wds))
@@ -230,7 +671,8 @@ func (r *Root) updateObjects(p Parent, obs *objects, wfs []web.Folder, wds []web
 		oldEmbed := lfd.Folder.Folder.Embedded
 		lfd.Folder.Folder = wf
 		lfd.Folder.Folder.Embedded = oldEmbed
-		objects.add(lfd.Folder)
+		lfd.Folder.alias = ""
+		r.addChildLocked(p, objects, lfd.Folder)
 		r.idCache[wf.FolderID] = lfd
 	}
 	for _, wd := range wds {
@@ -251,7 +693,8 @@ func (r *Root) updateObjects(p Parent, obs *objects, wfs []web.Folder, wds []web
 			}
 		}
 		lfd.Document.Document = wd
-		objects.add(lfd.Document)
+		lfd.Document.alias = ""
+		r.addChildLocked(p, objects, lfd.Document)
 		r.idCache[wd.DocumentID] = lfd
 	}
 	for _, c := range obs.Children() {
@@ -271,10 +714,53 @@ func (r *Root) updateObjects(p Parent, obs *objects, wfs []web.Folder, wds []web
 		}
 	}
 	*obs = *objects
+	r.updated[p] = time.Now()
 	logger.Debug2("%v new children: %#v", p.Name(), p.Children())
 	return nil
 }
 
+// addChildLocked adds c to p's new children, obs, while p is updated.
+// ShareBase allows more than one child of a folder to have the same name, but
+// the tree indexes children by name, so every child after the first with a
+// name gets an alias with its ID (see duplicateAlias) and a warning is logged
+// so that both can still be found.  The write lock must be held.
+func (r *Root) addChildLocked(p Parent, obs *objects, c Object) {
+	if _, added := obs.add(c); added {
+		return
+	}
+	if _, ok := obs.ids[c.ID()]; ok {
+		logger.Debug2("%v was listed in %v more than once", c.Name(), PathOf(p))
+		return
+	}
+	name := c.Name()
+	alias := duplicateAlias(name, c.ID())
+	switch c := c.(type) {
+	case *Document:
+		c.alias = alias
+	case *Folder:
+		c.alias = alias
+	}
+	if _, added := obs.add(c); !added {
+		logger.Warn(
+			"%v has more than one child named %q, and %q is taken "+
+				"too, so the one with ID %d is skipped",
+			PathOf(p), name, alias, c.ID())
+		return
+	}
+	logger.Warn(
+		"%v has more than one child named %q; the one with ID %d "+
+			"is %q instead",
+		PathOf(p), name, c.ID(), alias)
+}
+
+// duplicateAlias gets the name that a child named name with the given ID has
+// in the tree when another child already has that name.  The ID goes before
+// the extension so that patterns like *.pdf still match it.
+func duplicateAlias(name string, id int) string {
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), id, ext)
+}
+
 // Object is the basic interface implemented by all ShareBase objects
 type Object interface {
 	// ID gets the ID of the specific object.  IDs are not necessarily
@@ -298,6 +784,16 @@ type Object interface {
 	update(r *Root, c *web.Client) error
 }
 
+// asParent type-asserts o to a Parent.  Documents are never parents even
+// though they embed their Folder and so have its methods.
+func asParent(o Object) (Parent, bool) {
+	if _, ok := o.(*Document); ok {
+		return nil, false
+	}
+	p, ok := o.(Parent)
+	return p, ok
+}
+
 // ParentsOf climbs an object's Parent() chain until it gets to the root.
 // The returned slice is in child to parent order, with the last Parent being
 // the Root.
@@ -348,13 +844,79 @@ func Traverse(p Parent, f func(p Parent, c Object) error) error {
 			}
 			return err
 		}
-		if p, ok := pc.Child.(Parent); ok {
+		if p, ok := asParent(pc.Child); ok {
 			appendChildren(&pcs, p)
 		}
 	}
 	return nil
 }
 
+// SkipDir can be returned by a WalkObjects callback to skip the library or
+// folder it was called with.  Returned for a document, it skips the rest of
+// the document's folder.
+var SkipDir = errors.New("skip this folder")
+
+// WalkObjects walks the tree under root depth-first, calling fn with root and
+// every object under it along with their full paths.  Children are walked in
+// the order of their names.
+//
+// Libraries and folders are updated from ShareBase (unless they're still
+// fresh; see Root.TTL) after fn is called with them and right before their
+// children are walked, so the subtrees that fn skips by returning SkipDir
+// aren't requested at all.  fn must not modify or
+// keep path.  Any other error from fn or from updating a parent stops the
+// walk and is returned.
+func WalkObjects(root Parent, c *web.Client, fn func(path ShareBasePath, o Object) error) error {
+	err := walkObjects(rootOf(root), c, root, PathOf(root), fn)
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkObjects(r *Root, c *web.Client, p Parent, path ShareBasePath, fn func(path ShareBasePath, o Object) error) error {
+	if err := fn(path, p); err != nil {
+		return err
+	}
+	if err := r.refresh(c, p); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to update %v", path)
+	}
+	children := r.childrenOf(p)
+	names := make(map[Object]string, len(children))
+	for _, ch := range children {
+		names[ch] = r.nameOf(ch)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return names[children[i]] < names[children[j]]
+	})
+	for _, ch := range children {
+		chPath := append(path[:len(path):len(path)], names[ch])
+		if sub, ok := asParent(ch); ok {
+			if err := walkObjects(r, c, sub, chPath, fn); err != nil && err != SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := fn(chPath, ch); err != nil {
+			if err == SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rootOf gets the Root at the top of o's parents.
+func rootOf(o Object) *Root {
+	if r, ok := o.(*Root); ok {
+		return r
+	}
+	parents := ParentsOf(o)
+	return parents[len(parents)-1].(*Root)
+}
+
 // FolderContainer is a parent of Folder objects.  Its FolderByName function
 // probably calls a ChildByName function and then type-asserts the value to a
 // Folder.
@@ -422,7 +984,15 @@ func (l *Library) Parent() Parent { return l.Root }
 // update its own state because it assumes it was just updated with a previous
 // call to (*Root).update.
 func (l *Library) update(r *Root, c *web.Client) error {
-	wfs, err := l.Library.Folders(c)
+	r.mutex.RLock()
+	wl := l.Library
+	r.mutex.RUnlock()
+	if wl.LibraryID == 0 {
+		// like placeholder folders, placeholder libraries don't
+		// exist in ShareBase yet.
+		return nil
+	}
+	wfs, err := wl.Folders(c)
 	if err != nil {
 		return err
 	}
@@ -441,6 +1011,11 @@ type Folder struct {
 
 	// objects contains the documents and folders in the folder.
 	objects
+
+	// alias is the name that the folder has in the tree when its parent
+	// has another child with the same name.  It's empty otherwise.  See
+	// (*Root).addChildLocked.
+	alias string
 }
 
 func newFolder(p Parent, wf web.Folder) *Folder {
@@ -458,7 +1033,12 @@ func newFolder(p Parent, wf web.Folder) *Folder {
 func (f *Folder) ID() int { return f.Folder.FolderID }
 
 // Name implements the Object interface.
-func (f *Folder) Name() string { return f.Folder.FolderName }
+func (f *Folder) Name() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.Folder.FolderName
+}
 
 // Parent implements the Child interface.
 func (f *Folder) Parent() Parent { return f.DotDot }
@@ -500,26 +1080,133 @@ func (f *Folder) FolderByName(name string) (*Folder, error) {
 }
 
 func (f *Folder) update(r *Root, c *web.Client) error {
-	wf, err := f.getWebUpdaterFunc()(c, f.Folder.FolderID)
+	id := r.idOf(f)
+	if id == 0 {
+		// placeholder folders from dry runs don't exist in
+		// ShareBase, so there's nothing to update from.
+		return nil
+	}
+	// updateObjects replaces all of f's children, so both the documents
+	// and subfolders have to be embedded.
+	wf, err := f.getWebUpdaterFunc()(c, id, web.EmbedAll)
 	if err != nil {
 		return err
 	}
+	r.mutex.Lock()
+	// the refreshed state drops any size cached by web.Folder.Size.
+	f.Folder = wf
+	r.mutex.Unlock()
 	return r.updateObjects(
 		f, &f.objects, wf.Embedded.Folders, wf.Embedded.Documents)
 }
 
-func (f *Folder) getWebUpdaterFunc() func(c *web.Client, id int) (web.Folder, error) {
+func (f *Folder) getWebUpdaterFunc() func(c *web.Client, id int, embed web.Embed) (web.Folder, error) {
 	switch p := f.Parent().(type) {
 	case *Library:
-		return p.Library.Folder
+		return p.Library.FolderWithEmbed
 	case *Folder:
-		return p.Folder.Folder
+		return p.Folder.FolderWithEmbed
 	default:
 		panic(errors.Errorf(
 			"invalid parent type: %T", p))
 	}
 }
 
+// MoveTo moves the folder into dst, which must be a library or a folder in
+// the same library, and then moves it in the tree.  If dst already has a
+// child with the folder's name, it returns a web.AlreadyExists error.
+func (f *Folder) MoveTo(c *web.Client, dst Parent) error {
+	r := rootOf(f)
+	r.mutex.RLock()
+	wf, name, from := f.Folder, f.Name(), f.DotDot
+	for _, p := range append([]Parent{dst}, ParentsOf(dst)...) {
+		if p == Parent(f) {
+			r.mutex.RUnlock()
+			return errors.Errorf(
+				"cannot move %v into itself", PathOf(f))
+		}
+	}
+	_, exists := dst.ChildByName(name)
+	r.mutex.RUnlock()
+	if dst == from {
+		return nil
+	}
+	if exists {
+		return web.AlreadyExists{Kind: web.FolderKind, Name: name}
+	}
+	var err error
+	switch dst := dst.(type) {
+	case *Library:
+		err = wf.MoveToLibrary(c, &dst.Library)
+	case *Folder:
+		err = wf.MoveToFolder(c, &dst.Folder)
+	default:
+		return errors.Errorf(
+			"folders can only be moved into libraries or folders, "+
+				"not %v (type: %T)", dst, dst)
+	}
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to move %v: %v", r.pathOf(f), err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	objectsOf(from).del(f)
+	f.DotDot = dst
+	r.adoptLocked(dst, f)
+	return nil
+}
+
+// Rename renames the folder and then renames it in the tree.  If its parent
+// already has a child with the new name, it returns a web.AlreadyExists
+// error.
+func (f *Folder) Rename(c *web.Client, name string) error {
+	r := rootOf(f)
+	r.mutex.RLock()
+	wf, p := f.Folder, f.DotDot
+	_, exists := p.ChildByName(name)
+	r.mutex.RUnlock()
+	if exists {
+		return web.AlreadyExists{Kind: web.FolderKind, Name: name}
+	}
+	if err := wf.Rename(c, name); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to rename %v: %v", r.pathOf(f), err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// the children are indexed by name, so f has to be removed before
+	// its name changes.
+	objectsOf(p).del(f)
+	f.Folder.FolderName = name
+	r.adoptLocked(p, f)
+	return nil
+}
+
+// adoptLocked adds f to p's children after it was moved or renamed in
+// ShareBase.  If p already has a child with the same name, p's children are
+// out of date, so p is marked stale to be updated again the next time it's
+// used.  The write lock must be held.
+func (r *Root) adoptLocked(p Parent, f *Folder) {
+	// an alias only made f's old name unique in its old parent.
+	f.alias = ""
+	if _, added := objectsOf(p).add(f); !added {
+		delete(r.updated, p)
+	}
+}
+
+// objectsOf gets the children collection of a library or folder.
+func objectsOf(p Parent) *objects {
+	switch p := p.(type) {
+	case *Library:
+		return &p.folders.objects
+	case *Folder:
+		return &p.objects
+	default:
+		panic(errors.Errorf("invalid parent type: %T", p))
+	}
+}
+
 // Document is a ShareBase document.
 type Document struct {
 	// Folder is the ShareBase folder that holds this Document.
@@ -527,13 +1214,21 @@ type Document struct {
 
 	// Document holds the ShareBase API state of this document.
 	web.Document
+
+	// alias is like Folder.alias but for documents.
+	alias string
 }
 
 // ID implements the Object interface.
 func (d *Document) ID() int { return d.Document.DocumentID }
 
 // Name implements the Object interface.
-func (d *Document) Name() string { return d.Document.DocumentName }
+func (d *Document) Name() string {
+	if d.alias != "" {
+		return d.alias
+	}
+	return d.Document.DocumentName
+}
 
 // Parent implements the Child interface.
 func (d *Document) Parent() Parent { return d.Folder }
@@ -649,13 +1344,17 @@ func (o *objects) del(c Object) (deleted bool) {
 	if x0 != x1 {
 		return false
 	}
-	for i, c := range o.children[x0+1:] {
-		o.children[x0+i] = c
+	delete(o.ids, id)
+	delete(o.names, name)
+	last := len(o.children) - 1
+	copy(o.children[x0:], o.children[x0+1:])
+	// clear the last slot so it doesn't keep the moved object alive.
+	o.children[last] = nil
+	o.children = o.children[:last]
+	for i, c := range o.children[x0:] {
 		o.ids[c.ID()] = x0 + i
 		o.names[c.Name()] = x0 + i
 	}
-	delete(o.ids, o.children[len(o.children)-1].ID())
-	delete(o.names, o.children[len(o.children)-1].Name())
 	return true
 }
 
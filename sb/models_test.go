@@ -0,0 +1,822 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestObjectsDel(t *testing.T) {
+	var o objects
+	o.init(5)
+	docs := make([]*Document, 5)
+	for i := range docs {
+		docs[i] = &Document{Document: web.Document{
+			DocumentID:   i + 1,
+			DocumentName: fmt.Sprintf("doc%d", i+1),
+		}}
+		if _, ok := o.add(docs[i]); !ok {
+			t.Fatalf("failed to add %v", docs[i].Name())
+		}
+	}
+	if !o.del(docs[2]) {
+		t.Fatalf("failed to delete %v", docs[2].Name())
+	}
+	if o.del(docs[2]) {
+		t.Fatalf("deleted %v twice", docs[2].Name())
+	}
+	remaining := []*Document{docs[0], docs[1], docs[3], docs[4]}
+	if len(o.Children()) != len(remaining) {
+		t.Fatalf("expected %d children, got %d", len(remaining), len(o.Children()))
+	}
+	for i, d := range remaining {
+		if ch := o.Children()[i]; ch != d {
+			t.Fatalf("child %d: expected %v, got %v", i, d.Name(), ch.Name())
+		}
+		if ch, ok := o.ChildByName(d.Name()); !ok || ch != d {
+			t.Fatalf("ChildByName(%q): got %v, %v", d.Name(), ch, ok)
+		}
+		if ch, ok := o.ChildByID(d.ID()); !ok || ch != d {
+			t.Fatalf("ChildByID(%d): got %v, %v", d.ID(), ch, ok)
+		}
+	}
+	if _, ok := o.ChildByName(docs[2].Name()); ok {
+		t.Fatalf("found deleted %v by name", docs[2].Name())
+	}
+	if _, ok := o.ChildByID(docs[2].ID()); ok {
+		t.Fatalf("found deleted %v by ID", docs[2].Name())
+	}
+	// the last child can still be deleted and re-added.
+	if !o.del(docs[4]) {
+		t.Fatalf("failed to delete %v", docs[4].Name())
+	}
+	if _, ok := o.add(docs[4]); !ok {
+		t.Fatalf("failed to re-add %v", docs[4].Name())
+	}
+}
+
+// treeContents are the contents of serveTree's documents by their IDs.
+var treeContents = map[int]string{1: "aaaaaaaaaa", 2: "bbbbbbb", 3: "ccccc"}
+
+// serveTree serves a library, "Lib", with the folder "Top" holding the
+// subfolder "Sub" and the documents a.txt (10 bytes) and b.txt (whose size
+// isn't listed but is 7 bytes in its metadata).  Sub holds c.txt (5 bytes).
+// Sub can be moved and renamed, but the listings don't change afterwards.
+func serveTree(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	encode := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}
+	mux.HandleFunc("/api/libraries", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Library{{
+			LibraryID:   1,
+			LibraryName: "Lib",
+			Links:       web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+		}})
+	})
+	mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Folder{{FolderID: 10, FolderName: "Top", LibraryID: 1}})
+	})
+	mux.HandleFunc("/api/folders/10", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, web.Folder{
+			FolderID: 10, FolderName: "Top", LibraryID: 1,
+			Embedded: web.FolderEmbedded{
+				Folders: []web.Folder{{FolderID: 11, FolderName: "Sub", LibraryID: 1}},
+				Documents: []web.Document{
+					{
+						DocumentID: 1, DocumentName: "a.txt", FolderID: 10, Size: 10, Hash: []byte{1},
+						Links: web.DocumentLinks{Content: srv.URL + "/api/documents/1/content"},
+					},
+					{
+						DocumentID: 2, DocumentName: "b.txt", FolderID: 10,
+						Links: web.DocumentLinks{
+							Self:    srv.URL + "/api/documents/2",
+							Content: srv.URL + "/api/documents/2/content",
+						},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/folders/11", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, web.Folder{
+			FolderID: 11, FolderName: "Sub", LibraryID: 1,
+			Embedded: web.FolderEmbedded{
+				Documents: []web.Document{
+					{
+						DocumentID: 3, DocumentName: "c.txt", FolderID: 11, Size: 5, Hash: []byte{3},
+						Links: web.DocumentLinks{Content: srv.URL + "/api/documents/3/content"},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/folders/11/move", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/documents/2", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, web.Document{
+			DocumentID: 2, DocumentName: "b.txt", FolderID: 10,
+			Size: 7, Hash: []byte{2}, ContentType: "text/plain",
+		})
+	})
+	for id, content := range treeContents {
+		content := content
+		mux.HandleFunc(fmt.Sprintf("/api/documents/%d/content", id), func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(content))
+		})
+	}
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+// fakeShareBase is an in-memory ShareBase with a single library, "Lib", that
+// folders and small documents can be created in.
+type fakeShareBase struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	// mutex protects the fields below.
+	mutex     sync.Mutex
+	nextID    int
+	folders   map[int]*fakeFolder
+	documents map[int]*fakeDocument
+}
+
+type fakeFolder struct {
+	id, parent int
+	name       string
+}
+
+type fakeDocument struct {
+	id, folder    int
+	name, content string
+	modified      time.Time
+}
+
+func newFakeShareBase(t *testing.T) *fakeShareBase {
+	fs := &fakeShareBase{
+		t:         t,
+		folders:   make(map[int]*fakeFolder),
+		documents: make(map[int]*fakeDocument),
+	}
+	fs.srv = httptest.NewServer(http.HandlerFunc(fs.serveHTTP))
+	return fs
+}
+
+// addFolder adds a folder named name to the folder with the parent ID, or to
+// the top of the library if parent is 0.
+func (fs *fakeShareBase) addFolder(parent int, name string) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.addFolderLocked(parent, name)
+}
+
+func (fs *fakeShareBase) addFolderLocked(parent int, name string) int {
+	fs.nextID++
+	fs.folders[fs.nextID] = &fakeFolder{id: fs.nextID, parent: parent, name: name}
+	return fs.nextID
+}
+
+func (fs *fakeShareBase) addDocument(folder int, name, content string, modified time.Time) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.nextID++
+	fs.documents[fs.nextID] = &fakeDocument{
+		id: fs.nextID, folder: folder, name: name, content: content,
+		modified: modified,
+	}
+	return fs.nextID
+}
+
+// childFolder gets the ID of parent's folder named name or 0 if there isn't
+// one.
+func (fs *fakeShareBase) childFolder(parent int, name string) int {
+	for id, f := range fs.folders {
+		if f.parent == parent && f.name == name {
+			return id
+		}
+	}
+	return 0
+}
+
+// tree gets the paths of all of the folders and documents under the folder
+// with the given ID mapped to the documents' contents.  Folders' paths end
+// with a slash.
+func (fs *fakeShareBase) tree(id int) map[string]string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	tree := make(map[string]string)
+	var walk func(id int, prefix string)
+	walk = func(id int, prefix string) {
+		for _, f := range fs.folders {
+			if f.parent == id {
+				tree[prefix+f.name+"/"] = ""
+				walk(f.id, prefix+f.name+"/")
+			}
+		}
+		for _, d := range fs.documents {
+			if d.folder == id {
+				tree[prefix+d.name] = d.content
+			}
+		}
+	}
+	walk(id, "")
+	return tree
+}
+
+// deleteFolderLocked deletes the folder with the given ID and everything in
+// it.
+func (fs *fakeShareBase) deleteFolderLocked(id int) {
+	for cid, f := range fs.folders {
+		if f.parent == id {
+			fs.deleteFolderLocked(cid)
+		}
+	}
+	for did, d := range fs.documents {
+		if d.folder == id {
+			delete(fs.documents, did)
+		}
+	}
+	delete(fs.folders, id)
+}
+
+// numDocuments gets the number of documents in the folder with the given ID,
+// which the tree doesn't show if some of them have the same name.
+func (fs *fakeShareBase) numDocuments(folder int) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	n := 0
+	for _, d := range fs.documents {
+		if d.folder == folder {
+			n++
+		}
+	}
+	return n
+}
+
+func sha1Sum(content string) []byte {
+	sum := sha1.Sum([]byte(content))
+	return sum[:]
+}
+
+func (fs *fakeShareBase) folderJSON(f *fakeFolder, embed bool) web.Folder {
+	wf := web.Folder{
+		FolderID:   f.id,
+		FolderName: f.name,
+		LibraryID:  1,
+		Links: web.FolderLinks{
+			Self:      fmt.Sprintf("%v/api/folders/%d", fs.srv.URL, f.id),
+			Documents: fmt.Sprintf("%v/api/folders/%d/documents", fs.srv.URL, f.id),
+		},
+	}
+	if !embed {
+		return wf
+	}
+	for _, c := range fs.folders {
+		if c.parent == f.id {
+			wf.Embedded.Folders = append(wf.Embedded.Folders, fs.folderJSON(c, false))
+		}
+	}
+	for _, d := range fs.documents {
+		if d.folder == f.id {
+			wf.Embedded.Documents = append(wf.Embedded.Documents, fs.documentJSON(d))
+		}
+	}
+	return wf
+}
+
+func (fs *fakeShareBase) documentJSON(d *fakeDocument) web.Document {
+	return web.Document{
+		DocumentID:   d.id,
+		DocumentName: d.name,
+		FolderID:     d.folder,
+		Size:         int64(len(d.content)),
+		DateModified: d.modified,
+		Hash:         sha1Sum(d.content),
+		Links: web.DocumentLinks{
+			Self:    fmt.Sprintf("%v/api/documents/%d", fs.srv.URL, d.id),
+			Content: fmt.Sprintf("%v/api/documents/%d/content", fs.srv.URL, d.id),
+		},
+	}
+}
+
+func (fs *fakeShareBase) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	encode := func(v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			fs.t.Error(err)
+		}
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var id int
+	if len(parts) > 2 {
+		id, _ = strconv.Atoi(parts[2])
+	}
+	switch {
+	case r.URL.Path == "/api/libraries":
+		encode([]web.Library{{
+			LibraryID:   1,
+			LibraryName: "Lib",
+			Links: web.LibraryLinks{
+				Self:    fs.srv.URL + "/api/libraries/1",
+				Folders: fs.srv.URL + "/api/libraries/1/folders",
+			},
+		}})
+	case r.URL.Path == "/api/libraries/1/folders" && r.Method == http.MethodPost:
+		var req web.NewFolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fs.t.Error(err)
+		}
+		parent := 0
+		for _, name := range strings.Split(req.FolderPath, "\\") {
+			child := fs.childFolder(parent, name)
+			if child == 0 {
+				child = fs.addFolderLocked(parent, name)
+			}
+			parent = child
+		}
+		encode(fs.folderJSON(fs.folders[parent], false))
+	case r.URL.Path == "/api/libraries/1/folders":
+		folders := []web.Folder{}
+		for _, f := range fs.folders {
+			if f.parent == 0 {
+				folders = append(folders, fs.folderJSON(f, false))
+			}
+		}
+		encode(folders)
+	case len(parts) == 3 && parts[1] == "folders" && fs.folders[id] != nil && r.Method == http.MethodDelete:
+		fs.deleteFolderLocked(id)
+	case len(parts) == 3 && parts[1] == "folders" && fs.folders[id] != nil:
+		encode(fs.folderJSON(fs.folders[id], true))
+	case len(parts) == 3 && parts[1] == "documents" && fs.documents[id] != nil && r.Method == http.MethodDelete:
+		delete(fs.documents, id)
+	case len(parts) == 4 && parts[1] == "folders" && parts[3] == "documents":
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			fs.t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, err := ioutil.ReadAll(file)
+		if err != nil {
+			fs.t.Error(err)
+		}
+		fs.nextID++
+		d := &fakeDocument{
+			id: fs.nextID, folder: id, name: header.Filename,
+			content: string(content), modified: time.Now(),
+		}
+		fs.documents[d.id] = d
+		encode(fs.documentJSON(d))
+	case len(parts) == 4 && parts[1] == "documents" && parts[3] == "content" && fs.documents[id] != nil:
+		io.WriteString(w, fs.documents[id].content)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRootConcurrentTraversal(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	newClient := func() *web.Client {
+		c, err := web.NewClient(srv.URL, "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	r := NewRoot()
+	c := newClient()
+	o, err := r.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := o.(*Folder)
+	lib, err := r.LibraryByName("Lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// keep refreshing while the others traverse.
+		defer wg.Done()
+		c := newClient()
+		for i := 0; i < n; i++ {
+			for _, p := range []Parent{r, lib, top} {
+				if err := p.update(r, c); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := newClient()
+			for i := 0; i < n; i++ {
+				o, err := r.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top", "Sub", "c.txt"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if id := r.idOf(o); id != 3 {
+					t.Errorf("expected c.txt's ID to be 3, got %d", id)
+					return
+				}
+				obs, err := r.ObjectsByPath(c, nil, ShareBasePath{"Lib", "Top", "*.txt"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(obs) != 2 {
+					t.Errorf("expected 2 matches, got %d", len(obs))
+					return
+				}
+				if _, err = r.FolderByID(c, lib, 11); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRootTTL(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoot()
+	r.TTL = time.Hour
+	o, err := r.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := o.(*Folder)
+	missing := ShareBasePath{"Lib", "Top", "missing.txt"}
+	if _, err = r.ObjectByPath(c, nil, missing); err == nil {
+		t.Fatal("expected missing.txt to be missing")
+	}
+	n := c.NumRequests()
+	// Top was just updated, so looking it up again is free.
+	if _, err = r.ObjectByPath(c, nil, missing); err == nil {
+		t.Fatal("expected missing.txt to be missing")
+	}
+	if _, ok := err.(ChildNotFound); !ok {
+		t.Fatalf("expected ChildNotFound, got %v", err)
+	}
+	if n2 := c.NumRequests(); n2 != n {
+		t.Fatalf("expected no requests while fresh, got %d", n2-n)
+	}
+	if err = r.ForceRefresh(c, top); err != nil {
+		t.Fatal(err)
+	}
+	if n2 := c.NumRequests(); n2 != n+1 {
+		t.Fatalf("expected ForceRefresh to make 1 request, got %d", n2-n)
+	}
+	r.TTL = 0
+	if _, err = r.ObjectByPath(c, nil, missing); err == nil {
+		t.Fatal("expected missing.txt to be missing")
+	}
+	if n2 := c.NumRequests(); n2 != n+2 {
+		t.Fatalf("expected a request without a TTL, got %d", n2-n-1)
+	}
+}
+
+// serveWideTree serves a library with a single top folder, 1, where every
+// folder n has the subfolders 2n ("a") and 2n+1 ("b") down to depth levels
+// and the folders at the bottom each have one document, x.txt.  Each folder
+// listing takes delay.
+func serveWideTree(t *testing.T, depth int, delay time.Duration) *httptest.Server {
+	var srv *httptest.Server
+	encode := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/libraries", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Library{{
+			LibraryID:   1,
+			LibraryName: "Lib",
+			Links:       web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+		}})
+	})
+	mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Folder{{FolderID: 1, FolderName: "top", LibraryID: 1}})
+	})
+	mux.HandleFunc("/api/folders/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		var id int
+		if _, err := fmt.Sscanf(r.URL.Path, "/api/folders/%d", &id); err != nil {
+			t.Error(err)
+			return
+		}
+		f := web.Folder{FolderID: id, LibraryID: 1}
+		// folder n is at level log2(n) + 1.
+		level := 0
+		for n := id; n > 0; n >>= 1 {
+			level++
+		}
+		if level < depth {
+			f.Embedded.Folders = []web.Folder{
+				{FolderID: 2 * id, FolderName: "a", LibraryID: 1},
+				{FolderID: 2*id + 1, FolderName: "b", LibraryID: 1},
+			}
+		} else {
+			f.Embedded.Documents = []web.Document{
+				{DocumentID: 1000 + id, DocumentName: "x.txt", FolderID: id},
+			}
+		}
+		encode(w, f)
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestRootPrefetch(t *testing.T) {
+	const (
+		depth = 5
+		delay = 20 * time.Millisecond
+	)
+	srv := serveWideTree(t, depth, delay)
+	defer srv.Close()
+	pattern := ShareBasePath{"Lib", "top", "*", "*", "*", "*", "x.txt"}
+	elapsed := make(map[int]time.Duration)
+	for _, prefetch := range []int{0, 4} {
+		c, err := web.NewClient(srv.URL, "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewRoot()
+		r.Prefetch = prefetch
+		start := time.Now()
+		obs, err := r.ObjectsByPath(c, nil, pattern)
+		elapsed[prefetch] = time.Since(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(obs) != 1<<(depth-1) {
+			t.Fatalf("expected %d documents, got %d", 1<<(depth-1), len(obs))
+		}
+		// every folder is listed exactly once either way.
+		if got, expected := c.NumRequests(), uint64(2+(1<<depth)-1); got != expected {
+			t.Fatalf("with prefetch %d: expected %d requests, got %d", prefetch, expected, got)
+		}
+		t.Logf("prefetch %d: %v", prefetch, elapsed[prefetch])
+	}
+	if elapsed[4] >= elapsed[0]/2 {
+		t.Fatalf("expected prefetching to at least halve %v, got %v", elapsed[0], elapsed[4])
+	}
+}
+
+func TestRootIgnoreCase(t *testing.T) {
+	var srv *httptest.Server
+	encode := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/libraries", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Library{{
+			LibraryID:   1,
+			LibraryName: "Lib",
+			Links:       web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+		}})
+	})
+	mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, []web.Folder{
+			{FolderID: 10, FolderName: "Documents", LibraryID: 1},
+			{FolderID: 11, FolderName: "Notes", LibraryID: 1},
+			{FolderID: 12, FolderName: "notes", LibraryID: 1},
+		})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoot()
+	if _, err = r.ObjectByPath(c, nil, ShareBasePath{"lib", "documents"}); err == nil {
+		t.Fatal("expected paths to be case-sensitive by default")
+	} else if _, ok := err.(ChildNotFound); !ok {
+		t.Fatalf("expected ChildNotFound, got %v", err)
+	}
+	r.IgnoreCase = true
+	o, err := r.ObjectByPath(c, nil, ShareBasePath{"lib", "documents"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PathOf(o).String(); got != "sb:Lib/Documents" {
+		t.Fatalf("expected sb:Lib/Documents, got %v", got)
+	}
+	// exact matches win over ones that only differ by case.
+	if o, err = r.ObjectByPath(c, nil, ShareBasePath{"Lib", "notes"}); err != nil {
+		t.Fatal(err)
+	} else if o.ID() != 12 {
+		t.Fatalf("expected folder 12, got %d", o.ID())
+	}
+	_, err = r.ObjectByPath(c, nil, ShareBasePath{"Lib", "NOTES"})
+	if a, ok := err.(AmbiguousName); !ok || len(a.Matches) != 2 || a.Matches[0] != "Notes" {
+		t.Fatalf("expected an AmbiguousName error, got %v", err)
+	}
+}
+
+func TestWalkObjects(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoot()
+	lib, err := r.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk := func(skip string) (paths []string) {
+		err := WalkObjects(lib.(Parent), c, func(p ShareBasePath, o Object) error {
+			paths = append(paths, p.String())
+			if o.Name() == skip {
+				return SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+	got := strings.Join(walk(""), " ")
+	expected := "sb:Lib sb:Lib/Top sb:Lib/Top/Sub sb:Lib/Top/Sub/c.txt sb:Lib/Top/a.txt sb:Lib/Top/b.txt"
+	if got != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+	// skipping Sub keeps it from being updated, and skipping at a.txt
+	// skips b.txt, too.
+	n := c.NumRequests()
+	got = strings.Join(walk("Sub"), " ")
+	if expected = "sb:Lib sb:Lib/Top sb:Lib/Top/Sub sb:Lib/Top/a.txt sb:Lib/Top/b.txt"; got != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+	if requests := c.NumRequests() - n; requests != 2 {
+		t.Fatalf("expected Lib and Top to be updated, got %d requests", requests)
+	}
+	got = strings.Join(walk("a.txt"), " ")
+	if expected = "sb:Lib sb:Lib/Top sb:Lib/Top/Sub sb:Lib/Top/Sub/c.txt sb:Lib/Top/a.txt"; got != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestFolderMoveTo(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoot()
+	lookup := func(path ...string) Object {
+		o, err := r.ObjectByPath(c, nil, ShareBasePath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	lib := lookup("Lib").(*Library)
+	top := lookup("Lib", "Top").(*Folder)
+	doc := lookup("Lib", "Top", "Sub", "c.txt")
+	sub := lookup("Lib", "Top", "Sub").(*Folder)
+	if err = top.MoveTo(c, sub); err == nil {
+		t.Fatal("expected an error moving a folder into its subfolder")
+	}
+	if err = sub.MoveTo(c, lib); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := top.ChildByName("Sub"); ok {
+		t.Fatal("expected Sub to be removed from Top")
+	}
+	if o, ok := lib.ChildByName("Sub"); !ok || o != sub {
+		t.Fatal("expected Sub to be in Lib")
+	}
+	if got := PathOf(doc).String(); got != "sb:Lib/Sub/c.txt" {
+		t.Fatalf("expected c.txt to move with Sub, got %v", got)
+	}
+	if err = sub.Rename(c, "Top"); err != (web.AlreadyExists{Kind: web.FolderKind, Name: "Top"}) {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if err = sub.Rename(c, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := lib.ChildByName("Renamed"); !ok || o != sub {
+		t.Fatal("expected Sub to be renamed in Lib")
+	}
+	if got := PathOf(doc).String(); got != "sb:Lib/Renamed/c.txt" {
+		t.Fatalf("expected c.txt to be in the renamed folder, got %v", got)
+	}
+}
+
+func TestGetOrCreateFolderMissing(t *testing.T) {
+	fs := newFakeShareBase(t)
+	defer fs.srv.Close()
+	top := fs.addFolder(0, "Top")
+	c, err := web.NewClient(fs.srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoot()
+	// ParentByPath wraps the error, but it's still a missing parent.
+	_, _, err = r.ParentByPath(c, nil, ShareBasePath{"Lib", "Top", "New", "a.txt"})
+	if !isChildNotFound(err) {
+		t.Fatalf("expected a ChildNotFound, got %v", err)
+	}
+	f, err := r.GetOrCreateFolder(c, nil, ShareBasePath{"Lib", "Top", "New", "Deeper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PathOf(f).String(); got != "sb:Lib/Top/New/Deeper" {
+		t.Fatalf("expected sb:Lib/Top/New/Deeper, got %v", got)
+	}
+	fs.mutex.Lock()
+	created := fs.childFolder(fs.childFolder(top, "New"), "Deeper")
+	fs.mutex.Unlock()
+	if created == 0 || f.ID() != created {
+		t.Fatalf("expected folder %d to be created, got %d", created, f.ID())
+	}
+	p, base, err := r.ParentByPath(c, nil, ShareBasePath{"Lib", "Top", "New", "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PathOf(p).String(); got != "sb:Lib/Top/New" || base != "a.txt" {
+		t.Fatalf("expected sb:Lib/Top/New and a.txt, got %v and %v", got, base)
+	}
+}
+
+func TestUpdateObjectsDuplicateNames(t *testing.T) {
+	r := NewRoot()
+	lib := newLibrary(r, web.Library{LibraryID: 1, LibraryName: "Lib"})
+	f := newFolder(lib, web.Folder{FolderID: 10, FolderName: "Top", LibraryID: 1})
+	if err := r.updateObjects(f, &f.objects, []web.Folder{
+		{FolderID: 11, FolderName: "Sub", LibraryID: 1},
+		{FolderID: 12, FolderName: "Sub", LibraryID: 1},
+	}, []web.Document{
+		{DocumentID: 1, DocumentName: "a.txt", FolderID: 10},
+		{DocumentID: 2, DocumentName: "a.txt", FolderID: 10},
+		{DocumentID: 3, DocumentName: "b.txt", FolderID: 10},
+		// listed twice, but it's the same document.
+		{DocumentID: 3, DocumentName: "b.txt", FolderID: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.Children()); n != 5 {
+		t.Fatalf("expected 5 children, got %d", n)
+	}
+	for name, id := range map[string]int{
+		"a.txt": 1, "a (2).txt": 2, "b.txt": 3, "Sub": 11, "Sub (12)": 12,
+	} {
+		c, ok := f.ChildByName(name)
+		if !ok || c.ID() != id {
+			t.Fatalf("expected %q to be %d, got %v", name, id, c)
+		}
+	}
+	c, _ := f.ChildByName("a (2).txt")
+	if got := PathOf(c).String(); got != "sb:Lib/Top/a (2).txt" {
+		t.Fatalf("expected sb:Lib/Top/a (2).txt, got %v", got)
+	}
+	// once the first one is gone, the other one has its own name again.
+	if err := r.updateObjects(f, &f.objects, nil, []web.Document{
+		{DocumentID: 2, DocumentName: "a.txt", FolderID: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := f.ChildByName("a.txt"); !ok || c.ID() != 2 {
+		t.Fatalf("expected a.txt to be 2, got %v", c)
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
 )
 
 // PathSeparator is the separator used to describe paths in ShareBase from
@@ -20,15 +21,27 @@ const PathSeparator = "/"
 // Path is the interface implemented by all filesystem paths, either local
 // or in ShareBase.
 type Path interface {
+	// Base gets the last element of the path, or "" if the path is
+	// empty.
+	Base() string
+
 	// Dir gets the parent directory of this path.
 	Dir() Path
 
 	// Elem gets a path element by its index.
 	Elem(index int) string
 
+	// Ext gets the extension of the path's Base the same way as
+	// path.Ext: everything from the last dot, or "" if there isn't one.
+	Ext() string
+
 	// Len gets the number of elements in the path.
 	Len() int
 
+	// Join gets a new path of the same type with elems appended to this
+	// path.  This path isn't modified.
+	Join(elems ...string) Path
+
 	// String produces a string representation of the path.
 	String() string
 }
@@ -48,22 +61,34 @@ func PathOf(o Object) ShareBasePath {
 	return ShareBasePath(parts)
 }
 
-// Basename gets a path's base name (i.e. the last element of the path).
-func Basename(p Path) string {
-	length := p.Len()
-	if length == 0 {
+// RelativePathOf gets the path of o relative to root.  o must be nested
+// somewhere under root.
+func RelativePathOf(root Parent, o Object) ShareBasePath {
+	return PathOf(o)[PathOf(root).Len():]
+}
+
+// Basename gets a path's base name (i.e. the last element of the path).  It's
+// the same as p.Base().
+func Basename(p Path) string { return p.Base() }
+
+// lastElem gets the last of elems or "" if there aren't any.
+func lastElem(elems []string) string {
+	if len(elems) == 0 {
 		return ""
 	}
-	return p.Elem(length - 1)
+	return elems[len(elems)-1]
 }
 
 // LocalPath describes a path to somewhere on the local device's filesystem.
 type LocalPath []string
 
-// LocalPathFromString creates a LocalPath from the given path string.
+// LocalPathFromString creates a LocalPath from the given path string.  Both
+// forward slashes and backslashes separate elements on every OS so that
+// Windows paths (and tar headers written on Windows) can be used anywhere.
+// A drive letter like "C:" is kept as the first element.
 func LocalPathFromString(v string) LocalPath {
-	v = filepath.Clean(v)
-	return LocalPath(strings.Split(v, string(os.PathSeparator)))
+	v = path.Clean(strings.Replace(v, "\\", "/", -1))
+	return LocalPath(strings.Split(v, "/"))
 }
 
 // LocalPathFromPaths creates a single LocalPath from the given path parts
@@ -79,15 +104,26 @@ func (p LocalPath) Copy() LocalPath {
 	return p2
 }
 
+// Base implements the Path interface.
+func (p LocalPath) Base() string { return lastElem(p) }
+
 // Dir implements the Path interface.
 func (p LocalPath) Dir() Path { return p[:len(p)-1] }
 
 // Elem implements the Path interface.
 func (p LocalPath) Elem(index int) string { return p[index] }
 
+// Ext implements the Path interface.
+func (p LocalPath) Ext() string { return path.Ext(p.Base()) }
+
 // Len implements the Path interface.
 func (p LocalPath) Len() int { return len(p) }
 
+// Join implements the Path interface.
+func (p LocalPath) Join(elems ...string) Path {
+	return append(p[:len(p):len(p)], elems...)
+}
+
 // String implements the Path interface.
 func (p LocalPath) String() string { return filepath.Join([]string(p)...) }
 
@@ -111,28 +147,77 @@ func ShareBasePathFromString(v string) ShareBasePath {
 	v = path.Clean(v)
 	elems := strings.Split(v, PathSeparator)
 	if len(elems) > 0 {
-		if elems[0] == "my" {
-			elems[0] = "My Library"
+		if lib, ok := libraryAliases[elems[0]]; ok {
+			elems[0] = lib
 		}
 	}
+	cleanShareBaseElems(elems)
+	return ShareBasePath(elems)
+}
+
+// defaultPersonalLibrary is the library that the "my" alias refers to unless
+// the configuration names a different one.
+const defaultPersonalLibrary = "My Library"
+
+// libraryAliases maps shorthand names that can start ShareBase paths to the
+// full names of the libraries they refer to.
+var libraryAliases = map[string]string{"my": defaultPersonalLibrary}
+
+// setLibraryAliases replaces libraryAliases with the configuration's aliases.
+// The configuration's PersonalLibrary takes precedence over a "my" alias, and
+// "my" falls back to defaultPersonalLibrary if neither is set.
+func setLibraryAliases(cfg Config) {
+	aliases := make(map[string]string, len(cfg.LibraryAliases)+1)
+	for alias, lib := range cfg.LibraryAliases {
+		aliases[alias] = lib
+	}
+	if cfg.PersonalLibrary != "" {
+		aliases["my"] = cfg.PersonalLibrary
+	} else if _, ok := aliases["my"]; !ok {
+		aliases["my"] = defaultPersonalLibrary
+	}
+	libraryAliases = aliases
+}
+
+// cleanShareBaseElems replaces the elements of elems with their
+// cleanShareBaseElem results, warning about each one that changed.
+func cleanShareBaseElems(elems []string) {
 	for i, elem := range elems {
-		fixed := strings.Join(
-			allowedShareBaseRegexp.FindAllString(
-				elem, -1),
-			"")
+		fixed := cleanShareBaseElem(elem)
 		if elem != fixed {
 			logger.Warn(
-				"invalid ShareBase path element: %q " +
+				"invalid ShareBase path element: %q "+
 					"changed to: %q",
 				elem, fixed)
 			elems[i] = fixed
 		}
 	}
-	return ShareBasePath(elems)
 }
 
-var allowedShareBaseRegexp = regexp.MustCompile(
-	"[0-9A-Za-z_\\.\\-\\+ ]+")
+// cleanShareBaseElem removes the characters from a path element that can't be
+// in a ShareBase name with web.CleanName.  The glob characters are allowed so
+// that path elements can be patterns.
+func cleanShareBaseElem(elem string) string {
+	return web.CleanName(elem)
+}
+
+// globChars are the characters that make a path element a pattern matched
+// with path.Match instead of a literal name.
+const globChars = "*?["
+
+// HasGlob checks if any element of the path is a glob pattern.
+func HasGlob(p Path) bool {
+	for i := 0; i < p.Len(); i++ {
+		if isGlob(p.Elem(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(elem string) bool {
+	return strings.ContainsAny(elem, globChars)
+}
 
 // Copy creates a copy of the ShareBasePath.
 func (p ShareBasePath) Copy() ShareBasePath {
@@ -141,15 +226,59 @@ func (p ShareBasePath) Copy() ShareBasePath {
 	return p2
 }
 
+// Resolve resolves p against base, the full path (starting with the library)
+// that p is relative to.  "." elements are dropped and ".." elements remove
+// the element before them.  If p is absolute, meaning it came from a string
+// that started with a "/" (like "sb:/Lib/Documents") so its first element is
+// empty, base is ignored and p is only cleaned.  A ".." that would go above
+// the libraries is an error instead of being ignored like it is by
+// path.Clean.
+func (p ShareBasePath) Resolve(base ShareBasePath) (ShareBasePath, error) {
+	elems := []string(p)
+	resolved := base.Copy()
+	if len(elems) > 0 && elems[0] == "" {
+		elems, resolved = elems[1:], resolved[:0]
+	}
+	for _, elem := range elems {
+		switch elem {
+		case "", ".":
+		case "..":
+			if len(resolved) == 0 {
+				return nil, errors.Errorf(
+					"%q goes above the root from %v",
+					strings.Join(p, PathSeparator), base)
+			}
+			resolved = resolved[:len(resolved)-1]
+		default:
+			resolved = append(resolved, elem)
+		}
+	}
+	return resolved, nil
+}
+
+// Base implements the Path interface.
+func (p ShareBasePath) Base() string { return lastElem(p) }
+
 // Dir implements the Path interface.
 func (p ShareBasePath) Dir() Path { return p[:len(p)-1] }
 
 // Elem implements the Path interface.
 func (p ShareBasePath) Elem(index int) string { return p[index] }
 
+// Ext implements the Path interface.
+func (p ShareBasePath) Ext() string { return path.Ext(p.Base()) }
+
 // Len implements the Path interface.
 func (p ShareBasePath) Len() int { return len(p) }
 
+// Join implements the Path interface.  Like with ShareBasePathFromString,
+// characters that can't be in ShareBase names are removed from elems.
+func (p ShareBasePath) Join(elems ...string) Path {
+	joined := append(p[:len(p):len(p)], elems...)
+	cleanShareBaseElems(joined[len(p):])
+	return joined
+}
+
 // String implements the Path interface.
 func (p ShareBasePath) String() string {
 	return shareBaseURIScheme + path.Join([]string(p)...)
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShareBasePathFromString(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want ShareBasePath
+	}{
+		{"sb:my/Documents/Résumé.pdf", ShareBasePath{"My Library", "Documents", "Résumé.pdf"}},
+		{"sb:Bibliothèque/日本語/報告書.txt", ShareBasePath{"Bibliothèque", "日本語", "報告書.txt"}},
+		{"sb:my/Ünïcödé (copy) #2.txt", ShareBasePath{"My Library", "Ünïcödé (copy) #2.txt"}},
+		{"sb:my/Documents/*.pdf", ShareBasePath{"My Library", "Documents", "*.pdf"}},
+		{"sb:my/a:b|c<d>\"e\".txt", ShareBasePath{"My Library", "abcde.txt"}},
+		{"sb:my/bad\tname\x00.txt", ShareBasePath{"My Library", "badname.txt"}},
+	} {
+		if got := ShareBasePathFromString(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ShareBasePathFromString(%q): expected %q, got %q", tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestLibraryAliases(t *testing.T) {
+	defer setLibraryAliases(Config{})
+	setLibraryAliases(Config{
+		PersonalLibrary: "Personal",
+		LibraryAliases:  map[string]string{"it": "IT Library", "my": "ignored"},
+	})
+	for _, tc := range []struct {
+		in   string
+		want ShareBasePath
+	}{
+		{"sb:my/Documents", ShareBasePath{"Personal", "Documents"}},
+		{"sb:it/Installers", ShareBasePath{"IT Library", "Installers"}},
+		{"sb:Other/my", ShareBasePath{"Other", "my"}},
+	} {
+		if got := ShareBasePathFromString(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ShareBasePathFromString(%q): expected %q, got %q", tc.in, tc.want, got)
+		}
+	}
+	setLibraryAliases(Config{})
+	if got := ShareBasePathFromString("sb:my"); !reflect.DeepEqual(got, ShareBasePath{defaultPersonalLibrary}) {
+		t.Errorf("expected the default personal library, got %q", got)
+	}
+}
+
+func TestLocalPathFromString(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want LocalPath
+	}{
+		{`C:\Users\x\file.txt`, LocalPath{"C:", "Users", "x", "file.txt"}},
+		{`dir\sub/file.txt`, LocalPath{"dir", "sub", "file.txt"}},
+		{`dir\\sub\.\..\other/`, LocalPath{"dir", "other"}},
+		{"dir/sub/file.txt", LocalPath{"dir", "sub", "file.txt"}},
+		{"file.txt", LocalPath{"file.txt"}},
+	} {
+		if got := LocalPathFromString(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("LocalPathFromString(%q): expected %q, got %q", tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestShareBasePathResolve(t *testing.T) {
+	base := ShareBasePath{"Lib", "Documents", "2024"}
+	for _, tc := range []struct {
+		in   string
+		want ShareBasePath
+	}{
+		{"sb:report.pdf", ShareBasePath{"Lib", "Documents", "2024", "report.pdf"}},
+		{"sb:./report.pdf", ShareBasePath{"Lib", "Documents", "2024", "report.pdf"}},
+		{"sb:../2023/report.pdf", ShareBasePath{"Lib", "Documents", "2023", "report.pdf"}},
+		{"sb:../../..", ShareBasePath{}},
+		{"sb:.", ShareBasePath{"Lib", "Documents", "2024"}},
+		{"sb:/Other/Documents", ShareBasePath{"Other", "Documents"}},
+		{"sb:/Other/../Lib", ShareBasePath{"Lib"}},
+		{"sb:../../../..", nil},
+	} {
+		got, err := ShareBasePathFromString(tc.in).Resolve(base)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.want, got)
+		}
+	}
+	// the base is left alone.
+	if _, err := (ShareBasePath{"..", "x"}).Resolve(base); err != nil || len(base) != 3 || base[2] != "2024" {
+		t.Fatalf("expected base to be unchanged, got %q (%v)", base, err)
+	}
+}
+
+func TestPathJoin(t *testing.T) {
+	sb := ShareBasePath{"Lib", "Documents"}
+	if got := sb.Join("a:b.txt"); !reflect.DeepEqual(got, ShareBasePath{"Lib", "Documents", "ab.txt"}) {
+		t.Errorf("expected a cleaned ShareBasePath, got %#v", got)
+	}
+	local := LocalPath{"home", "me"}
+	if got := local.Join("a:b.txt"); !reflect.DeepEqual(got, LocalPath{"home", "me", "a:b.txt"}) {
+		t.Errorf("expected a LocalPath, got %#v", got)
+	}
+	// joining twice onto the same path mustn't share its backing array.
+	a, b := sb.Join("a"), sb.Join("b")
+	if a.Elem(2) != "a" || b.Elem(2) != "b" || len(sb) != 2 {
+		t.Fatalf("expected independent paths, got %q and %q", a, b)
+	}
+}
+
+func TestPathBaseExt(t *testing.T) {
+	for _, tc := range []struct {
+		elems []string
+		base  string
+		ext   string
+	}{
+		{[]string{"Lib", "Top", "a.txt"}, "a.txt", ".txt"},
+		{[]string{"Lib", "backup.tar.gz"}, "backup.tar.gz", ".gz"},
+		{[]string{"Lib", "v1.2.3", "README"}, "README", ""},
+		{[]string{"Lib", ".profile"}, ".profile", ".profile"},
+		{[]string{"Lib", "trailing."}, "trailing.", "."},
+		{[]string{"Lib"}, "Lib", ""},
+		{nil, "", ""},
+	} {
+		for _, p := range []Path{ShareBasePath(tc.elems), LocalPath(tc.elems)} {
+			if base := p.Base(); base != tc.base || Basename(p) != base {
+				t.Errorf("%#v: expected base %q, got %q", p, tc.base, base)
+			}
+			if ext := p.Ext(); ext != tc.ext {
+				t.Errorf("%#v: expected extension %q, got %q", p, tc.ext, ext)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// The index fields that -preserve stores local files' modes and
+// modification times in.
+const (
+	modeField  = "sb.mode"
+	mtimeField = "sb.mtime"
+)
+
+// preserveFileInfo stores file's mode and modification time in d's index
+// fields.  If ShareBase doesn't support index fields, it only warns.
+func (s *state) preserveFileInfo(c *web.Client, d *web.Document, file *os.File) error {
+	st, err := file.Stat()
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to stat %v: %v", file.Name(), err)
+	}
+	err = d.SetFields(c, map[string]string{
+		modeField:  strconv.FormatUint(uint64(st.Mode().Perm()), 8),
+		mtimeField: st.ModTime().UTC().Format(time.RFC3339Nano),
+	})
+	if err == web.ErrFieldsUnsupported {
+		s.warnFieldsUnsupported()
+		return nil
+	}
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to save the mode and modification time "+
+				"of %v: %v", file.Name(), err)
+	}
+	return nil
+}
+
+// preservedFileInfo gets the mode and modification time that
+// preserveFileInfo stored for d.  ok is false if there aren't any (or
+// ShareBase doesn't support index fields).
+func (s *state) preservedFileInfo(c *web.Client, d *Document) (mode os.FileMode, mtime time.Time, ok bool, err error) {
+	fields, err := d.Document.Fields(c)
+	if err == web.ErrFieldsUnsupported {
+		s.warnFieldsUnsupported()
+		return 0, time.Time{}, false, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, false, errors.ErrorfWithCause(
+			err, "failed to get fields of %v: %v", PathOf(d), err)
+	}
+	if fields[modeField] == "" {
+		logger.Debug1("%v has no preserved file mode", PathOf(d))
+		return 0, time.Time{}, false, nil
+	}
+	m, err := strconv.ParseUint(fields[modeField], 8, 32)
+	if err != nil {
+		logger.Warn(
+			"%v has an invalid %v field: %v", PathOf(d), modeField, err)
+		return 0, time.Time{}, false, nil
+	}
+	if mtime, err = time.Parse(time.RFC3339Nano, fields[mtimeField]); err != nil {
+		logger.Warn(
+			"%v has an invalid %v field: %v", PathOf(d), mtimeField, err)
+		mtime = time.Time{}
+	}
+	return os.FileMode(m).Perm(), mtime, true, nil
+}
+
+// warnFieldsUnsupported warns once that -preserve can't save or restore
+// anything.
+func (s *state) warnFieldsUnsupported() {
+	s.fieldsWarning.Do(func() {
+		logger.Warn(
+			"ShareBase doesn't support document index fields, so " +
+				"-preserve can't keep file modes and " +
+				"modification times")
+	})
+}
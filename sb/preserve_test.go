@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestPreserve(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		fields map[string]string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/documents/1/fields", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(fields)
+	})
+	mux.HandleFunc("/api/documents/2/fields", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.sh")
+	if err = ioutil.WriteFile(source, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err = os.Chtimes(source, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := &state{Preserve: true}
+	for _, id := range []string{"1", "2"} {
+		// document 2 doesn't support fields, which is only a warning.
+		wd := &web.Document{Links: web.DocumentLinks{Self: srv.URL + "/api/documents/" + id}}
+		if err = s.preserveFileInfo(c, wd, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fields[modeField] != "755" || fields[mtimeField] != "2020-01-02T03:04:05Z" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+
+	lib := newLibrary(NewRoot(), web.Library{LibraryName: "Lib"})
+	folder := newFolder(lib, web.Folder{FolderName: "Folder"})
+	for _, tc := range []struct {
+		id    string
+		mode  os.FileMode
+		mtime time.Time
+	}{
+		{"1", 0755, mtime},
+		// without fields, only the document's date is used.
+		{"2", 0600, mtime.Add(time.Hour)},
+	} {
+		d := &Document{
+			Folder: folder,
+			Document: web.Document{
+				DocumentName: "script.sh",
+				DateModified: mtime.Add(time.Hour),
+				Links:        web.DocumentLinks{Self: srv.URL + "/api/documents/" + tc.id},
+			},
+		}
+		target, err := os.OpenFile(filepath.Join(dir, "download"+tc.id), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.shareBaseFileToLocalFile(c, d, strings.NewReader("#!/bin/sh\n"), target)
+		if err2 := target.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := os.Stat(target.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != tc.mode || !st.ModTime().Equal(tc.mtime) {
+			t.Fatalf(
+				"%v: expected mode %v and time %v, got %v and %v",
+				tc.id, tc.mode, tc.mtime, st.Mode().Perm(), st.ModTime())
+		}
+	}
+}
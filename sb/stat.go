@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// objectStat is the detailed metadata of an object written by the stat
+// command.
+type objectStat struct {
+	Name         string            `json:"name"`
+	Path         string            `json:"path"`
+	ID           int               `json:"id"`
+	Kind         web.Kind          `json:"kind"`
+	Size         *int64            `json:"size,omitempty"`
+	ContentType  string            `json:"contentType,omitempty"`
+	DateModified *time.Time        `json:"dateModified,omitempty"`
+	Hash         string            `json:"hash,omitempty"`
+	Folders      *int              `json:"folders,omitempty"`
+	Documents    *int              `json:"documents,omitempty"`
+	Links        map[string]string `json:"links,omitempty"`
+}
+
+// statObject writes the metadata of a single document, folder, or library.
+// Documents' metadata is requested if their sizes and hashes weren't listed,
+// and folders and libraries are updated to count their children.
+func (s *state) statObject(c *web.Client, o Object) error {
+	st, err := s.makeObjectStat(c, o)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to stat %v: %v", PathOf(o), err)
+	}
+	if s.JSON {
+		return json.NewEncoder(os.Stdout).Encode(st)
+	}
+	return writeObjectStat(os.Stdout, st, s.Human)
+}
+
+func (s *state) makeObjectStat(c *web.Client, o Object) (objectStat, error) {
+	st := objectStat{
+		Name: o.Name(),
+		Path: PathOf(o).String(),
+		ID:   o.ID(),
+		Kind: kindOf(o),
+	}
+	switch o := o.(type) {
+	case *Document:
+		wd, err := o.Document.Metadata(c)
+		if err != nil {
+			return objectStat{}, err
+		}
+		o.Document = wd
+		st.Size = &wd.Size
+		st.ContentType = wd.ContentType
+		st.DateModified = &wd.DateModified
+		st.Hash = getHex(wd.Hash)
+		st.Links = map[string]string{
+			"self":    wd.Links.Self,
+			"content": wd.Links.Content,
+			"shares":  wd.Links.Shares,
+		}
+	case *Folder:
+		if err := o.update(s.Root, c); err != nil {
+			return objectStat{}, err
+		}
+		st.Folders, st.Documents = countChildren(o)
+		st.Links = map[string]string{
+			"self":      o.Folder.Links.Self,
+			"folders":   o.Folder.Links.Folders,
+			"documents": o.Folder.Links.Documents,
+			"shares":    o.Folder.Links.Shares,
+		}
+	case *Library:
+		if err := o.update(s.Root, c); err != nil {
+			return objectStat{}, err
+		}
+		st.Folders, _ = countChildren(o)
+		st.Links = map[string]string{
+			"self":    o.Library.Links.Self,
+			"folders": o.Library.Links.Folders,
+		}
+	default:
+		return objectStat{}, errors.Errorf("cannot stat %T", o)
+	}
+	for k, v := range st.Links {
+		if v == "" {
+			delete(st.Links, k)
+		}
+	}
+	return st, nil
+}
+
+// countChildren counts p's folders and documents.
+func countChildren(p Parent) (folders, documents *int) {
+	var nf, nd int
+	for _, ch := range p.Children() {
+		switch ch.(type) {
+		case *Folder:
+			nf++
+		case *Document:
+			nd++
+		}
+	}
+	return &nf, &nd
+}
+
+// writeObjectStat writes st as aligned lines of field names and values.
+// Fields without values are left out.
+func writeObjectStat(w io.Writer, st objectStat, human bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fields := [][2]string{
+		{"name", st.Name},
+		{"path", st.Path},
+		{"id", fmt.Sprint(st.ID)},
+		{"kind", string(st.Kind)},
+	}
+	if st.Size != nil {
+		fields = append(fields, [2]string{"size", formatSize(*st.Size, human)})
+	}
+	fields = append(fields, [2]string{"content type", st.ContentType})
+	if st.DateModified != nil && !st.DateModified.IsZero() {
+		fields = append(fields, [2]string{
+			"modified", st.DateModified.Format(time.RFC3339)})
+	}
+	fields = append(fields, [2]string{"hash", st.Hash})
+	if st.Folders != nil {
+		fields = append(fields, [2]string{"folders", fmt.Sprint(*st.Folders)})
+	}
+	if st.Documents != nil {
+		fields = append(fields, [2]string{"documents", fmt.Sprint(*st.Documents)})
+	}
+	names := make([]string, 0, len(st.Links))
+	for name := range st.Links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, [2]string{name + " link", st.Links[name]})
+	}
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(tw, "%s:\t%s\n", f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestStatObject(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := s.makeObjectStat(c, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Folders == nil || *st.Folders != 1 || st.Documents == nil || *st.Documents != 2 {
+		t.Fatalf("expected 1 folder and 2 documents, got %+v", st)
+	}
+	o, err = s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top", "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b.txt's size and hash weren't listed, so they come from its
+	// metadata.
+	if st, err = s.makeObjectStat(c, o); err != nil {
+		t.Fatal(err)
+	}
+	if st.Size == nil || *st.Size != 7 || st.ContentType != "text/plain" || st.Hash == "" {
+		t.Fatalf("expected b.txt's metadata, got %+v", st)
+	}
+	var buf bytes.Buffer
+	if err = writeObjectStat(&buf, st, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"name:         b.txt\n",
+		"size:         7\n",
+		"content type: text/plain\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in:\n%s", line, buf.String())
+		}
+	}
+}
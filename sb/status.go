@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// statusWriter writes what sb is doing, like which files it's copying, for
+// the person running it.  It's separate from the logger so that the status
+// messages are shown regardless of -LogLevel and can be silenced with -q
+// without hiding warnings or debug output.
+//
+// A nil *statusWriter is valid and doesn't write anything.
+type statusWriter struct {
+	// mutex keeps lines from different workers from interleaving.
+	mutex sync.Mutex
+
+	w io.Writer
+
+	// verbose enables the messages written with verbosef.
+	verbose bool
+}
+
+// newStatusWriter creates a statusWriter that writes to w.  If quiet is set,
+// it returns nil so that nothing is written.
+func newStatusWriter(w io.Writer, quiet, verbose bool) *statusWriter {
+	if quiet {
+		return nil
+	}
+	return &statusWriter{w: w, verbose: verbose}
+}
+
+// printf writes a status line.  The newline is added.
+func (sw *statusWriter) printf(format string, args ...interface{}) {
+	if sw == nil {
+		return
+	}
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	if _, err := fmt.Fprintf(sw.w, format+"\n", args...); err != nil {
+		logger.Debug1("failed to write status: %v", err)
+	}
+}
+
+// verbosef writes a status line only with -v.
+func (sw *statusWriter) verbosef(format string, args ...interface{}) {
+	if sw == nil || !sw.verbose {
+		return
+	}
+	sw.printf(format, args...)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		quiet, verbose bool
+		expected       string
+	}{
+		{"default", false, false, "copying a to b...\n"},
+		{"verbose", false, true, "copying a to b...\nexcluding c\n"},
+		{"quiet", true, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := &state{status: newStatusWriter(&buf, tc.quiet, tc.verbose)}
+			s.status.printf("copying %v to %v...", "a", "b")
+			s.status.verbosef("excluding %v", "c")
+			if buf.String() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, buf.String())
+			}
+		})
+	}
+}
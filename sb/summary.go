@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// transferSummary counts the files, folders, and bytes that a transfer
+// copied so that they can be summarized when it's done.  It's safe to use
+// from multiple goroutines.
+//
+// A nil *transferSummary is valid and doesn't count anything.
+type transferSummary struct {
+	// start is when the transfer started.
+	start time.Time
+
+	// requests is how many requests the client pool had made when the
+	// transfer started.
+	requests uint64
+
+	// mutex protects the counts.
+	mutex   sync.Mutex
+	files   int
+	folders int
+	bytes   int64
+}
+
+// newTransferSummary starts a summary of a transfer made with clients from
+// pool.
+func newTransferSummary(pool *web.ClientPool) *transferSummary {
+	return &transferSummary{start: time.Now(), requests: pool.NumRequests()}
+}
+
+// addFile counts a file of size bytes.
+func (t *transferSummary) addFile(size int64) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.files++
+	if size > 0 {
+		t.bytes += size
+	}
+}
+
+// addFolder counts a folder.
+func (t *transferSummary) addFolder() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.folders++
+}
+
+// describe summarizes the transfer up to now.  requests is the number of
+// requests the client pool has made so far.
+func (t *transferSummary) describe(now time.Time, requests uint64) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	elapsed := now.Sub(t.start)
+	var rate web.Size
+	if elapsed > 0 {
+		rate = web.Size(float64(t.bytes) / elapsed.Seconds())
+	}
+	return fmt.Sprintf(
+		"transferred %v and %v (%v) in %v (%v/s) with %v",
+		plural(t.files, "file"), plural(t.folders, "folder"),
+		web.Size(t.bytes).Human(), elapsed.Round(time.Millisecond),
+		rate.Human(), plural(int(requests-t.requests), "request"))
+}
+
+// plural formats n with noun, adding an "s" unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// reportSummary writes the summary of the transfer to stderr unless -q or -n
+// was given.
+func (s *state) reportSummary() {
+	if s.summary == nil || s.DryRun {
+		return
+	}
+	s.status.printf(
+		"%s", s.summary.describe(time.Now(), s.ClientPool.NumRequests()))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferSummary(t *testing.T) {
+	var none *transferSummary
+	// a nil summary doesn't count anything.
+	none.addFile(1)
+	none.addFolder()
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sum := &transferSummary{start: start, requests: 10}
+	sum.addFolder()
+	sum.addFile(1024)
+	sum.addFile(3072)
+	// an unknown size still counts the file.
+	sum.addFile(-1)
+	const expected = "transferred 3 files and 1 folder (4.0K) in 2s (2.0K/s) with 7 requests"
+	if actual := sum.describe(start.Add(2*time.Second), 17); actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// syncResults are the results of a sync.
+type syncResults struct {
+	uploaded int
+	skipped  int
+	deleted  int
+
+	// orphans are the documents and folders in ShareBase that have no
+	// local counterpart.  They're deleted by -mirror.
+	orphans []Object
+}
+
+// syncDirectory uploads the files from the local directory given as the
+// command's argument into the ShareBase folder at p, skipping the files that
+// haven't changed since they were last uploaded.  The folder (and any
+// subfolders) are created if they don't exist yet.
+//
+// A file is uploaded if its document doesn't exist, the sizes differ, or the
+// file was modified after the document was.  With -checksum, files that look
+// the same are also compared by hash.  The document of a changed file is
+// deleted after the file is uploaded again so that the folder doesn't fill up
+// with copies that have the same name.
+//
+// With -mirror, documents and folders under p that don't exist locally (and
+// aren't excluded) are deleted after the uploads finish.  Unless -y is
+// given, the deletions have to be confirmed on the terminal first.
+func (s *state) syncDirectory(c *web.Client, p ShareBasePath) error {
+	if len(s.Args) != 1 {
+		return errors.Errorf(
+			"sync requires exactly one local directory argument")
+	}
+	local := s.Args[0]
+	st, err := os.Stat(local)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to stat %v: %v", local, err)
+	}
+	if !st.IsDir() {
+		return errors.Errorf("%v is not a directory", local)
+	}
+	if err = s.checkFields(c, p); err != nil {
+		return err
+	}
+	f, err := s.Root.GetOrCreateFolder(c, s.Root, p)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to get sync target %v: %v", p, err)
+	}
+	e, err := s.newDirExcluder(local)
+	if err != nil {
+		return err
+	}
+	var results syncResults
+	u := s.newUploader(context.Background(), s.Jobs)
+	err = s.syncLocalDir(c, u, e, local, f, "", &results)
+	if err2 := u.wait(); err2 != nil {
+		return err2
+	}
+	if err != nil {
+		return err
+	}
+	if s.Mirror {
+		if err = s.deleteOrphans(c, &results); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(
+		os.Stdout, "uploaded: %d, skipped: %d, deleted: %d\n",
+		results.uploaded, results.skipped, results.deleted)
+	return err
+}
+
+// syncLocalDir syncs the local directory dir into the folder f.  rel is dir's
+// path relative to the root of the sync.
+func (s *state) syncLocalDir(c *web.Client, u *uploader, e *excluder, dir string, f *Folder, rel string, results *syncResults) error {
+	if err := f.update(s.Root, c); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to update %v", PathOf(f))
+	}
+	u.dedupe.addFolder(f)
+	source, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := source.Readdir(-1)
+	source.Close()
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to read local directory: %v: %v", dir, err)
+	}
+	if s.Mirror {
+		locals := make(map[string]bool, len(infos))
+		for _, fi := range infos {
+			locals[fi.Name()] = true
+		}
+		for _, ch := range f.Children() {
+			_, isDir := ch.(*Folder)
+			if locals[ch.Name()] || e.excluded(path.Join(rel, ch.Name()), isDir) {
+				continue
+			}
+			results.orphans = append(results.orphans, ch)
+		}
+	}
+	for _, fi := range infos {
+		name := fi.Name()
+		sourcePath := path.Join(dir, name)
+		relPath := path.Join(rel, name)
+		if e.excluded(relPath, fi.IsDir()) {
+			s.status.verbosef("excluding %v", sourcePath)
+			continue
+		}
+		if fi.IsDir() {
+			sub, err := s.Root.GetOrCreateFolder(
+				c, f, ShareBasePath{name})
+			if err != nil {
+				return errors.ErrorfWithCause(
+					err, "failed to create ShareBase folder")
+			}
+			if err = s.syncLocalDir(
+				c, u, e, sourcePath, sub, relPath, results); err != nil {
+				return err
+			}
+			continue
+		}
+		j := uploadJob{
+			source: sourcePath,
+			size:   fi.Size(),
+			parent: f,
+			folder: f.Folder,
+			target: PathOf(f).Join(name),
+		}
+		if o, ok := f.ChildByName(name); ok {
+			d, ok := o.(*Document)
+			if !ok {
+				return errors.Errorf(
+					"cannot sync file %v over %v which is a %v",
+					sourcePath, PathOf(o), kindOf(o))
+			}
+			changed, err := s.fileChanged(sourcePath, fi, d)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				logger.Debug1("skipping unchanged %v", sourcePath)
+				results.skipped++
+				continue
+			}
+			wd := d.Document
+			j.replaces, j.replaced = &wd, d
+		}
+		if err = u.schedule(j); err != nil {
+			return err
+		}
+		results.uploaded++
+	}
+	return nil
+}
+
+// deleteOrphans deletes the orphaned documents and folders found by a sync
+// after getting confirmation.  In a dry run, the deletions are only logged.
+func (s *state) deleteOrphans(c *web.Client, results *syncResults) error {
+	if len(results.orphans) == 0 {
+		return nil
+	}
+	if s.DryRun {
+		for _, o := range results.orphans {
+			s.status.printf("dry run: would delete %v %v", kindOf(o), PathOf(o))
+		}
+		return nil
+	}
+	if err := s.confirmDeletes(results.orphans, true); err != nil {
+		return err
+	}
+	for _, o := range results.orphans {
+		s.status.printf("deleting %v %v...", kindOf(o), PathOf(o))
+		var err error
+		switch o := o.(type) {
+		case *Document:
+			err = o.Document.Delete(c)
+		case *Folder:
+			err = o.Folder.Delete(c)
+		default:
+			err = errors.Errorf("cannot delete %T", o)
+		}
+		if err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to delete %v: %v", PathOf(o), err)
+		}
+		s.Root.remove(o)
+		results.deleted++
+	}
+	return nil
+}
+
+// fileChanged checks if the local file at filename with info fi is different
+// from the document d.
+func (s *state) fileChanged(filename string, fi os.FileInfo, d *Document) (bool, error) {
+	if fi.Size() != d.Size || fi.ModTime().After(d.DateModified) {
+		return true, nil
+	}
+	if !s.Checksum || len(d.Hash) == 0 {
+		return false, nil
+	}
+	sum, err := sha1File(filename)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(sum, d.Hash), nil
+}
+
+// sha1File computes the SHA-1 hash of a local file's content, which is the
+// hash ShareBase reports for documents.
+func sha1File(filename string) (sum []byte, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer errors.WrapDeferred(&err, f.Close)
+	h := sha1.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, errors.ErrorfWithCause(
+			err, "failed to hash %v: %v", filename, err)
+	}
+	return h.Sum(nil), nil
+}
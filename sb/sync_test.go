@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// writeSyncDir writes files into a new temporary directory and sets their
+// modification times to modified.
+func writeSyncDir(t *testing.T, files map[string]string, modified time.Time) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// syncToFake syncs the local directory dir into "Lib/Top" of fs with a new
+// Root, like a separate run of sb would.  configure sets the state's flags.
+func syncToFake(t *testing.T, fs *fakeShareBase, dir string, configure func(s *state)) error {
+	s := &state{
+		Root:       NewRoot(),
+		ClientPool: web.NewClientPool(),
+		Config:     Config{DataCenter: fs.srv.URL, Token: "token"},
+		Args:       []string{dir},
+		Jobs:       1,
+	}
+	defer s.ClientPool.Close()
+	if configure != nil {
+		configure(s)
+	}
+	c, err := web.NewClient(fs.srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.syncDirectory(c, ShareBasePath{"Lib", "Top"})
+}
+
+func TestSyncDirectory(t *testing.T) {
+	local := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]string{
+		"new.txt":       "new",
+		"same.txt":      "same",
+		"size.txt":      "longer",
+		"mtime.txt":     "abc",
+		"hash.txt":      "xyz",
+		"Sub/inner.txt": "inner",
+	}
+	for _, tc := range []struct {
+		name     string
+		checksum bool
+		expected map[string]string
+	}{
+		{"metadata", false, map[string]string{
+			"new.txt":       "new",
+			"same.txt":      "same",
+			"size.txt":      "longer",
+			"mtime.txt":     "abc",
+			"hash.txt":      "abc",
+			"Sub/":          "",
+			"Sub/inner.txt": "inner",
+		}},
+		{"checksum", true, map[string]string{
+			"new.txt":       "new",
+			"same.txt":      "same",
+			"size.txt":      "longer",
+			"mtime.txt":     "abc",
+			"hash.txt":      "xyz",
+			"Sub/":          "",
+			"Sub/inner.txt": "inner",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newFakeShareBase(t)
+			defer fs.srv.Close()
+			top := fs.addFolder(0, "Top")
+			newer, older := local.Add(time.Hour), local.Add(-time.Hour)
+			sameID := fs.addDocument(top, "same.txt", "same", newer)
+			fs.addDocument(top, "size.txt", "old", newer)
+			fs.addDocument(top, "mtime.txt", "abc", older)
+			// the same size and an older local file only differ by
+			// hash.
+			fs.addDocument(top, "hash.txt", "abc", newer)
+			dir := writeSyncDir(t, files, local)
+			configure := func(s *state) { s.Checksum = tc.checksum }
+
+			if err := syncToFake(t, fs, dir, configure); err != nil {
+				t.Fatal(err)
+			}
+			if tree := fs.tree(top); !reflect.DeepEqual(tree, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, tree)
+			}
+			// changed documents are replaced, not added next to
+			// the old ones.
+			if n := fs.numDocuments(top); n != 5 {
+				t.Fatalf("expected 5 documents in Top, got %d", n)
+			}
+			fs.mutex.Lock()
+			_, kept := fs.documents[sameID]
+			fs.mutex.Unlock()
+			if !kept {
+				t.Fatal("expected the unchanged document to be kept")
+			}
+
+			// nothing has changed the next time.
+			fs.mutex.Lock()
+			nextID := fs.nextID
+			fs.mutex.Unlock()
+			if err := syncToFake(t, fs, dir, configure); err != nil {
+				t.Fatal(err)
+			}
+			fs.mutex.Lock()
+			uploaded := fs.nextID - nextID
+			fs.mutex.Unlock()
+			if uploaded != 0 {
+				t.Fatalf("expected nothing to be uploaded again, got %d documents", uploaded)
+			}
+			if n := fs.numDocuments(top); n != 5 {
+				t.Fatalf("expected 5 documents in Top, got %d", n)
+			}
+		})
+	}
+}
+
+func TestSyncMirror(t *testing.T) {
+	local := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]string{
+		"keep.txt":     "keep",
+		"Sub/kept.txt": "kept",
+	}
+	before := map[string]string{
+		"keep.txt":           "keep",
+		"orphan.txt":         "orphan",
+		"debug.log":          "excluded",
+		"Old/":               "",
+		"Old/old.txt":        "old",
+		"Sub/":               "",
+		"Sub/kept.txt":       "kept",
+		"Sub/sub orphan.txt": "orphan",
+	}
+	for _, tc := range []struct {
+		name     string
+		dryRun   bool
+		yes      bool
+		err      string
+		expected map[string]string
+		status   []string
+	}{
+		{"dryRun", true, false, "", before, []string{
+			"dry run: would delete Document sb:Lib/Top/orphan.txt",
+			"dry run: would delete Folder sb:Lib/Top/Old",
+			"dry run: would delete Document sb:Lib/Top/Sub/sub orphan.txt",
+		}},
+		{"notTerminal", false, false, "refusing to delete without " +
+			"confirmation because stdin is not a terminal (use -y)", before, nil},
+		{"yes", false, true, "", map[string]string{
+			"keep.txt":     "keep",
+			"debug.log":    "excluded",
+			"Sub/":         "",
+			"Sub/kept.txt": "kept",
+		}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newFakeShareBase(t)
+			defer fs.srv.Close()
+			top := fs.addFolder(0, "Top")
+			newer := local.Add(time.Hour)
+			fs.addDocument(top, "keep.txt", "keep", newer)
+			fs.addDocument(top, "orphan.txt", "orphan", newer)
+			fs.addDocument(top, "debug.log", "excluded", newer)
+			old := fs.addFolder(top, "Old")
+			fs.addDocument(old, "old.txt", "old", newer)
+			sub := fs.addFolder(top, "Sub")
+			fs.addDocument(sub, "kept.txt", "kept", newer)
+			fs.addDocument(sub, "sub orphan.txt", "orphan", newer)
+			dir := writeSyncDir(t, files, local)
+
+			// stdin is a file so that it's never a terminal to
+			// confirm the deletions on.
+			stdin, err := ioutil.TempFile(t.TempDir(), "stdin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stdin.Close()
+			defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+			os.Stdin = stdin
+
+			var status bytes.Buffer
+			err = syncToFake(t, fs, dir, func(s *state) {
+				s.Mirror = true
+				s.DryRun = tc.dryRun
+				s.Yes = tc.yes
+				s.Exclude = stringsFlag{"*.log"}
+				s.status = newStatusWriter(&status, false, false)
+			})
+			if tc.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.err != "" && (err == nil || err.Error() != tc.err) {
+				t.Fatalf("expected %q, got %v", tc.err, err)
+			}
+			if tree := fs.tree(top); !reflect.DeepEqual(tree, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, tree)
+			}
+			lines := strings.Split(strings.TrimSpace(status.String()), "\n")
+			for _, line := range tc.status {
+				if !containsString(lines, line) {
+					t.Errorf("expected status %q in %q", line, lines)
+				}
+			}
+			if strings.Contains(status.String(), "debug.log") {
+				t.Errorf("expected the excluded document to be left alone: %q", lines)
+			}
+		})
+	}
+}
+
+func containsString(vs []string, v string) bool {
+	for _, x := range vs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
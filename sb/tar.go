@@ -0,0 +1,14 @@
+package main
+
+// tarEntryPath gets the path of a tar entry relative to the folder that the
+// tar is extracted into.  Names that are absolute, start with a drive letter,
+// or still start with ".." after being cleaned would put the entry somewhere
+// else, so they're rejected with UnsafeTarEntry instead.
+func tarEntryPath(name string) (LocalPath, error) {
+	p := LocalPathFromString(name)
+	if first := p[0]; first == "" || first == ".." ||
+		(len(first) >= 2 && first[1] == ':') {
+		return nil, UnsafeTarEntry{Name: name}
+	}
+	return p, nil
+}
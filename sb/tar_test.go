@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestTarEntryPath(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		expected string
+		unsafe   bool
+	}{
+		{name: "a/b.txt", expected: "a/b.txt"},
+		{name: "./a/b.txt", expected: "a/b.txt"},
+		{name: "a/../b.txt", expected: "b.txt"},
+		{name: "a\\b.txt", expected: "a/b.txt"},
+		{name: "../b.txt", unsafe: true},
+		{name: "a/../../b.txt", unsafe: true},
+		{name: "..", unsafe: true},
+		{name: "/etc/passwd", unsafe: true},
+		{name: "\\Windows\\b.txt", unsafe: true},
+		{name: "C:/b.txt", unsafe: true},
+	} {
+		p, err := tarEntryPath(tc.name)
+		if tc.unsafe {
+			if _, ok := err.(UnsafeTarEntry); !ok {
+				t.Fatalf("expected UnsafeTarEntry for %q, got %v, %v", tc.name, p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.name, err)
+		}
+		if actual := strings.Join(p, "/"); actual != tc.expected {
+			t.Fatalf("expected %q to be %q, got %q", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestLocalTarTraversal(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	const content = "escaped"
+	if err = w.WriteHeader(&tar.Header{
+		Name:     "Sub/../../../evil.txt",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	lib, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.localTarToShareBaseDir(c, &buf, lib.(*Library), "Top")
+	if _, ok := err.(UnsafeTarEntry); !ok {
+		t.Fatalf("expected UnsafeTarEntry, got %v", err)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	fs := newFakeShareBase(t)
+	defer fs.srv.Close()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fs.addFolder(0, "Src")
+	fs.addDocument(src, "a.txt", "alpha", modified)
+	sub := fs.addFolder(src, "Sub")
+	fs.addDocument(sub, "b.txt", "bravo", modified)
+	fs.addFolder(sub, "Empty")
+	fs.addFolder(src, "Also Empty")
+
+	s := &state{
+		Root:       NewRoot(),
+		ClientPool: web.NewClientPool(),
+		Config:     Config{DataCenter: fs.srv.URL, Token: "token"},
+		Jobs:       1,
+	}
+	defer s.ClientPool.Close()
+	c, err := web.NewClient(fs.srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Src"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = s.shareBaseDirToLocalTar(c, o.(*Folder), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path.IsAbs(h.Name) || strings.HasPrefix(h.Name, shareBaseURIScheme) {
+			t.Fatalf("expected a relative name, got %q", h.Name)
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if h.Mode != 0755 {
+				t.Fatalf("expected %q to have mode 0755, got %o", h.Name, h.Mode)
+			}
+		case tar.TypeReg:
+			if h.Mode != 0644 || !h.ModTime.Equal(modified) {
+				t.Fatalf(
+					"expected %q to have mode 0644 and time %v, got %o and %v",
+					h.Name, modified, h.Mode, h.ModTime)
+			}
+		default:
+			t.Fatalf("unexpected type %q for %q", h.Typeflag, h.Name)
+		}
+	}
+
+	lib, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.localTarToShareBaseDir(c, &buf, lib.(*Library), "Dst"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"a.txt":       "alpha",
+		"Sub/":        "",
+		"Sub/b.txt":   "bravo",
+		"Sub/Empty/":  "",
+		"Also Empty/": "",
+	}
+	if actual := fs.tree(src); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected source tree %v, got %v", expected, actual)
+	}
+	fs.mutex.Lock()
+	dst := fs.childFolder(0, "Dst")
+	fs.mutex.Unlock()
+	if actual := fs.tree(dst); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected extracted tree %v, got %v", expected, actual)
+	}
+}
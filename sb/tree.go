@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// treeWriter renders a ShareBase subtree like the Unix tree command.  Lines
+// are flushed before every request to ShareBase, so they show up as the tree
+// is walked and only the children of the folders along the current path are
+// held in memory.
+type treeWriter struct {
+	s *state
+	c *web.Client
+	w *bufio.Writer
+
+	// folders and documents count what's been written.
+	folders   int
+	documents int
+}
+
+// showTree writes the folders and documents under o as an indented tree,
+// labeling each one with its kind.  -L limits how many levels deep the tree
+// goes (0 means there's no limit) and -d only writes folders.
+//
+// Traverse isn't used because it's breadth-first, and a tree has to be
+// written depth-first to be streamed.
+func (s *state) showTree(c *web.Client, o Object) error {
+	return s.writeTree(c, os.Stdout, o)
+}
+
+func (s *state) writeTree(c *web.Client, w io.Writer, o Object) (err error) {
+	tw := &treeWriter{s: s, c: c, w: bufio.NewWriter(w)}
+	defer errors.WrapDeferred(&err, tw.w.Flush)
+	if _, err = fmt.Fprintf(tw.w, "%v [%v]\n", PathOf(o), kindOf(o)); err != nil {
+		return err
+	}
+	if p, ok := asParent(o); ok {
+		if err = tw.writeChildren(p, "", 1); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(
+		tw.w, "\n%d folders, %d documents\n", tw.folders, tw.documents)
+	return err
+}
+
+// writeChildren updates p and writes its children sorted by name.  prefix
+// is written before each child's connector to line it up under its parent.
+func (tw *treeWriter) writeChildren(p Parent, prefix string, depth int) error {
+	if tw.s.TreeDepth > 0 && depth > tw.s.TreeDepth {
+		return nil
+	}
+	if err := tw.w.Flush(); err != nil {
+		return err
+	}
+	if err := p.update(tw.s.Root, tw.c); err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to update %v", PathOf(p))
+	}
+	children := make([]Object, 0, len(p.Children()))
+	for _, ch := range p.Children() {
+		if _, ok := ch.(*Document); ok && tw.s.DirsOnly {
+			continue
+		}
+		children = append(children, ch)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	for i, ch := range children {
+		connector, indent := "├── ", "│   "
+		if i == len(children)-1 {
+			connector, indent = "└── ", "    "
+		}
+		switch ch.(type) {
+		case *Folder:
+			tw.folders++
+		case *Document:
+			tw.documents++
+		}
+		if _, err := fmt.Fprintf(
+			tw.w, "%s%s%s [%v]\n",
+			prefix, connector, ch.Name(), kindOf(ch)); err != nil {
+			return err
+		}
+		if sub, ok := asParent(ch); ok {
+			if err := tw.writeChildren(sub, prefix+indent, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestWriteTree(t *testing.T) {
+	srv := serveTree(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		depth    int
+		dirsOnly bool
+		expected string
+	}{
+		{"all", 0, false, `sb:Lib [Library]
+└── Top [Folder]
+    ├── Sub [Folder]
+    │   └── c.txt [Document]
+    ├── a.txt [Document]
+    └── b.txt [Document]
+
+2 folders, 3 documents
+`},
+		{"depth", 2, false, `sb:Lib [Library]
+└── Top [Folder]
+    ├── Sub [Folder]
+    ├── a.txt [Document]
+    └── b.txt [Document]
+
+2 folders, 2 documents
+`},
+		{"dirsOnly", 0, true, `sb:Lib [Library]
+└── Top [Folder]
+    └── Sub [Folder]
+
+2 folders, 0 documents
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &state{Root: NewRoot(), TreeDepth: tc.depth, DirsOnly: tc.dirsOnly}
+			o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err = s.writeTree(c, &buf, o); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, buf.String())
+			}
+		})
+	}
+}
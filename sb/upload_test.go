@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestLocalFileToShareBaseDir(t *testing.T) {
+	fs := newFakeShareBase(t)
+	defer fs.srv.Close()
+	fs.addFolder(0, "Top")
+	c, err := web.NewClient(fs.srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &state{Root: NewRoot()}
+	o, err := s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := o.(*Folder)
+	d, err := s.localFileToShareBaseDir(c, strings.NewReader("new"), 3, f, "new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := PathOf(d).String(); got != "sb:Lib/Top/new.txt" || d.ID() == 0 {
+		t.Fatalf("expected sb:Lib/Top/new.txt with an ID, got %v with %d", got, d.ID())
+	}
+	o, err = s.Root.ObjectByPath(c, nil, ShareBasePath{"Lib", "Top", "new.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != Object(d) {
+		t.Fatalf("expected the new document to be in the tree, got %v", PathOf(o))
+	}
+
+	s.DryRun = true
+	if d, err = s.localFileToShareBaseDir(c, strings.NewReader("dry"), 3, f, "dry.txt"); err != nil || d != nil {
+		t.Fatalf("expected no document for a dry run, got %v, %v", d, err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/skillian/errors"
+	"github.com/skillian/sharebase/web"
+)
+
+// documentHash gets the SHA-1 hash that ShareBase reports for d, requesting
+// it if it wasn't listed with d.  Some documents don't have hashes, so a nil
+// hash without an error is possible.
+func documentHash(wc *web.Client, d *Document) ([]byte, error) {
+	if len(d.Document.Hash) > 0 {
+		return d.Document.Hash, nil
+	}
+	md, err := d.Document.Metadata(wc)
+	if err != nil {
+		return nil, errors.ErrorfWithCause(
+			err, "failed to get the hash of %v: %v", PathOf(d), err)
+	}
+	if len(md.Hash) == 0 {
+		logger.Warn("ShareBase has no hash to verify %v with", PathOf(d))
+	}
+	return md.Hash, nil
+}
+
+// downloadHash starts hashing a download of d into target for -verify.  If
+// target already holds the beginning of d's content from an interrupted
+// download, that part is hashed first so that the rest can be hashed as it's
+// written.  If s.Verify isn't set or d doesn't have a hash, the hash is nil.
+func (s *state) downloadHash(wc *web.Client, d *Document, target *os.File) (expected []byte, h hash.Hash, err error) {
+	if !s.Verify {
+		return nil, nil, nil
+	}
+	if expected, err = documentHash(wc, d); err != nil || expected == nil {
+		return nil, nil, err
+	}
+	h = sha1.New()
+	if target == os.Stdout {
+		return expected, h, nil
+	}
+	offset, err := target.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset == 0 {
+		return expected, h, nil
+	}
+	// target might only be open for writing.
+	f, err := os.Open(target.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if _, err = io.CopyN(h, f, offset); err != nil {
+		return nil, nil, errors.ErrorfWithCause(
+			err, "failed to hash the beginning of %v: %v",
+			target.Name(), err)
+	}
+	return expected, h, nil
+}
+
+// verifyLocalFile checks the hash of target, which already holds all of d's
+// content, if s.Verify is set.
+func (s *state) verifyLocalFile(wc *web.Client, d *Document, target *os.File) error {
+	if !s.Verify || target == os.Stdout {
+		return nil
+	}
+	expected, err := documentHash(wc, d)
+	if err != nil || expected == nil {
+		return err
+	}
+	actual, err := sha1File(target.Name())
+	if err != nil {
+		return err
+	}
+	return checkDownloadHash(d, target, expected, actual)
+}
+
+// checkDownloadHash returns a HashMismatch if actual isn't expected.
+func checkDownloadHash(d *Document, target *os.File, expected, actual []byte) error {
+	if bytes.Equal(expected, actual) {
+		return nil
+	}
+	return HashMismatch{
+		Document: PathOf(d).String(),
+		File:     target.Name(),
+		Expected: expected,
+		Actual:   actual,
+	}
+}
@@ -2,12 +2,14 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/skillian/logging"
@@ -31,6 +33,16 @@ var (
 // Client defines the client struct used to communicate with the ShareBase
 // web API.
 type Client struct {
+	// numRequests keeps track of the total number of HTTP requests issued
+	// to the ShareBase API.  It's accessible through the NumRequests
+	// function.  It's the first field so that it's 64-bit aligned for
+	// atomic access on 32-bit platforms.
+	numRequests uint64
+
+	// loggedOut is set to 1 after Logout so that later requests fail
+	// without being sent.
+	loggedOut uint32
+
 	// httpClient is the http.Client used to actually make the REST
 	// requests to the ShareBase API.
 	httpClient http.Client
@@ -39,31 +51,47 @@ type Client struct {
 	// requests
 	DataCenter url.URL
 
+	// Metrics, if set, observes every request the client sends.  It's
+	// in addition to the count that NumRequests returns, which is always
+	// kept.  It should be set before the client is used.
+	Metrics Metrics
+
 	// phoenixToken is a PHOENIX-TOKEN authorization header included in
 	// all requests to the ShareBase API.
 	phoenixToken string
 
-	// numRequests keeps track of the total number of HTTP requests issued
-	// to the ShareBase API.  It's accessible through the NumRequests
-	// function.
-	numRequests uint64
+	// poolToken is the token that a ClientPool created the client with.
+	// It stays the same after the pool refreshes phoenixToken so that
+	// the client is cached under the token it was requested with.
+	poolToken string
+
+	// expires is when phoenixToken expires, if a ClientPool validating
+	// its tokens has checked.
+	expires time.Time
 }
 
-// NewClient creates a new client from the given dataCenter URL string and
-// API token.
+// NewClient creates a new client from the given dataCenter and API token.
+// dataCenter is resolved with ResolveDataCenter, so it can be one of the
+// DataCenters' short names or a URL.
 func NewClient(dataCenter, token string) (*Client, error) {
+	return newClientWithTransport(dataCenter, token, nil)
+}
+
+// newClientWithTransport creates a new client that sends its requests through
+// the given transport.  If transport is nil, http.DefaultTransport is used.
+func newClientWithTransport(dataCenter, token string, transport http.RoundTripper) (*Client, error) {
 	if _, err := stringNotEmpty(dataCenter, "dataCenter"); err != nil {
 		return nil, err
 	}
 	if _, err := stringNotEmpty(token, "token"); err != nil {
 		return nil, err
 	}
-	dataCenterURL, err := parseURL(dataCenter)
+	dataCenterURL, err := ResolveDataCenter(dataCenter)
 	if err != nil {
 		return nil, err
 	}
 	c := &Client{
-		httpClient:   http.Client{},
+		httpClient:   http.Client{Transport: transport},
 		DataCenter:   *dataCenterURL,
 		phoenixToken: PhoenixTokenPrefix + token,
 	}
@@ -118,7 +146,8 @@ func AuthTokenForUsernameAndPassword(dataCenter, username, password string) (aut
 	}
 
 	// unmarshaling:
-	buffer := new(bytes.Buffer)
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 	defer errors.WrapDeferred(&err, res.Body.Close)
 	if _, err = io.Copy(buffer, res.Body); err != nil {
 		return AuthToken{}, errors.ErrorfWithCause(err, "failed to copy response data")
@@ -143,7 +172,35 @@ func validateParamsForUserAndPass(dataCenter, username, password string) (*url.U
 	if err != nil {
 		return nil, err
 	}
-	return parseURL(dataCenter)
+	return ResolveDataCenter(dataCenter)
+}
+
+// Whoami gets the AuthToken of the user that the Client is authenticated as,
+// including when the token expires.
+func (c *Client) Whoami() (authToken AuthToken, err error) {
+	authURL := c.DataCenter
+	authURL.Path = path.Join(authURL.Path, authenticateURL.Path)
+	err = c.requestJSONURL(http.MethodGet, &authURL, nil, &authToken)
+	return
+}
+
+// Logout invalidates the Client's token by deleting it from ShareBase so that
+// it can't be used again, even by other clients.  Afterwards, the Client's
+// requests fail with ErrUnauthorized without being sent.  A token that was
+// already invalid isn't an error.
+func (c *Client) Logout() error {
+	authURL := c.DataCenter
+	authURL.Path = path.Join(authURL.Path, authenticateURL.Path)
+	err := c.requestURL(http.MethodDelete, &authURL, nil, nil)
+	if _, ok := err.(Unauthorized); ok {
+		err = nil
+	}
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to invalidate token: %v", err)
+	}
+	atomic.StoreUint32(&c.loggedOut, 1)
+	return nil
 }
 
 // Libraries gets all of the libraries accessible from the current Client.
@@ -154,19 +211,108 @@ func (c *Client) Libraries() (libraries []Library, err error) {
 	return
 }
 
+// LibrariesWhere gets the libraries accessible from the current Client that
+// keep returns true for.
+func (c *Client) LibrariesWhere(keep func(Library) bool) ([]Library, error) {
+	libraries, err := c.Libraries()
+	if err != nil {
+		return nil, err
+	}
+	kept := libraries[:0]
+	for _, lib := range libraries {
+		if keep(lib) {
+			kept = append(kept, lib)
+		}
+	}
+	return kept, nil
+}
+
+// PersonalLibraries gets the private libraries accessible from the current
+// Client.  Usually there's only one, the user's "My Library."
+func (c *Client) PersonalLibraries() ([]Library, error) {
+	return c.LibrariesWhere(func(lib Library) bool { return lib.IsPrivate })
+}
+
+// SharedLibraries gets the libraries accessible from the current Client that
+// aren't private.
+func (c *Client) SharedLibraries() ([]Library, error) {
+	return c.LibrariesWhere(func(lib Library) bool { return !lib.IsPrivate })
+}
+
 // Library gets a library with the given integer ID.
 func (c *Client) Library(id int) (library Library, err error) {
 	libURL := c.DataCenter
 	libURL.Path = path.Join(libURL.Path, librariesURL.Path, strconv.Itoa(id))
 	err = c.requestJSONURL(http.MethodGet, &libURL, nil, &library)
 	if err != nil {
-		if _, ok := err.(NotFound); ok {
-			return Library{}, NotFound{Kind: LibraryKind, ID: id, Name: ""}
+		if nf, ok := err.(NotFound); ok {
+			return Library{}, nf.describe(LibraryKind, id, "")
 		}
 	}
 	return
 }
 
+// DeleteLibrary deletes the library with the given ID.  If the library still
+// has folders, NotEmpty is returned unless recursive is set, in which case
+// its folders, and everything in them, are deleted first.
+func (c *Client) DeleteLibrary(id int, recursive bool) error {
+	lib, err := c.Library(id)
+	if err != nil {
+		return err
+	}
+	folders, err := lib.Folders(c)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to list folders of library %q: %v",
+			lib.LibraryName, err)
+	}
+	if len(folders) > 0 && !recursive {
+		return NotEmpty{Kind: LibraryKind, Name: lib.LibraryName}
+	}
+	for i := range folders {
+		if err = folders[i].Delete(c); err != nil {
+			return errors.ErrorfWithCause(
+				err, "failed to delete folder %q of library %q: %v",
+				folders[i].FolderName, lib.LibraryName, err)
+		}
+	}
+	libURL := c.DataCenter
+	libURL.Path = path.Join(libURL.Path, librariesURL.Path, strconv.Itoa(id))
+	err = c.requestJSONURL(http.MethodDelete, &libURL, nil, nil)
+	if nf, ok := err.(NotFound); ok {
+		return nf.describe(LibraryKind, id, lib.LibraryName)
+	}
+	return err
+}
+
+// NewLibraryRequest is used by the NewLibrary function to create a new
+// library.
+type NewLibraryRequest struct {
+	// LibraryName is the name of the new library.
+	LibraryName string
+
+	// IsPrivate creates a personal library instead of a shared one.
+	IsPrivate bool
+}
+
+// NewLibrary creates a new library with the given name.  If a library with
+// the name already exists, an AlreadyExists error is returned.
+func (c *Client) NewLibrary(name string, isPrivate bool) (library Library, err error) {
+	if _, err = stringNotEmpty(name, "name"); err != nil {
+		return Library{}, err
+	}
+	libURL := c.DataCenter
+	libURL.Path = path.Join(libURL.Path, librariesURL.Path)
+	err = c.requestJSONURL(http.MethodPost, &libURL, NewLibraryRequest{
+		LibraryName: name,
+		IsPrivate:   isPrivate,
+	}, &library)
+	if _, ok := err.(Conflict); ok {
+		return Library{}, AlreadyExists{Kind: LibraryKind, Name: name}
+	}
+	return
+}
+
 // LibraryByName attepts to retrieve a library by its name.
 func (c *Client) LibraryByName(name string) (library Library, err error) {
 	libs, err := c.Libraries()
@@ -186,7 +332,7 @@ func (c *Client) LibraryByName(name string) (library Library, err error) {
 // queries are consumed in case ShareBase ever switches to a per-request
 // payment model.  This total includes both successful and failed requests.
 func (c *Client) NumRequests() uint64 {
-	return c.numRequests
+	return atomic.LoadUint64(&c.numRequests)
 }
 
 // requestURL uses a URL when making a request.  Relative URLs are supported.
@@ -211,34 +357,87 @@ func setContentType(v string) requestOption {
 	}
 }
 
+// setContentLength is a request option that sets an HTTP request's content
+// length for bodies that http.NewRequest can't get the length of.
+func setContentLength(n int64) requestOption {
+	return func(req *http.Request) error {
+		req.ContentLength = n
+		return nil
+	}
+}
+
+// withContext is a request option that cancels the request when ctx is done.
+func withContext(ctx context.Context) requestOption {
+	return func(req *http.Request) error {
+		*req = *req.WithContext(ctx)
+		return nil
+	}
+}
+
+// setHeader is a request option that sets an arbitrary HTTP request header.
+func setHeader(key, value string) requestOption {
+	return func(req *http.Request) error {
+		req.Header.Set(key, value)
+		return nil
+	}
+}
+
 // requestBody creates a web-request and returns the response's body
 // directly so it can be read from and closed without any copies
 // in the middle.
 func (c *Client) requestBody(method string, uri string, source io.Reader, options ...requestOption) (http.Header, io.ReadCloser, error) {
-	if _, err := stringNotEmpty(method, "method"); err != nil {
+	res, err := c.requestResponse(method, uri, source, options...)
+	if err != nil {
 		return nil, nil, err
 	}
-	req, err := http.NewRequest(method, uri, source)
-	if err != nil {
-		return nil, nil, errors.ErrorfWithCause(
-			err,
-			"failed to create request for %v: %v",
-			uri, err)
+	return res.Header, res.Body, nil
+}
+
+// Do sends req to ShareBase with the client's authentication headers and
+// returns ShareBase's response as-is so that endpoints the client doesn't
+// have methods for can still be used.  If req's URL is relative, it's
+// resolved against the client's DataCenter, so a URL of "api/libraries"
+// works like it does for the client's own requests.  req itself isn't
+// modified.
+//
+// The request is counted by NumRequests and observed by the client's Metrics
+// like any other, but unlike the client's other methods, responses that
+// aren't successful aren't turned into errors: the caller has to check the
+// status code.  The caller owns the response and must close its body.
+//
+// Do doesn't retry anything.  A 429 Too Many Requests response is returned
+// like any other instead of becoming a RateLimited error, so the caller has
+// to read its Retry-After header and send the request again itself.  The
+// request's body isn't limited by a RateLimiter either; wrap it with one's
+// Reader before calling Do to limit it.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if atomic.LoadUint32(&c.loggedOut) != 0 {
+		return nil, ErrUnauthorized
+	}
+	req = req.Clone(req.Context())
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if !req.URL.IsAbs() {
+		u := c.DataCenter
+		u.Path = path.Join(u.Path, req.URL.Path)
+		u.RawQuery = req.URL.RawQuery
+		req.URL = &u
+		req.Host = u.Host
 	}
+	return c.send(req)
+}
+
+// send adds the client's authentication headers to req and sends it.  It's
+// where every request is logged, counted, and observed by the client's
+// Metrics.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Authorization", c.phoenixToken)
 	req.Header["x-phoenix-app-id"] = []string{"ShareBase"}
-	for _, o := range options {
-		if err = o(req); err != nil {
-			return nil, nil, errors.ErrorfWithCause(
-				err,
-				"error applying option: %v (type: %T): %v",
-				o, o, err)
-		}
-	}
 	if logger.Level() <= logging.VerboseLevel {
 		buffer := bytes.Buffer{}
-		if err = req.Write(&buffer); err != nil {
-			return nil, nil, err
+		if err := req.Write(&buffer); err != nil {
+			return nil, err
 		}
 		bufferBytes := buffer.Bytes()
 		var bufferString string
@@ -252,33 +451,85 @@ func (c *Client) requestBody(method string, uri string, source io.Reader, option
 			"request (%d bytes total):\n\n%v",
 			len(bufferBytes), bufferString)
 	}
+	start := time.Now()
 	res, err := c.httpClient.Do(req)
-	c.numRequests++
+	atomic.AddUint64(&c.numRequests, 1)
+	if c.Metrics != nil {
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		c.Metrics.ObserveRequest(req.Method, status, time.Since(start))
+	}
+	return res, err
+}
+
+// requestResponse is like requestBody but returns the whole successful
+// response for callers that need to check more than its headers (e.g. the
+// exact status code).  The response body must be closed.
+func (c *Client) requestResponse(method string, uri string, source io.Reader, options ...requestOption) (*http.Response, error) {
+	if _, err := stringNotEmpty(method, "method"); err != nil {
+		return nil, err
+	}
+	if atomic.LoadUint32(&c.loggedOut) != 0 {
+		return nil, ErrUnauthorized
+	}
+	req, err := http.NewRequest(method, uri, source)
 	if err != nil {
-		return nil, nil, errors.ErrorfWithCause(
+		return nil, errors.ErrorfWithCause(
+			err,
+			"failed to create request for %v: %v",
+			uri, err)
+	}
+	for _, o := range options {
+		if err = o(req); err != nil {
+			return nil, errors.ErrorfWithCause(
+				err,
+				"error applying option: %v (type: %T): %v",
+				o, o, err)
+		}
+	}
+	res, err := c.send(req)
+	if err != nil {
+		return nil, errors.ErrorfWithCause(
 			err,
 			"failed to complete request for %v: %v",
 			uri, err)
 	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		res.Body.Close()
+		requestID := responseRequestID(res.Header)
 		switch res.StatusCode {
-		case 401:
+		case http.StatusUnauthorized:
 			// TODO(skillian): Eventually wrap this function to
 			// re-authenticate when this error is returned and
 			// then retry.
-			return nil, nil, ErrUnauthorized
-		case 404:
+			return nil, Unauthorized{requestID: requestID}
+		case http.StatusForbidden:
+			return nil, Forbidden{requestID: requestID}
+		case http.StatusNotFound:
 			// The caller must check if the result is NotFound and populate the
 			// fields.
-			return nil, nil, NotFound{}
+			return nil, NotFound{requestID: requestID}
+		case http.StatusConflict:
+			// Callers creating objects turn this into
+			// AlreadyExists.
+			return nil, Conflict{requestID: requestID}
+		case http.StatusTooManyRequests:
+			return nil, RateLimited{
+				RetryAfter: parseRetryAfter(
+					res.Header.Get("Retry-After"), time.Now()),
+				requestID: requestID,
+			}
 		default:
-			return nil, nil, statusError{
-				code: res.StatusCode,
-				msg:  res.Status,
+			return nil, StatusError{
+				Code:      res.StatusCode,
+				Status:    res.Status,
+				requestID: requestID,
 			}
 		}
 	}
-	return res.Header, res.Body, nil
+	return res, nil
 }
 
 // request a given relative or absolute URI with the given method.  The body
@@ -326,7 +577,8 @@ func (c *Client) requestJSON(method string, uri string, source, target interface
 	// the interface itself will be nil.
 	var w io.Writer
 	if target != nil {
-		b = new(bytes.Buffer)
+		b = getBuffer()
+		defer putBuffer(b)
 		w = b
 	}
 	options = append(options, setContentType("application/json"))
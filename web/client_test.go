@@ -0,0 +1,586 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestWhoami(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != web.PhoenixTokenPrefix+"token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Token":          "token",
+			"UserName":       "someone@example.com",
+			"UserId":         42,
+			"ExpirationDate": expires,
+		})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := c.Whoami()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.UserName != "someone@example.com" || tok.UserID != 42 || !tok.ExpirationDate.Equal(expires) {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+	c, err = web.NewClient(srv.URL, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Whoami(); err != web.ErrUnauthorized {
+		t.Fatalf("expected %v, got %v", web.ErrUnauthorized, err)
+	}
+}
+
+func TestWhoamiDataCenterPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sharebaseapi/api/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(web.AuthToken{Token: "token", UserID: 42})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL+"/sharebaseapi", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := c.Whoami()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.UserID != 42 {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+}
+
+func TestNewLibrary(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/libraries" {
+			http.NotFound(w, r)
+			return
+		}
+		var req web.NewLibraryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		if req.LibraryName == "Existing" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(web.Library{
+			LibraryID:   7,
+			LibraryName: req.LibraryName,
+			IsPrivate:   req.IsPrivate,
+			Links: web.LibraryLinks{
+				Self:    "http://" + r.Host + "/api/libraries/7",
+				Folders: "http://" + r.Host + "/api/libraries/7/folders",
+			},
+		})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := c.NewLibrary("New", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.LibraryID != 7 || lib.LibraryName != "New" || !lib.IsPrivate || lib.Links.Folders == "" {
+		t.Fatalf("unexpected library: %+v", lib)
+	}
+	_, err = c.NewLibrary("Existing", false)
+	if ae, ok := err.(web.AlreadyExists); !ok || ae.Kind != web.LibraryKind || ae.Name != "Existing" {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+}
+
+func TestNewLibraryDataCenterPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sharebaseapi/api/libraries" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(web.Library{LibraryID: 7, LibraryName: "New"})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL+"/sharebaseapi", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := c.NewLibrary("New", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lib.LibraryID != 7 {
+		t.Fatalf("unexpected library: %+v", lib)
+	}
+}
+
+func TestNewFolderConflict(t *testing.T) {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			// another client already created the folder.
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode([]web.Folder{{
+			FolderID: 10, FolderName: "Top", LibraryID: 1,
+			Links: web.FolderLinks{Folders: srv.URL + "/api/folders/10/folders"},
+		}})
+	})
+	mux.HandleFunc("/api/folders/10/folders", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Folder{
+			{FolderID: 11, FolderName: "Other", LibraryID: 1},
+			{FolderID: 12, FolderName: "Sub", LibraryID: 1},
+		})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := web.Library{
+		LibraryID: 1,
+		Links:     web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+	}
+	f, err := lib.NewFolder(c, "Top", "Sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FolderID != 12 || f.FolderName != "Sub" {
+		t.Fatalf("expected the existing folder, got %+v", f)
+	}
+}
+
+// BenchmarkRequestJSON shows the allocations of listing a folder's
+// subfolders, which is most of the requests of a recursive upload.
+func BenchmarkRequestJSON(b *testing.B) {
+	folders := make([]web.Folder, 100)
+	for i := range folders {
+		folders[i] = web.Folder{FolderID: i + 1, FolderName: "folder", LibraryID: 1}
+	}
+	body, err := json.Marshal(folders)
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := web.Folder{Links: web.FolderLinks{Folders: srv.URL}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = f.Folders(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLargeDocument shows the allocations of a large upload's patches.
+// The server discards the patches so that only the client's allocations are
+// counted.
+func BenchmarkLargeDocument(b *testing.B) {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/folders/1/temp", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{
+			Links: web.NewLargeDocumentResponseLinks{Location: srv.URL + "/temp/1"},
+		})
+	})
+	mux.HandleFunc("/temp/1", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{CurrentSize: uint64(n)})
+	})
+	mux.HandleFunc("/folders/1/documents", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(web.Document{DocumentID: 1})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := web.Folder{FolderID: 1, Links: web.FolderLinks{
+		Self:      srv.URL + "/folders/1",
+		Documents: srv.URL + "/folders/1/documents",
+	}}
+	content := make([]byte, 8*web.M)
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = f.NewDocumentWithSize(
+			c, "large.bin", bytes.NewReader(content), -1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the library's ID is the status code to respond with.
+		code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/libraries/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "30")
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		code   int
+		target error
+	}{
+		{http.StatusUnauthorized, web.Unauthorized{}},
+		{http.StatusForbidden, web.Forbidden{}},
+		{http.StatusConflict, web.Conflict{}},
+		{http.StatusTooManyRequests, web.RateLimited{}},
+		{http.StatusInternalServerError, web.StatusError{Code: http.StatusInternalServerError}},
+		{http.StatusBadGateway, web.StatusError{}},
+	} {
+		_, err := c.Library(tc.code)
+		if !errors.Is(err, tc.target) {
+			t.Errorf("%d: expected %T, got %T: %v", tc.code, tc.target, err, err)
+		}
+		if rl, ok := err.(web.RateLimited); ok && rl.RetryAfter != 30*time.Second {
+			t.Errorf("expected to retry after 30s, got %v", rl.RetryAfter)
+		}
+	}
+	if _, err = c.Library(http.StatusUnauthorized); err != web.ErrUnauthorized {
+		t.Fatalf("expected %v, got %v", web.ErrUnauthorized, err)
+	}
+	if errors.Is(web.AlreadyExists{}, web.Forbidden{}) || !errors.Is(web.AlreadyExists{}, web.Conflict{}) {
+		t.Fatal("AlreadyExists should only match Conflict")
+	}
+}
+
+func TestResolveDataCenter(t *testing.T) {
+	for _, tc := range []struct {
+		dataCenter string
+		expected   string
+	}{
+		{"us", "https://app.sharebase.com/sharebaseapi"},
+		{" US ", "https://app.sharebase.com/sharebaseapi"},
+		{"https://app.sharebase.com/sharebaseapi/", "https://app.sharebase.com/sharebaseapi"},
+		{"HTTPS://App.ShareBase.com", "https://app.sharebase.com/sharebaseapi"},
+		{"app.sharebase.com/sharebaseapi", "https://app.sharebase.com/sharebaseapi"},
+		// on-premises and test servers aren't checked.
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{"sharebase.example.com/api/", "https://sharebase.example.com/api"},
+		{"usa", ""},
+		{"ftp://app.sharebase.com/sharebaseapi", ""},
+		{"https:///sharebaseapi", ""},
+		// unlisted hosted data centers are only warned about.
+		{"https://eu.sharebase.com/sharebaseapi", "https://eu.sharebase.com/sharebaseapi"},
+		{"EU.sharebase.com/sharebaseapi/", "https://eu.sharebase.com/sharebaseapi"},
+		{"https://app.sharebase.com/api", ""},
+	} {
+		u, err := web.ResolveDataCenter(tc.dataCenter)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.dataCenter, u)
+			} else if !strings.Contains(err.Error(), "us (https://app.sharebase.com/sharebaseapi)") &&
+				!strings.Contains(err.Error(), "http or https") &&
+				!strings.Contains(err.Error(), "no host") {
+				t.Errorf("%q: expected the error to name the data centers, got %v", tc.dataCenter, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.dataCenter, err)
+			continue
+		}
+		if u.String() != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.dataCenter, tc.expected, u)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/authenticate" || r.Method != http.MethodDelete {
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL.Path)
+			return
+		}
+		if deleted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	n := c.NumRequests()
+	if _, err = c.Libraries(); err != web.ErrUnauthorized {
+		t.Fatalf("expected %v, got %v", web.ErrUnauthorized, err)
+	}
+	if c.NumRequests() != n {
+		t.Fatal("expected requests after Logout not to be sent")
+	}
+	// a token that was already invalidated can be logged out of again.
+	c, err = web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogoutDataCenterPath(t *testing.T) {
+	var deleted bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sharebaseapi/api/authenticate" || r.Method != http.MethodDelete {
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL+"/sharebaseapi", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Fatal("expected the token to be deleted")
+	}
+}
+
+func TestDeleteLibrary(t *testing.T) {
+	var (
+		srv     *httptest.Server
+		deleted []string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/libraries/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, r.URL.Path)
+			return
+		}
+		json.NewEncoder(w).Encode(web.Library{
+			LibraryID: 1, LibraryName: "Lib",
+			Links: web.LibraryLinks{Folders: srv.URL + "/api/libraries/1/folders"},
+		})
+	})
+	mux.HandleFunc("/api/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Folder{{FolderID: 10, FolderName: "Top", LibraryID: 1}})
+	})
+	mux.HandleFunc("/api/folders/10", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("unexpected %v of %v", r.Method, r.URL.Path)
+		}
+		deleted = append(deleted, r.URL.Path)
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteLibrary(1, false); err != (web.NotEmpty{Kind: web.LibraryKind, Name: "Lib"}) {
+		t.Fatalf("expected NotEmpty, got %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, got %q", deleted)
+	}
+	if err = c.DeleteLibrary(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, " ") != "/api/folders/10 /api/libraries/1" {
+		t.Fatalf("expected the folder and then the library to be deleted, got %q", deleted)
+	}
+	err = c.DeleteLibrary(2, true)
+	if nf, ok := err.(web.NotFound); !ok || nf.Kind != web.LibraryKind || nf.ID != 2 {
+		t.Fatalf("expected a library NotFound, got %v", err)
+	}
+}
+
+func TestLibrariesWhere(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Library{
+			{LibraryID: 1, LibraryName: "My Library", IsPrivate: true},
+			{LibraryID: 2, LibraryName: "Team"},
+			{LibraryID: 3, LibraryName: "Archive"},
+		})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(libs []web.Library, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := make([]string, len(libs))
+		for i, lib := range libs {
+			s[i] = strconv.Itoa(lib.LibraryID)
+		}
+		return strings.Join(s, ",")
+	}
+	if got := ids(c.PersonalLibraries()); got != "1" {
+		t.Errorf("expected personal library 1, got %v", got)
+	}
+	if got := ids(c.SharedLibraries()); got != "2,3" {
+		t.Errorf("expected shared libraries 2,3, got %v", got)
+	}
+	if got := ids(c.LibrariesWhere(func(lib web.Library) bool {
+		return strings.HasPrefix(lib.LibraryName, "A")
+	})); got != "3" {
+		t.Errorf("expected library 3, got %v", got)
+	}
+	if got := ids(c.Libraries()); got != "1,2,3" {
+		t.Errorf("expected all of the libraries, got %v", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/libraries/1":
+			w.Header().Set("X-Request-Id", "req-1")
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api/libraries/2":
+			w.Header().Set("X-Correlation-Id", "req-2")
+			http.NotFound(w, r)
+		case "/api/libraries/401", "/api/libraries/403",
+			"/api/libraries/409", "/api/libraries/429":
+			code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/libraries/"))
+			w.Header().Set("X-Request-Id", "req-"+strconv.Itoa(code))
+			w.WriteHeader(code)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Library(1)
+	if se, ok := err.(web.StatusError); !ok || se.RequestID() != "req-1" {
+		t.Errorf("expected a StatusError with request ID req-1, got %v", err)
+	}
+	_, err = c.Library(2)
+	if nf, ok := err.(web.NotFound); !ok || nf.RequestID() != "req-2" || nf.Kind != web.LibraryKind || nf.ID != 2 {
+		t.Errorf("expected a library NotFound with request ID req-2, got %v", err)
+	}
+	_, err = c.Library(3)
+	if nf, ok := err.(web.NotFound); !ok || nf.RequestID() != "" {
+		t.Errorf("expected a NotFound without a request ID, got %v", err)
+	}
+	for _, tc := range []struct {
+		code   int
+		target error
+	}{
+		{http.StatusUnauthorized, web.Unauthorized{}},
+		{http.StatusForbidden, web.Forbidden{}},
+		{http.StatusConflict, web.Conflict{}},
+		{http.StatusTooManyRequests, web.RateLimited{}},
+	} {
+		_, err = c.Library(tc.code)
+		r, ok := err.(interface{ RequestID() string })
+		if !errors.Is(err, tc.target) || !ok || r.RequestID() != "req-"+strconv.Itoa(tc.code) {
+			t.Errorf("%d: expected a %T with request ID req-%d, got %v", tc.code, tc.target, tc.code, err)
+		}
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != web.PhoenixTokenPrefix+"token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/sharebaseapi/api/new-feature" || r.URL.RawQuery != "x=1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL+"/sharebaseapi", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := web.NewMemoryMetrics()
+	c.Metrics = m
+	req, err := http.NewRequest(http.MethodGet, "api/new-feature?x=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unsuccessful responses are returned as they are.
+	if res.StatusCode != http.StatusTeapot || string(body) != "hello" {
+		t.Fatalf("unexpected response: %v %q", res.Status, body)
+	}
+	if req.Header.Get("Authorization") != "" || req.URL.IsAbs() {
+		t.Fatal("expected the caller's request to be left alone")
+	}
+	if c.NumRequests() != 1 || m.Counts()[web.RequestKey{Method: http.MethodGet, Status: http.StatusTeapot}] != 1 {
+		t.Fatalf("expected the request to be counted, got %d and %v", c.NumRequests(), m.Counts())
+	}
+}
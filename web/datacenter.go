@@ -0,0 +1,126 @@
+package web
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/skillian/errors"
+)
+
+// DataCenters maps the short names of ShareBase's hosted data centers to
+// their API URLs.  Any of the names can be passed to NewClient (or
+// ResolveDataCenter) instead of the URL.
+var DataCenters = map[string]string{
+	"us": "https://app.sharebase.com/sharebaseapi",
+}
+
+// hostedDomain is the domain of ShareBase's hosted data centers.  URLs of the
+// DataCenters' hosts have to have their API paths.  Other hosts in it might
+// be hosted data centers that aren't listed yet, so they're only warned
+// about, and hosts outside of it are assumed to be on-premises or custom
+// deployments and aren't checked.
+const hostedDomain = "sharebase.com"
+
+// warnedHosts holds the unlisted hosts in the hosted domain that have already
+// been warned about so that resolving them again, like a ClientPool does for
+// every client, doesn't repeat the warning.
+var warnedHosts sync.Map
+
+// ResolveDataCenter resolves dataCenter, which is either one of the
+// DataCenters' short names or a URL, to the data center's API URL.
+//
+// URLs are normalized: "https://" is added if there's no scheme, the scheme
+// and host are lowercased, and the trailing slash is removed.  A hosted
+// data center's URL without a path gets its API path.  URLs of the
+// DataCenters' hosts with other paths, bare words that aren't one of their
+// names, and schemes other than http and https are rejected with an error
+// naming the valid data centers.  URLs of other hosts in ShareBase's domain
+// are used as they are after a warning.
+func ResolveDataCenter(dataCenter string) (*url.URL, error) {
+	dataCenter = strings.TrimSpace(dataCenter)
+	if _, err := stringNotEmpty(dataCenter, "dataCenter"); err != nil {
+		return nil, err
+	}
+	if known, ok := DataCenters[strings.ToLower(dataCenter)]; ok {
+		return parseURL(known)
+	}
+	if !strings.Contains(dataCenter, "://") {
+		if !strings.ContainsAny(dataCenter, ".:/") {
+			return nil, errors.Errorf(
+				"unknown data center %q: expected one of %v or a URL",
+				dataCenter, validDataCenters())
+		}
+		dataCenter = "https://" + dataCenter
+	}
+	u, err := parseURL(dataCenter)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf(
+			"invalid data center %q: expected an http or https URL",
+			dataCenter)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf(
+			"invalid data center %q: the URL has no host", dataCenter)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !isHostedDomain(u.Hostname()) {
+		return u, nil
+	}
+	listed := false
+	for _, known := range DataCenters {
+		k, err := parseURL(known)
+		if err != nil {
+			return nil, err
+		}
+		if k.Host != u.Host {
+			continue
+		}
+		listed = true
+		if u.Path == "" {
+			u.Path = k.Path
+		}
+		if path.Clean(u.Path) == path.Clean(k.Path) {
+			return u, nil
+		}
+	}
+	if !listed {
+		if _, warned := warnedHosts.LoadOrStore(u.Host, true); !warned {
+			logger.Warn(
+				"%v isn't one of the known data centers (%v); "+
+					"using it anyway",
+				u, validDataCenters())
+		}
+		return u, nil
+	}
+	return nil, errors.Errorf(
+		"unknown data center %q: expected one of %v or the URL of an "+
+			"on-premises deployment",
+		dataCenter, validDataCenters())
+}
+
+// isHostedDomain checks if host is in ShareBase's domain.
+func isHostedDomain(host string) bool {
+	return host == hostedDomain || strings.HasSuffix(host, "."+hostedDomain)
+}
+
+// validDataCenters lists the DataCenters' names and URLs, sorted by name,
+// for errors.
+func validDataCenters() string {
+	names := make([]string, 0, len(DataCenters))
+	for name := range DataCenters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + " (" + DataCenters[name] + ")"
+	}
+	return strings.Join(names, ", ")
+}
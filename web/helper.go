@@ -1,10 +1,16 @@
 package web
 
 import (
+	"bytes"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/skillian/errors"
 	"github.com/skillian/logging"
@@ -19,9 +25,22 @@ const (
 var (
 	logger = logging.GetLogger("github.com/skillian/sharebase")
 
-	// ErrUnauthorized is returned when the request results in a 401
-	// unauthorized response.
-	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnauthorized is returned when a logged out Client makes a
+	// request.  It's an Unauthorized, so errors.Is matches it with the
+	// Unauthorized errors of 401 responses, too, but those only compare
+	// equal to it with == if ShareBase didn't send a request ID.
+	ErrUnauthorized error = Unauthorized{}
+
+	// ErrServerCopyUnsupported is returned from Document.CopyOnServer
+	// when ShareBase doesn't support copying documents server-side.
+	ErrServerCopyUnsupported = errors.New(
+		"server-side document copies are not supported")
+
+	// ErrFieldsUnsupported is returned from Document.Fields and
+	// Document.SetFields when ShareBase doesn't support document index
+	// fields.
+	ErrFieldsUnsupported = errors.New(
+		"document index fields are not supported")
 )
 
 // Lener is implemented by types that have a Len method returning their
@@ -32,6 +51,40 @@ type Lener interface {
 	Len() int
 }
 
+// Lener64 is like Lener but for types whose length might not fit in an int,
+// like files larger than 2GiB on 32-bit platforms.  Folder.NewDocument checks
+// for it before Lener.
+type Lener64 interface {
+	// Len64 gets the length of the type's storage in bytes.
+	Len64() int64
+}
+
+// maxPooledBufferSize is the capacity above which buffers aren't put back into
+// bufferPool so that one unusually large response doesn't stay allocated.
+// It's big enough for the largest patch buffers.
+const maxPooledBufferSize = int(4 * MaxPatchSize)
+
+// bufferPool holds the buffers that responses and patches are read into so
+// that they can be reused across requests.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getBuffer gets an empty buffer from bufferPool.  It must be returned with
+// putBuffer once nothing refers to its contents.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer resets b and puts it back into bufferPool.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
 // mergeURL merges non-empty source fields into the given target.
 func mergeURL(target, source *url.URL) {
 	if source == nil || target == nil {
@@ -113,6 +166,21 @@ type NotFound struct {
 	Kind
 	ID   int
 	Name string
+
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
+}
+
+// RequestID gets the ID that ShareBase gave the request that wasn't found,
+// which Hyland support can use to trace it.  It's empty if ShareBase didn't
+// send one.
+func (err NotFound) RequestID() string { return err.requestID }
+
+// describe fills in which object wasn't found while keeping the request ID
+// of the response that err came from.
+func (err NotFound) describe(kind Kind, id int, name string) NotFound {
+	err.Kind, err.ID, err.Name = kind, id, name
+	return err
 }
 
 // Error implements the error interface.
@@ -124,12 +192,372 @@ func (err NotFound) Error() string {
 	return fmt.Sprintf("%v %v not found", err.Kind, key)
 }
 
-type statusError struct {
-	code int
-	msg  string
+// AlreadyExists is an error returned when a ShareBase object cannot be
+// created because one with the same name already exists.
+type AlreadyExists struct {
+	Kind
+	Name string
+}
+
+// Error implements the error interface.
+func (err AlreadyExists) Error() string {
+	return fmt.Sprintf("%v %v already exists", err.Kind, err.Name)
+}
+
+// Is makes AlreadyExists match Conflict because it's what a 409 Conflict
+// response becomes when the conflict is with an existing object.
+func (err AlreadyExists) Is(target error) bool {
+	switch target.(type) {
+	case AlreadyExists, Conflict:
+		return true
+	}
+	return false
+}
+
+// NotEmpty is returned when deleting a ShareBase object that still has
+// children without deleting them, too.
+type NotEmpty struct {
+	Kind
+	Name string
+}
+
+// Error implements the error interface.
+func (err NotEmpty) Error() string {
+	return fmt.Sprintf("%v %v is not empty", err.Kind, err.Name)
+}
+
+// InvalidNameChars are the characters, besides control characters, that
+// ShareBase doesn't allow in folder and document names.  The slashes are
+// included because ShareBase treats names with them as paths.
+const InvalidNameChars = "\\/:\"<>|"
+
+// InvalidName is returned when a document or folder name is empty or has
+// characters that ShareBase doesn't allow in names.
+type InvalidName struct {
+	Name string
+
+	// Char is the first invalid character in Name.  It's 0 if Name is
+	// empty.
+	Char rune
+}
+
+// Error implements the error interface.
+func (err InvalidName) Error() string {
+	if err.Name == "" {
+		return "name cannot be empty"
+	}
+	return fmt.Sprintf("invalid name %q: %q is not allowed", err.Name, err.Char)
+}
+
+func isInvalidNameChar(r rune) bool {
+	return unicode.IsControl(r) || strings.ContainsRune(InvalidNameChars, r)
+}
+
+// ValidateName returns an InvalidName if name can't be the name of a
+// ShareBase document or folder.
+func ValidateName(name string) error {
+	if name == "" {
+		return InvalidName{}
+	}
+	if i := strings.IndexFunc(name, isInvalidNameChar); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(name[i:])
+		return InvalidName{Name: name, Char: r}
+	}
+	return nil
+}
+
+// CleanName removes the characters from name that can't be in a ShareBase
+// name: InvalidNameChars and control characters.  Any other characters,
+// including non-ASCII letters, are kept.
+func CleanName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if isInvalidNameChar(r) {
+			return -1
+		}
+		return r
+	}, name)
+}
+
+// Unauthorized is returned when a request results in a 401 Unauthorized
+// response because the client's token is missing, invalid, or expired.
+type Unauthorized struct {
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
+}
+
+// RequestID gets the ID that ShareBase gave the unauthorized request.  It's
+// empty if ShareBase didn't send one.
+func (err Unauthorized) RequestID() string { return err.requestID }
+
+// Error implements the error interface.
+func (Unauthorized) Error() string { return "unauthorized" }
+
+// Is matches any Unauthorized error.
+func (Unauthorized) Is(target error) bool {
+	_, ok := target.(Unauthorized)
+	return ok
+}
+
+// Forbidden is returned when a request results in a 403 Forbidden response
+// because the authenticated user isn't allowed to do it.
+type Forbidden struct {
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
+}
+
+// RequestID gets the ID that ShareBase gave the forbidden request.  It's
+// empty if ShareBase didn't send one.
+func (err Forbidden) RequestID() string { return err.requestID }
+
+// Error implements the error interface.
+func (Forbidden) Error() string { return "forbidden" }
+
+// Is matches any Forbidden error.
+func (Forbidden) Is(target error) bool {
+	_, ok := target.(Forbidden)
+	return ok
+}
+
+// Conflict is returned when a request results in a 409 Conflict response.
+// Functions that create objects turn it into AlreadyExists with the
+// object's kind and name.
+type Conflict struct {
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
+}
+
+// RequestID gets the ID that ShareBase gave the conflicting request.  It's
+// empty if ShareBase didn't send one.
+func (err Conflict) RequestID() string { return err.requestID }
+
+// Error implements the error interface.
+func (Conflict) Error() string { return "conflict" }
+
+// Is matches any Conflict error.
+func (Conflict) Is(target error) bool {
+	_, ok := target.(Conflict)
+	return ok
+}
+
+// RateLimited is returned when a request results in a 429 Too Many Requests
+// response.
+type RateLimited struct {
+	// RetryAfter is how long ShareBase asked to wait before trying
+	// again with its Retry-After header.  It's 0 if it didn't say.
+	RetryAfter time.Duration
+
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
 }
 
-func (err statusError) Error() string {
+// RequestID gets the ID that ShareBase gave the rate limited request.  It's
+// empty if ShareBase didn't send one.
+func (err RateLimited) RequestID() string { return err.requestID }
+
+// Error implements the error interface.
+func (err RateLimited) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited: retry after %v", err.RetryAfter)
+	}
+	return "rate limited"
+}
+
+// Is matches any RateLimited error, regardless of its RetryAfter.
+func (RateLimited) Is(target error) bool {
+	_, ok := target.(RateLimited)
+	return ok
+}
+
+// parseRetryAfter parses a Retry-After header's value, which is either a
+// number of seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// IntegrityError is returned when the hash of uploaded content computed
+// locally doesn't match the hash that ShareBase reports for the document.
+type IntegrityError struct {
+	// Name is the name of the uploaded document.
+	Name string
+
+	// Expected is the hash computed locally from the uploaded content.
+	Expected []byte
+
+	// Actual is the hash reported by ShareBase.
+	Actual []byte
+}
+
+// Error implements the error interface.
+func (err IntegrityError) Error() string {
+	return fmt.Sprintf(
+		"document %q failed integrity check: local hash %x does not "+
+			"match ShareBase hash %x",
+		err.Name, err.Expected, err.Actual)
+}
+
+// SizeMismatch is returned when the size of an upload that ShareBase reports
+// doesn't match the number of bytes written to it locally.
+type SizeMismatch struct {
+	// Name is the name of the uploaded document.
+	Name string
+
+	// Expected is the number of bytes written locally.
+	Expected int64
+
+	// Actual is the number of bytes ShareBase reports it received.
+	Actual int64
+}
+
+// Error implements the error interface.
+func (err SizeMismatch) Error() string {
+	return fmt.Sprintf(
+		"document %q size mismatch: wrote %d bytes but ShareBase "+
+			"received %d",
+		err.Name, err.Expected, err.Actual)
+}
+
+// UploadCanceled is returned when a large document upload is stopped because
+// its context is done.  The upload's temporary file is deleted before it's
+// returned.
+type UploadCanceled struct {
+	// Name is the name of the document that was being uploaded.
+	Name string
+
+	// Sent is the number of bytes that were uploaded before it stopped.
+	Sent int64
+
+	// Err is the context's error, context.Canceled or
+	// context.DeadlineExceeded.
+	Err error
+}
+
+// Error implements the error interface.
+func (err UploadCanceled) Error() string {
 	return fmt.Sprintf(
-		"status %d: %v", err.code, err.msg)
+		"upload of document %q stopped after %d bytes: %v",
+		err.Name, err.Sent, err.Err)
+}
+
+// Unwrap gets the context's error.
+func (err UploadCanceled) Unwrap() error { return err.Err }
+
+// IncompleteSize is returned from Folder.Size along with the size of
+// whatever could be counted when some of the folder's subfolders couldn't be
+// listed.
+type IncompleteSize struct {
+	// Name is the name of the folder that was being sized.
+	Name string
+
+	// Size is the size of the documents that were counted.
+	Size int64
+
+	// Errs holds the errors from listing the subfolders that couldn't
+	// be counted.
+	Errs []error
+
+	// Skipped is the number of subfolders that weren't listed because
+	// sizing the folder would make more than MaxFolderSizeRequests
+	// requests.
+	Skipped int
+}
+
+// Error implements the error interface.
+func (err IncompleteSize) Error() string {
+	msg := fmt.Sprintf(
+		"size of folder %q is incomplete: only %d bytes were counted",
+		err.Name, err.Size)
+	if err.Skipped > 0 {
+		msg = fmt.Sprintf(
+			"%v (%d subfolders were skipped after %d requests)",
+			msg, err.Skipped, MaxFolderSizeRequests)
+	}
+	if len(err.Errs) > 0 {
+		msg = fmt.Sprintf(
+			"%v: %d subfolders failed, the first with: %v",
+			msg, len(err.Errs), err.Errs[0])
+	}
+	return msg
+}
+
+// MissingFields is returned from CheckFields when required index fields
+// don't have values.
+type MissingFields struct {
+	// Names are the names of the required fields without values.
+	Names []string
+}
+
+// Error implements the error interface.
+func (err MissingFields) Error() string {
+	return fmt.Sprintf(
+		"missing required fields: %v", strings.Join(err.Names, ", "))
+}
+
+// StatusError is returned when a request results in an unsuccessful
+// response whose status doesn't have its own error type.
+type StatusError struct {
+	// Code is the response's status code.
+	Code int
+
+	// Status is the response's status line, like "500 Internal Server
+	// Error".
+	Status string
+
+	// requestID is the ID that ShareBase gave the request, if any.
+	requestID string
+}
+
+// RequestID gets the ID that ShareBase gave the failed request, which Hyland
+// support can use to trace it.  It's empty if ShareBase didn't send one.
+func (err StatusError) RequestID() string { return err.requestID }
+
+// requestIDHeaders are the response headers that ShareBase, or the proxies in
+// front of it, might put a request's ID in, in the order they're checked.
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id", "X-Correlation-Id"}
+
+// responseRequestID gets a response's request ID from its headers.
+func responseRequestID(h http.Header) string {
+	for _, k := range requestIDHeaders {
+		if v := h.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Error implements the error interface.
+func (err StatusError) Error() string {
+	return fmt.Sprintf(
+		"status %d: %v", err.Code, err.Status)
+}
+
+// Is matches a StatusError with the same Code or, if target's Code is 0, any
+// StatusError.
+func (err StatusError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	return ok && (t.Code == 0 || t.Code == err.Code)
+}
+
+// isUnsupported checks if the error returned from a request indicates that
+// the endpoint doesn't exist or doesn't support the request method.
+func isUnsupported(err error) bool {
+	switch err := err.(type) {
+	case NotFound:
+		return true
+	case StatusError:
+		return err.Code == http.StatusMethodNotAllowed ||
+			err.Code == http.StatusNotImplemented
+	}
+	return false
 }
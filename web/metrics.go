@@ -0,0 +1,137 @@
+package web
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics observes the requests that Clients send to ShareBase, for example
+// to export them to a monitoring system.  Clients sharing a Metrics call it
+// from multiple goroutines, so implementations must be safe for concurrent
+// use.
+type Metrics interface {
+	// ObserveRequest is called after each request with the request's
+	// method, the response's status code, and how long it took to get
+	// the response.  status is 0 if no response was received at all.
+	ObserveRequest(method string, status int, duration time.Duration)
+}
+
+// RequestKey identifies the requests that MemoryMetrics counts together.
+type RequestKey struct {
+	Method string
+	Status int
+}
+
+// LatencyBuckets are the upper bounds of the latency histograms' buckets
+// that MemoryMetrics keeps.  They're the same as Prometheus' default
+// buckets.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram is a histogram of request latencies.
+type LatencyHistogram struct {
+	// Buckets are the upper bounds of the histogram's buckets.
+	Buckets []time.Duration
+
+	// Counts holds the number of requests in each bucket, plus one more
+	// for those that took longer than the last bucket.  Unlike
+	// Prometheus' buckets, they're not cumulative.
+	Counts []uint64
+
+	// Count is the total number of requests.
+	Count uint64
+
+	// Sum is the total time that the requests took.
+	Sum time.Duration
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		Buckets: LatencyBuckets,
+		Counts:  make([]uint64, len(LatencyBuckets)+1),
+	}
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool {
+		return d <= h.Buckets[i]
+	})
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *LatencyHistogram) clone() LatencyHistogram {
+	h2 := *h
+	h2.Counts = append([]uint64(nil), h.Counts...)
+	return h2
+}
+
+// MemoryMetrics is a Metrics that keeps the count of requests by method and
+// status code and a histogram of the requests' latencies by method in
+// memory.  It's safe to use from multiple goroutines.
+type MemoryMetrics struct {
+	// mutex protects counts and latencies.
+	mutex     sync.Mutex
+	counts    map[RequestKey]uint64
+	latencies map[string]*LatencyHistogram
+}
+
+var _ Metrics = (*MemoryMetrics)(nil)
+
+// NewMemoryMetrics creates an empty MemoryMetrics.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		counts:    make(map[RequestKey]uint64),
+		latencies: make(map[string]*LatencyHistogram),
+	}
+}
+
+// ObserveRequest implements Metrics.
+func (m *MemoryMetrics) ObserveRequest(method string, status int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.counts[RequestKey{Method: method, Status: status}]++
+	h, ok := m.latencies[method]
+	if !ok {
+		h = newLatencyHistogram()
+		m.latencies[method] = h
+	}
+	h.observe(duration)
+}
+
+// Counts gets a copy of the number of requests observed so far by their
+// methods and status codes.
+func (m *MemoryMetrics) Counts() map[RequestKey]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	counts := make(map[RequestKey]uint64, len(m.counts))
+	for k, n := range m.counts {
+		counts[k] = n
+	}
+	return counts
+}
+
+// Latencies gets a copy of the latency histogram of the requests observed
+// so far with the given method.
+func (m *MemoryMetrics) Latencies(method string) LatencyHistogram {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, ok := m.latencies[method]
+	if !ok {
+		return newLatencyHistogram().clone()
+	}
+	return h.clone()
+}
@@ -0,0 +1,76 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestMemoryMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/libraries/2" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	m := web.NewMemoryMetrics()
+	p := web.NewClientPoolWithConfig(web.ClientPoolConfig{Metrics: m})
+	defer p.Close()
+	c, err := p.Client(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 1, 2} {
+		c.Library(id)
+	}
+	if _, err = c.NewLibrary("Lib", false); err != nil {
+		t.Fatal(err)
+	}
+	counts := m.Counts()
+	expected := map[web.RequestKey]uint64{
+		{Method: http.MethodGet, Status: http.StatusOK}:       2,
+		{Method: http.MethodGet, Status: http.StatusNotFound}: 1,
+		{Method: http.MethodPost, Status: http.StatusOK}:      1,
+	}
+	if len(counts) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+	for k, n := range expected {
+		if counts[k] != n {
+			t.Fatalf("expected %v, got %v", expected, counts)
+		}
+	}
+	if n := c.NumRequests(); n != 4 {
+		t.Fatalf("expected NumRequests to still count 4 requests, got %d", n)
+	}
+	h := m.Latencies(http.MethodGet)
+	var total uint64
+	for _, n := range h.Counts {
+		total += n
+	}
+	if h.Count != 3 || total != 3 || len(h.Counts) != len(h.Buckets)+1 || h.Sum <= 0 {
+		t.Fatalf("unexpected GET latencies: %+v", h)
+	}
+	if h := m.Latencies(http.MethodDelete); h.Count != 0 {
+		t.Fatalf("expected no DELETE latencies, got %+v", h)
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	m := web.NewMemoryMetrics()
+	for _, d := range []time.Duration{time.Millisecond, 5 * time.Millisecond, 6 * time.Millisecond, time.Minute} {
+		m.ObserveRequest(http.MethodGet, http.StatusOK, d)
+	}
+	h := m.Latencies(http.MethodGet)
+	if h.Counts[0] != 2 || h.Counts[1] != 1 || h.Counts[len(h.Counts)-1] != 1 {
+		t.Fatalf("unexpected bucket counts: %v", h.Counts)
+	}
+	if h.Sum != 12*time.Millisecond+time.Minute {
+		t.Fatalf("unexpected sum: %v", h.Sum)
+	}
+}
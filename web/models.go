@@ -2,19 +2,26 @@ package web
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"math/big"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/skillian/errors"
-	"github.com/skillian/mparthelp"
 )
 
 // Size defines a file size in bytes
@@ -34,18 +41,45 @@ const (
 	G
 )
 
+// Human formats the size with the largest of the B, K, M, or G units that the
+// size is at least 1 of, e.g. "4.2M".
+func (sz Size) Human() string {
+	for _, u := range [...]struct {
+		unit   Size
+		suffix string
+	}{{G, "G"}, {M, "M"}, {K, "K"}} {
+		if sz >= u.unit {
+			v := float64(sz) / float64(u.unit)
+			if v < 10 {
+				return fmt.Sprintf("%.1f%s", v, u.suffix)
+			}
+			return fmt.Sprintf("%.0f%s", v, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(sz))
+}
+
 const (
 	// SmallFileCutoff is the file size limit under which ShareBase's
 	// recommended small file upload method is used and above which ShareBase's
-	// large file upload method is used.
+	// large file upload method is used.  It's the default; uploads can use
+	// a different cutoff with WithSmallFileCutoff.
 	SmallFileCutoff Size = 5 * M
 
 	// PatchSize is the size of patches made to a large file upload.
 	// ShareBase's recommendation is 512K and not to exceed 2M, so I'm going
 	// to go with the power of 2 between them.
 	PatchSize Size = 512 * K
+
+	// MaxPatchSize is the largest patch size ShareBase accepts for a
+	// large file upload.
+	MaxPatchSize Size = 2 * M
 )
 
+// DefaultContentType is the content type that documents are uploaded with
+// when no type is specified and it can't be detected from the document name.
+const DefaultContentType = "application/octet-stream"
+
 // LibraryLinks holds the URLs that a Library's Links attribute has.
 type LibraryLinks struct {
 	// Self is the library link.
@@ -76,16 +110,16 @@ func (lib *Library) Folders(c *Client) (folders []Folder, err error) {
 	return folders, err
 }
 
-// Folder gets a folder by ID from the given library.
+// Folder gets a folder by ID from the given library with its documents and
+// subfolders embedded.
 func (lib *Library) Folder(c *Client, id int) (folder Folder, err error) {
-	url := c.DataCenter
-	url.Path = path.Join(url.Path, foldersURL.Path)
-	uriString := fmt.Sprintf("%v/%d?embed=d,f", url.String(), id)
-	err = c.requestJSON(http.MethodGet, uriString, nil, &folder)
-	if _, ok := err.(NotFound); ok {
-		return Folder{}, NotFound{Kind: FolderKind, ID: id, Name: ""}
-	}
-	return folder, err
+	return getFolder(c, id, EmbedAll)
+}
+
+// FolderWithEmbed gets a folder by ID from the given library with only the
+// children selected by embed.
+func (lib *Library) FolderWithEmbed(c *Client, id int, embed Embed) (Folder, error) {
+	return getFolder(c, id, embed)
 }
 
 // FolderByName gets a folder within the library by its name.
@@ -102,6 +136,169 @@ func (lib *Library) FolderByName(c *Client, name string) (Folder, error) {
 	return Folder{}, NotFound{Kind: FolderKind, ID: 0, Name: name}
 }
 
+// MaxConcurrentFolderListings is the most folders that Library.WalkDocuments
+// lists at the same time.
+const MaxConcurrentFolderListings = 4
+
+// AllDocuments gets every document in every folder of the library.  See
+// WalkDocuments for how the folders are listed.  For large libraries, use
+// WalkDocuments instead so that all of the documents don't have to be held in
+// memory at once.
+func (lib *Library) AllDocuments(c *Client) (documents []Document, err error) {
+	err = lib.WalkDocuments(c, func(path []string, d Document) error {
+		documents = append(documents, d)
+		return nil
+	})
+	return documents, err
+}
+
+// WalkDocuments calls fn with every document in every folder of the library
+// and the names of the folders from the library down to the document's
+// folder.  fn must not modify or keep the path.
+//
+// The folders are listed with their embedded documents and subfolders by up
+// to MaxConcurrentFolderListings requests at a time, so the documents aren't
+// walked in any particular order, but fn is only called from one goroutine
+// at a time.  Documents and folders that are listed more than once are only
+// walked the first time.  The first error from listing a folder or from fn
+// stops the walk and is returned.
+func (lib *Library) WalkDocuments(c *Client, fn func(path []string, d Document) error) error {
+	top, err := lib.Folders(c)
+	if err != nil {
+		return err
+	}
+	type listing struct {
+		path   []string
+		folder Folder
+		err    error
+	}
+	var (
+		sem     = make(chan struct{}, MaxConcurrentFolderListings)
+		results = make(chan listing)
+		done    = make(chan struct{})
+		wg      sync.WaitGroup
+		pending int
+		folders = make(map[int]bool)
+		docs    = make(map[int]bool)
+	)
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	list := func(path []string, f Folder) {
+		if folders[f.FolderID] {
+			return
+		}
+		folders[f.FolderID] = true
+		pending++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			g, err := lib.Folder(c, f.FolderID)
+			<-sem
+			select {
+			case results <- listing{path, g, err}:
+			case <-done:
+			}
+		}()
+	}
+	for _, f := range top {
+		list([]string{f.FolderName}, f)
+	}
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.err != nil {
+			return errors.ErrorfWithCause(
+				r.err, "failed to list folder %v: %v",
+				joinFolderPath(r.path...), r.err)
+		}
+		for _, d := range r.folder.Embedded.Documents {
+			if docs[d.DocumentID] {
+				continue
+			}
+			docs[d.DocumentID] = true
+			if err = fn(r.path, d); err != nil {
+				return err
+			}
+		}
+		for _, sub := range r.folder.Embedded.Folders {
+			// the full slice expression makes append copy the
+			// path for each subfolder.
+			list(append(r.path[:len(r.path):len(r.path)], sub.FolderName), sub)
+		}
+	}
+	return nil
+}
+
+// Search finds the documents in the library that match the query.  The
+// returned documents' FolderIDs can be used to locate them.
+func (lib *Library) Search(c *Client, query string) ([]Document, error) {
+	return lib.SearchFields(c, query, nil)
+}
+
+// SearchFields is like Search but it also filters the documents by their
+// document index field values.  The fields map is keyed by field name.
+func (lib *Library) SearchFields(c *Client, query string, fields map[string]string) (documents []Document, err error) {
+	values := make(url.Values, len(fields)+1)
+	if query != "" {
+		values.Set("q", query)
+	}
+	for k, v := range fields {
+		values.Set("field."+k, v)
+	}
+	err = c.requestJSON(
+		http.MethodGet,
+		Concat(lib.Links.Self, "/search?", values.Encode()),
+		nil,
+		&documents)
+	return documents, err
+}
+
+// FieldDefinition describes one of a library's document index fields.
+type FieldDefinition struct {
+	// FieldName is the name that the field's values are keyed by.
+	FieldName string
+
+	// Required is set if documents can't be created in the library
+	// without a value for the field.
+	Required bool
+}
+
+// FieldDefinitions gets the definitions of the index fields of the library's
+// document type.  If ShareBase doesn't report any (e.g. the library doesn't
+// have a document type), nil is returned without an error.
+func (lib *Library) FieldDefinitions(c *Client) (defs []FieldDefinition, err error) {
+	err = c.requestJSON(
+		http.MethodGet, Concat(lib.Links.Self, "/fields"), nil, &defs)
+	if isUnsupported(err) {
+		logger.Debug2(
+			"%v doesn't report field definitions: %v",
+			lib.LibraryName, err)
+		return nil, nil
+	}
+	return defs, err
+}
+
+// CheckFields checks that fields has a non-empty value for every required
+// field in defs.  If it doesn't, a MissingFields error is returned.
+func CheckFields(defs []FieldDefinition, fields map[string]string) error {
+	var missing []string
+	for _, def := range defs {
+		if def.Required && fields[def.FieldName] == "" {
+			missing = append(missing, def.FieldName)
+		}
+	}
+	if len(missing) > 0 {
+		return MissingFields{Names: missing}
+	}
+	return nil
+}
+
 // NewFolderRequest is used by the NewFolder function to create a new folder.
 type NewFolderRequest struct {
 	// FolderPath holds the full path to the folder with the path components
@@ -115,13 +312,39 @@ func joinFolderPath(parts ...string) string {
 }
 
 // NewFolder creates a new folder within the library with the specified path.
+// If the folder already exists (e.g. because another client just created
+// it), the existing folder is returned instead of an error.
 func (lib *Library) NewFolder(c *Client, path ...string) (folder Folder, err error) {
 	err = c.requestJSON(http.MethodPost, lib.Links.Folders, NewFolderRequest{
 		FolderPath: joinFolderPath(path...),
 	}, &folder)
+	if _, ok := err.(Conflict); ok {
+		logger.Debug1(
+			"folder %v already exists; getting it instead",
+			joinFolderPath(path...))
+		return lib.FolderByPath(c, path...)
+	}
 	return folder, err
 }
 
+// FolderByPath gets the folder at the given path within the library by
+// getting each folder in the path by its name.
+func (lib *Library) FolderByPath(c *Client, path ...string) (Folder, error) {
+	if len(path) == 0 {
+		return Folder{}, errors.Errorf("folder path cannot be empty")
+	}
+	folder, err := lib.FolderByName(c, path[0])
+	if err != nil {
+		return Folder{}, err
+	}
+	for _, name := range path[1:] {
+		if folder, err = folder.FolderByName(c, name); err != nil {
+			return Folder{}, err
+		}
+	}
+	return folder, nil
+}
+
 // Folder represents a folder in the ShareBase API.
 type Folder struct {
 	// FolderID is the unique ID of the folder in ShareBase.
@@ -139,6 +362,10 @@ type Folder struct {
 
 	// Embedded holds nested objects that might be embedded in the folder.
 	Embedded FolderEmbedded
+
+	// docsSize and treeSize cache the results of Size without and with
+	// its subfolders.  They're nil until they're known.
+	docsSize, treeSize *int64
 }
 
 // FolderLinks are the links specific to a folder.
@@ -165,6 +392,288 @@ type FolderEmbedded struct {
 	Folders []Folder
 }
 
+// Embed selects which of a folder's children are embedded in it when it's
+// fetched by ID.  Callers that only need the subfolders can leave out the
+// documents, whose list can be much bigger.
+type Embed struct {
+	// Documents embeds the folder's documents.
+	Documents bool
+
+	// Folders embeds the folder's subfolders.
+	Folders bool
+}
+
+// EmbedAll embeds both the documents and the subfolders.  It's what
+// Library.Folder and Folder.Folder use.
+var EmbedAll = Embed{Documents: true, Folders: true}
+
+// Query gets the URL query string that requests e's children, for example
+// "embed=d,f".  It's empty if nothing is embedded.  The values are fixed
+// tokens, so the string never needs escaping.
+func (e Embed) Query() string {
+	vs := make([]string, 0, 2)
+	if e.Documents {
+		vs = append(vs, "d")
+	}
+	if e.Folders {
+		vs = append(vs, "f")
+	}
+	if len(vs) == 0 {
+		return ""
+	}
+	return "embed=" + strings.Join(vs, ",")
+}
+
+// Delete deletes the folder and everything in it from ShareBase.
+func (f *Folder) Delete(c *Client) error {
+	err := c.requestJSON(http.MethodDelete, f.selfLink(c), nil, nil)
+	if nf, ok := err.(NotFound); ok {
+		return nf.describe(FolderKind, f.FolderID, f.FolderName)
+	}
+	return err
+}
+
+// RenameFolderRequest is marshaled when asking ShareBase to rename a folder.
+type RenameFolderRequest struct {
+	// FolderName is the folder's new name.
+	FolderName string
+}
+
+// MoveFolderRequest is marshaled when asking ShareBase to move a folder.
+type MoveFolderRequest struct {
+	// LibraryID is the ID of the library that the folder is in.  Folders
+	// can only be moved within their library.
+	LibraryID int `json:"LibraryId"`
+
+	// ParentFolderID is the ID of the folder that the folder is moved
+	// into, or 0 to move it to the top of its library.
+	ParentFolderID int `json:"ParentFolderId"`
+}
+
+// Rename renames the folder in ShareBase and then in f.  If its parent
+// already has a folder or document with the new name, AlreadyExists is
+// returned.
+func (f *Folder) Rename(c *Client, name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	err := c.requestJSON(
+		http.MethodPut, f.selfLink(c), RenameFolderRequest{FolderName: name}, nil)
+	if err = f.updateError(err, name); err != nil {
+		return err
+	}
+	logger.Debug2("renamed folder %q to %q", f.FolderName, name)
+	f.FolderName = name
+	return nil
+}
+
+// MoveToFolder moves the folder into dst, which must be in the same library.
+// If dst already has a folder or document with f's name, AlreadyExists is
+// returned.
+func (f *Folder) MoveToFolder(c *Client, dst *Folder) error {
+	if dst.FolderID == f.FolderID {
+		return errors.Errorf(
+			"cannot move folder %q into itself", f.FolderName)
+	}
+	return f.move(c, dst.LibraryID, dst.FolderID)
+}
+
+// MoveToLibrary moves the folder to the top of dst, which must be the
+// library it's already in.  If dst already has a folder with f's name,
+// AlreadyExists is returned.
+func (f *Folder) MoveToLibrary(c *Client, dst *Library) error {
+	return f.move(c, dst.LibraryID, 0)
+}
+
+func (f *Folder) move(c *Client, libraryID, parentID int) error {
+	if libraryID != f.LibraryID {
+		return errors.Errorf(
+			"cannot move folder %q from library %d to library %d: "+
+				"folders can only be moved within their library",
+			f.FolderName, f.LibraryID, libraryID)
+	}
+	err := c.requestJSON(
+		http.MethodPost,
+		Concat(f.selfLink(c), "/move"),
+		MoveFolderRequest{LibraryID: libraryID, ParentFolderID: parentID},
+		nil)
+	if err = f.updateError(err, f.FolderName); err != nil {
+		return err
+	}
+	logger.Debug2("moved folder %q into folder %d", f.FolderName, parentID)
+	return nil
+}
+
+// selfLink gets the folder's Self link.  Folders listed from their parents
+// don't always have links, so it falls back to the folder's location by its
+// ID.
+func (f *Folder) selfLink(c *Client) string {
+	if f.Links.Self != "" {
+		return f.Links.Self
+	}
+	u := c.DataCenter
+	u.Path = path.Join(u.Path, foldersURL.Path, strconv.Itoa(f.FolderID))
+	return u.String()
+}
+
+// updateError turns the errors from renaming or moving the folder into
+// NotFound and AlreadyExists errors with the folder's name.
+func (f *Folder) updateError(err error, name string) error {
+	switch err := err.(type) {
+	case NotFound:
+		return err.describe(FolderKind, f.FolderID, f.FolderName)
+	case Conflict:
+		return AlreadyExists{Kind: FolderKind, Name: name}
+	}
+	return err
+}
+
+// MaxFolderSizeRequests is the most requests that Folder.Size makes to size
+// a folder and its subfolders.
+const MaxFolderSizeRequests = 1000
+
+// Size gets the total size of the documents in the folder from the Size of
+// each document in the folder's Documents listing.  If recursive is true,
+// the documents in every subfolder are included, too.  Subfolders are listed
+// breadth-first, with two requests per folder, until there are no more or
+// MaxFolderSizeRequests have been made.
+//
+// If the folder itself can't be listed, its error is returned.  If only some
+// of its subfolders can't be (or there are too many to list), the size of
+// everything else is returned with an IncompleteSize error.
+//
+// Complete results are cached in f, so sizing the same Folder again doesn't
+// make any requests.  Getting the folder from ShareBase again (e.g. with
+// Library.Folder) gets a Folder without the cached sizes.  Size must not be
+// called on the same Folder from multiple goroutines.
+func (f *Folder) Size(c *Client, recursive bool) (int64, error) {
+	if !recursive && f.docsSize != nil {
+		return *f.docsSize, nil
+	}
+	if recursive && f.treeSize != nil {
+		return *f.treeSize, nil
+	}
+	var (
+		total    int64
+		requests int
+		inc      = IncompleteSize{Name: f.FolderName}
+	)
+	pending := []Folder{*f}
+	for i := 0; i < len(pending); i++ {
+		if i > 0 && requests+2 > MaxFolderSizeRequests {
+			inc.Skipped = len(pending) - i
+			break
+		}
+		g := pending[i]
+		size, subs, err := g.listSize(c, recursive)
+		requests += 2
+		if err != nil {
+			if i == 0 {
+				return 0, err
+			}
+			inc.Errs = append(inc.Errs, errors.ErrorfWithCause(
+				err, "failed to list folder %q: %v",
+				g.FolderName, err))
+			continue
+		}
+		if i == 0 {
+			f.docsSize = &size
+		}
+		total += size
+		pending = append(pending, subs...)
+	}
+	if len(inc.Errs) > 0 || inc.Skipped > 0 {
+		inc.Size = total
+		return total, inc
+	}
+	if recursive {
+		f.treeSize = &total
+	}
+	return total, nil
+}
+
+// listSize gets the size of f's own documents and, if subfolders is true,
+// f's subfolders.
+func (f *Folder) listSize(c *Client, subfolders bool) (size int64, folders []Folder, err error) {
+	docs, err := f.Documents(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, d := range docs {
+		size += d.Size
+	}
+	if !subfolders {
+		return size, nil, nil
+	}
+	if folders, err = f.Folders(c); err != nil {
+		return 0, nil, err
+	}
+	return size, folders, nil
+}
+
+// Shares gets the shares that have been created for the folder.
+func (f *Folder) Shares(c *Client) (shares []Share, err error) {
+	err = c.requestJSON(http.MethodGet, f.sharesLink(), nil, &shares)
+	return
+}
+
+// CreateShare creates a new share link to the folder.
+func (f *Folder) CreateShare(c *Client, opts ShareOptions) (share Share, err error) {
+	err = c.requestJSON(http.MethodPost, f.sharesLink(), opts, &share)
+	return
+}
+
+// sharesLink gets the link to the folder's shares.
+func (f *Folder) sharesLink() string {
+	if f.Links.Shares != "" {
+		return f.Links.Shares
+	}
+	return Concat(f.Links.Self, "/shares")
+}
+
+// SharePermission determines what someone with a share link is allowed to
+// do with the shared folder or document.
+type SharePermission string
+
+const (
+	// ShareView only allows viewing shared content.
+	ShareView SharePermission = "View"
+
+	// ShareDownload allows viewing and downloading shared content.
+	ShareDownload SharePermission = "Download"
+
+	// ShareUpload allows uploading documents into a shared folder.
+	ShareUpload SharePermission = "Upload"
+)
+
+// ShareOptions is marshaled when creating a new share.
+type ShareOptions struct {
+	// ExpirationDate is when the share stops working.  If it's nil, the
+	// share never expires.
+	ExpirationDate *time.Time `json:",omitempty"`
+
+	// Permission is what the share allows.  ShareBase's default is used
+	// when it is empty.
+	Permission SharePermission `json:",omitempty"`
+}
+
+// Share is a public link to a ShareBase folder or document.
+type Share struct {
+	// ShareID is the unique ID of the share in ShareBase.
+	ShareID int `json:"ShareId"`
+
+	// URL is the public link that the folder or document is shared
+	// through.
+	URL string `json:"Url"`
+
+	// ExpirationDate is when the share stops working.  It's the zero
+	// time if the share doesn't expire.
+	ExpirationDate time.Time
+
+	// Permission is what the share allows.
+	Permission SharePermission
+}
+
 // Document attempts to retrieve a document from the folder by its ID.
 func (f *Folder) Document(c *Client, id int) (Document, error) {
 	docs, err := f.Documents(c)
@@ -206,16 +715,29 @@ func (f *Folder) Folders(c *Client) (folders []Folder, err error) {
 	return
 }
 
-// Folder gets a single folder within the current folder by its ID.
+// Folder gets a single folder within the current folder by its ID with its
+// documents and subfolders embedded.
 func (f *Folder) Folder(c *Client, id int) (folder Folder, err error) {
-	url := c.DataCenter
-	url.Path = path.Join(url.Path, foldersURL.Path)
-	uriString := fmt.Sprintf("%v/%d?embed=d,f", url.String(), id)
-	err = c.requestJSON(http.MethodGet, uriString, nil, &folder)
-	if _, ok := err.(NotFound); ok {
-		return Folder{}, NotFound{Kind: FolderKind, ID: id, Name: ""}
+	return getFolder(c, id, EmbedAll)
+}
+
+// FolderWithEmbed gets a single folder within the current folder by its ID
+// with only the children selected by embed.
+func (f *Folder) FolderWithEmbed(c *Client, id int, embed Embed) (Folder, error) {
+	return getFolder(c, id, embed)
+}
+
+// getFolder gets a folder by its ID, embedding the children selected by
+// embed.
+func getFolder(c *Client, id int, embed Embed) (folder Folder, err error) {
+	u := c.DataCenter
+	u.Path = path.Join(u.Path, foldersURL.Path, strconv.Itoa(id))
+	u.RawQuery = embed.Query()
+	err = c.requestJSON(http.MethodGet, u.String(), nil, &folder)
+	if nf, ok := err.(NotFound); ok {
+		return Folder{}, nf.describe(FolderKind, id, "")
 	}
-	return
+	return folder, err
 }
 
 // FolderByName gets a child folder from the current folder by its name.
@@ -232,44 +754,364 @@ func (f *Folder) FolderByName(c *Client, name string) (Folder, error) {
 	return Folder{}, NotFound{Kind: FolderKind, ID: 0, Name: name}
 }
 
-// NewDocument creates a new ShareBase document in the given folder.
-func (f *Folder) NewDocument(c *Client, name string, content io.Reader) error {
-	if lengther, ok := content.(Lener); ok {
-		if Size(lengther.Len()) < SmallFileCutoff {
-			return f.newSmallDocument(c, name, content)
+// ProgressFunc is called during a transfer with the number of bytes
+// transferred so far and the total number of bytes to transfer.  totalBytes
+// is -1 when the total isn't known (e.g. when streaming).
+type ProgressFunc func(bytesSent, totalBytes int64)
+
+// call calls the progress function if it isn't nil.
+func (f ProgressFunc) call(bytesSent, totalBytes int64) {
+	if f != nil {
+		f(bytesSent, totalBytes)
+	}
+}
+
+// DocumentOption customizes how a document is uploaded by Folder.NewDocument
+// or Folder.DocumentWriter.
+type DocumentOption func(o *documentOptions) error
+
+// documentOptions holds the configuration that DocumentOptions modify.
+type documentOptions struct {
+	progress  ProgressFunc
+	patchSize Size
+
+	// smallFileCutoff is the size under which content is uploaded with
+	// the small file upload method.
+	smallFileCutoff Size
+
+	// sum is non-nil when the uploaded content should be verified
+	// against the hash ShareBase reports.
+	sum *[]byte
+
+	// contentType is the MIME type of the uploaded content.  When it's
+	// empty, the type is detected from the document name's extension.
+	contentType string
+
+	// ctx cancels the upload when it's done.  It's nil unless
+	// WithContext is used.
+	ctx context.Context
+
+	// fields are the index field values from WithFields.
+	fields map[string]string
+}
+
+// context gets the context that cancels the upload.
+func (o documentOptions) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// contentTypeOf gets the MIME type to upload a document with the given name
+// as.
+func (o documentOptions) contentTypeOf(name string) string {
+	if o.contentType != "" {
+		return o.contentType
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return DefaultContentType
+}
+
+// makeDocumentOptions applies the given options to the default document
+// options.
+func makeDocumentOptions(options []DocumentOption) (documentOptions, error) {
+	o := documentOptions{
+		patchSize:       PatchSize,
+		smallFileCutoff: SmallFileCutoff,
+	}
+	for _, opt := range options {
+		if err := opt(&o); err != nil {
+			return documentOptions{}, err
 		}
 	}
-	return f.newLargeDocument(c, name, content)
+	return o, nil
+}
+
+// WithProgress configures a callback that is called after every chunk of the
+// document is uploaded.
+func WithProgress(f ProgressFunc) DocumentOption {
+	return func(o *documentOptions) error {
+		o.progress = f
+		return nil
+	}
+}
+
+// WithContext makes the upload stop when ctx is done, even in the middle of a
+// patch.  Large uploads that are canceled have their temporary files deleted
+// from ShareBase and return an UploadCanceled error.
+func WithContext(ctx context.Context) DocumentOption {
+	return func(o *documentOptions) error {
+		o.ctx = ctx
+		return nil
+	}
+}
+
+// WithPatchSize configures the size of the patches used to upload a large
+// document.  The size must be between 1 byte and MaxPatchSize.
+func WithPatchSize(size Size) DocumentOption {
+	return func(o *documentOptions) error {
+		if err := validatePatchSize(size); err != nil {
+			return err
+		}
+		o.patchSize = size
+		return nil
+	}
+}
+
+// WithSmallFileCutoff configures the size under which NewDocument and
+// NewDocumentWithSize use ShareBase's small file upload method instead of
+// SmallFileCutoff.  The size must be positive.
+func WithSmallFileCutoff(size Size) DocumentOption {
+	return func(o *documentOptions) error {
+		if size < 1 {
+			return errors.Errorf(
+				"small file cutoff must be positive, not %d", size)
+		}
+		o.smallFileCutoff = size
+		return nil
+	}
+}
+
+// validatePatchSize checks that a patch size is within the range accepted
+// by ShareBase.
+func validatePatchSize(size Size) error {
+	if size < 1 || size > MaxPatchSize {
+		return errors.Errorf(
+			"patch size must be between 1 and %d bytes, not %d",
+			MaxPatchSize, size)
+	}
+	return nil
+}
+
+// WithContentType sets the MIME type that a document's content is uploaded
+// as.  Without this option, the type is detected from the extension of the
+// document name and defaults to DefaultContentType.
+func WithContentType(contentType string) DocumentOption {
+	return func(o *documentOptions) error {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return errors.ErrorfWithCause(
+				err, "invalid content type %q: %v",
+				contentType, err)
+		}
+		o.contentType = contentType
+		return nil
+	}
+}
+
+// WithIntegrityCheck computes the SHA-1 hash of the content as it is uploaded
+// and stores it into sum.  If ShareBase reports the hash of the document it
+// created, the hashes are compared and an IntegrityError is returned if they
+// don't match.  The computed hash is stored into sum even if ShareBase doesn't
+// report a hash.
+func WithIntegrityCheck(sum *[]byte) DocumentOption {
+	return func(o *documentOptions) error {
+		if sum == nil {
+			return errors.Errorf("integrity check sum cannot be nil")
+		}
+		o.sum = sum
+		return nil
+	}
+}
+
+// WithFields sets the index field values, keyed by field name, that the
+// document is created with.  Libraries with document types can require some
+// fields to be set; see Library.FieldDefinitions and CheckFields.
+func WithFields(fields map[string]string) DocumentOption {
+	return func(o *documentOptions) error {
+		o.fields = make(map[string]string, len(fields))
+		for k, v := range fields {
+			if k == "" {
+				return errors.Errorf("field names cannot be empty")
+			}
+			o.fields[k] = v
+		}
+		return nil
+	}
+}
+
+// NewDocument creates a new ShareBase document in the given folder and returns
+// it.  If content implements Lener64 or Lener, its length is used to pick
+// ShareBase's small or large file upload method.  Otherwise, the large method
+// is used.  If name can't be a ShareBase document name, InvalidName is
+// returned before anything is uploaded; CleanName can fix it.
+func (f *Folder) NewDocument(c *Client, name string, content io.Reader, options ...DocumentOption) (Document, error) {
+	return f.NewDocumentWithSize(
+		c, name, content, contentLength(content), options...)
+}
+
+// NewDocumentWithSize is like NewDocument but for when the size of content
+// is already known, like when uploading a local file.  If size is less than
+// 0, it's unknown and the content is streamed with the large file upload
+// method.
+func (f *Folder) NewDocumentWithSize(c *Client, name string, content io.Reader, size int64, options ...DocumentOption) (Document, error) {
+	if err := ValidateName(name); err != nil {
+		return Document{}, err
+	}
+	o, err := makeDocumentOptions(options)
+	if err != nil {
+		return Document{}, err
+	}
+	length := size
+	var h hash.Hash
+	if o.sum != nil {
+		h = sha1.New()
+		content = io.TeeReader(content, h)
+	}
+	var d Document
+	if IsSmallDocumentWithCutoff(length, o.smallFileCutoff) {
+		d, err = f.newSmallDocument(c, name, content, length, o)
+	} else {
+		d, err = f.newLargeDocument(c, name, content, length, o)
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	if h != nil {
+		*o.sum = h.Sum(nil)
+		if len(d.Hash) > 0 && !bytes.Equal(d.Hash, *o.sum) {
+			return d, IntegrityError{
+				Name:     name,
+				Expected: *o.sum,
+				Actual:   d.Hash,
+			}
+		}
+	}
+	return d, nil
+}
+
+// IsSmallDocument checks if NewDocumentWithSize would upload content of the
+// given size with ShareBase's small file upload method instead of its large
+// file upload method.
+func IsSmallDocument(size int64) bool {
+	return IsSmallDocumentWithCutoff(size, SmallFileCutoff)
+}
+
+// IsSmallDocumentWithCutoff is like IsSmallDocument but for uploads made
+// with WithSmallFileCutoff(cutoff).
+func IsSmallDocumentWithCutoff(size int64, cutoff Size) bool {
+	return size >= 0 && Size(size) < cutoff
+}
+
+// contentLength gets the length of content if it implements Lener64 or Lener
+// or -1 if its length isn't known.  Lener64 is checked first because an int
+// can overflow on 32-bit platforms.
+func contentLength(content io.Reader) int64 {
+	if lengther, ok := content.(Lener64); ok {
+		return lengther.Len64()
+	}
+	if lengther, ok := content.(Lener); ok {
+		return int64(lengther.Len())
+	}
+	return -1
 }
 
 // NewDocumentRequest is marshaled when creating a new document.
 type NewDocumentRequest struct {
 	// DocumentName is the name of the document to be created in a folder.
 	DocumentName string
+
+	// ContentType is the MIME type of the document's content.
+	ContentType string `json:",omitempty"`
+
+	// Fields holds the document's index field values by field name.
+	Fields map[string]string `json:",omitempty"`
+}
+
+// smallDocumentBody creates the multipart body of a small document upload
+// with the metadata and file parts.  Only the parts' headers are built up
+// front; the content is read as the body is sent so that it isn't copied into
+// memory first.  The file part has its own Content-Type header so that
+// ShareBase doesn't have to guess what it is.
+//
+// If length is negative, the content is buffered to get its length because
+// ShareBase requires the body's length.
+func smallDocumentBody(req NewDocumentRequest, content io.Reader, length int64) (body io.Reader, bodyLength int64, formDataContentType string, err error) {
+	if length < 0 {
+		buffer := new(bytes.Buffer)
+		if _, err = io.Copy(buffer, content); err != nil {
+			return nil, 0, "", errors.ErrorfWithCause(
+				err, "failed to buffer %q content: %v",
+				req.DocumentName, err)
+		}
+		content, length = buffer, int64(buffer.Len())
+	}
+	head := new(bytes.Buffer)
+	mw := multipart.NewWriter(head)
+	header := make(textproto.MIMEHeader, 2)
+	header.Set("Content-Disposition", mime.FormatMediaType(
+		"form-data", map[string]string{"name": "metadata"}))
+	header.Set("Content-Type", "application/json")
+	pw, err := mw.CreatePart(header)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if err = json.NewEncoder(pw).Encode(req); err != nil {
+		return nil, 0, "", errors.ErrorfWithCause(
+			err, "failed to marshal %#v to JSON: %v", req, err)
+	}
+	header = make(textproto.MIMEHeader, 2)
+	header.Set("Content-Disposition", mime.FormatMediaType(
+		"form-data", map[string]string{
+			"name":     "file",
+			"filename": req.DocumentName,
+		}))
+	header.Set("Content-Type", req.ContentType)
+	// CreatePart only writes the part's header; its content goes between
+	// head and tail.
+	if _, err = mw.CreatePart(header); err != nil {
+		return nil, 0, "", err
+	}
+	// the closing boundary is written by another Writer with the same
+	// boundary so that it's not written until after the content.
+	tail := new(bytes.Buffer)
+	mw2 := multipart.NewWriter(tail)
+	if err = mw2.SetBoundary(mw.Boundary()); err != nil {
+		return nil, 0, "", err
+	}
+	if err = mw2.Close(); err != nil {
+		return nil, 0, "", err
+	}
+	bodyLength = int64(head.Len()) + length + int64(tail.Len())
+	body = io.MultiReader(head, io.LimitReader(content, length), tail)
+	return body, bodyLength, mw.FormDataContentType(), nil
 }
 
-func (f *Folder) newSmallDocument(c *Client, name string, content io.Reader) error {
-	body := bytes.Buffer{}
-	formDataContentType, err := mparthelp.Parts{
-		mparthelp.Part{
-			Name:   "metadata",
-			Source: mparthelp.JSON{Value: NewDocumentRequest{DocumentName: name}},
-		},
-		mparthelp.Part{
-			Name:   "file",
-			Source: mparthelp.File{Name: name, Reader: content, Closer: nil},
+func (f *Folder) newSmallDocument(c *Client, name string, content io.Reader, length int64, o documentOptions) (d Document, err error) {
+	body, bodyLength, formDataContentType, err := smallDocumentBody(
+		NewDocumentRequest{
+			DocumentName: name,
+			ContentType:  o.contentTypeOf(name),
+			Fields:       o.fields,
 		},
-	}.Into(&body)
+		content, length)
 	if err != nil {
-		return err
+		return Document{}, err
 	}
+	jsonBuffer := getBuffer()
+	defer putBuffer(jsonBuffer)
 	err = c.request(
 		http.MethodPost,
 		f.Links.Documents,
-		&body,
-		nil,
-		setContentType(formDataContentType))
-	return err
+		body,
+		jsonBuffer,
+		setContentType(formDataContentType),
+		setContentLength(bodyLength),
+		withContext(o.context()))
+	if err != nil {
+		return Document{}, err
+	}
+	o.progress.call(length, length)
+	if jsonBuffer.Len() > 0 {
+		if err = json.Unmarshal(jsonBuffer.Bytes(), &d); err != nil {
+			return Document{}, errors.ErrorfWithCause(
+				err, "failed to unmarshal new document %q: %v",
+				name, err)
+		}
+	}
+	return d, nil
 }
 
 // NewLargeDocumentResponse is a JSON response returned when creating a large
@@ -288,53 +1130,96 @@ type NewLargeDocumentResponseLinks struct {
 	Location string
 }
 
-// newLargeDocument uploads a large document.
-func (f *Folder) newLargeDocument(c *Client, name string, content io.Reader) (err error) {
+// newLargeDocument uploads a large document.  length is the total length of
+// the content if it's known or -1 if it isn't.
+func (f *Folder) newLargeDocument(c *Client, name string, content io.Reader, length int64, o documentOptions) (d Document, err error) {
 	// deleted everything 2018-11-25 14:16
-	res, err := f.createNewLargeDocument(c, name)
+	ctx := o.context()
+	res, err := f.createNewLargeDocument(c, name, o.contentTypeOf(name))
 	if err != nil {
-		return errors.ErrorfWithCause(
+		return Document{}, errors.ErrorfWithCause(
 			err, "failed to create new document request: %v", err)
 	}
-	dataBuffer := new(bytes.Buffer)
-	dataBuffer.Grow(int(PatchSize))
-	jsonBuffer := new(bytes.Buffer)
+	defer abortLargeDocumentOnError(c, res, &err)
+	dataBuffer := getBuffer()
+	defer putBuffer(dataBuffer)
+	dataBuffer.Grow(int(o.patchSize))
+	jsonBuffer := getBuffer()
+	defer putBuffer(jsonBuffer)
 	cur := res
 	// It'd be nice if this could be stack-allocated, but I think all values
 	// passed as interfaces always escape to the heap:
 	dataReader := &io.LimitedReader{R: content, N: 0}
 	total := int64(0)
 	for {
-		dataReader.N = int64(PatchSize)
+		if err = ctx.Err(); err != nil {
+			return Document{}, UploadCanceled{Name: name, Sent: total, Err: err}
+		}
+		dataReader.N = int64(o.patchSize)
 		// copying to a buffer instead of just passing the LimitedReader to
 		// the request so that the content length can be known before reading
 		// the body.  ShareBase requires the content length be specified or
 		// else you end up with a 0 byte file in ShareBase.
 		w, err := io.Copy(dataBuffer, dataReader)
 		if err != nil {
-			return errors.ErrorfWithCause(
+			return Document{}, errors.ErrorfWithCause(
 				err, "failure buffering data for patch: %v", err)
 		}
 		if w == 0 {
 			break
 		}
-		if err = c.request(http.MethodPatch, res.Links.Location, dataBuffer, jsonBuffer); err != nil {
-			return errors.ErrorfWithCause(
+		if err = c.request(http.MethodPatch, res.Links.Location, dataBuffer, jsonBuffer, withContext(ctx)); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				// the patch was interrupted, so it's not counted.
+				return Document{}, UploadCanceled{Name: name, Sent: total, Err: ctxErr}
+			}
+			return Document{}, errors.ErrorfWithCause(
 				err, "failed to patch document %q: %v", name, err)
 		}
 		if err = json.Unmarshal(jsonBuffer.Bytes(), &cur); err != nil {
-			return errors.ErrorfWithCause(
+			return Document{}, errors.ErrorfWithCause(
 				err, "failed to unmarshal updated upload info: %v", err)
 		}
 		if cur.CurrentSize == 0 {
-			return errors.Errorf(
+			return Document{}, errors.Errorf(
 				"Last patch of document %q uploaded nothing.", name)
 		}
 		jsonBuffer.Reset()
 		total += w
+		o.progress.call(total, length)
 	}
-	var d Document
-	err = c.requestJSON(http.MethodPost, f.Links.Documents, nil, &d, func(req *http.Request) error {
+	return f.finishLargeDocument(c, res, o.fields)
+}
+
+// abortLargeDocument deletes the temporary file of a large document upload.
+// Temporary files that are already gone aren't an error.
+func abortLargeDocument(c *Client, res NewLargeDocumentResponse) error {
+	err := c.request(http.MethodDelete, res.Links.Location, nil, nil)
+	if _, ok := err.(NotFound); ok {
+		return nil
+	}
+	return err
+}
+
+// abortLargeDocumentOnError is deferred by large uploads to delete their
+// temporary files if they fail.  Failures to delete them are only logged so
+// that the upload's error is returned.
+func abortLargeDocumentOnError(c *Client, res NewLargeDocumentResponse, err *error) {
+	if *err == nil {
+		return
+	}
+	if err2 := abortLargeDocument(c, res); err2 != nil {
+		logger.Warn(
+			"failed to delete temporary file of failed upload %q: %v",
+			res.FileName, err2)
+	}
+}
+
+// finishLargeDocument turns the temporary file of a large document upload
+// into an actual document in the folder.  Its index fields, if there are
+// any, are sent in the body of the request.
+func (f *Folder) finishLargeDocument(c *Client, res NewLargeDocumentResponse, fields map[string]string) (d Document, err error) {
+	err = c.requestJSON(http.MethodPost, f.Links.Documents, largeDocumentFields(res, fields), &d, func(req *http.Request) error {
 		b, err := json.Marshal(res)
 		if err != nil {
 			return err
@@ -346,21 +1231,160 @@ func (f *Folder) newLargeDocument(c *Client, name string, content io.Reader) (er
 	return
 }
 
+// NewDocumentFromReaderAt creates a new ShareBase document in the folder
+// from size bytes of content.  Unlike NewDocument, the content is uploaded
+// with up to workers patches in flight at the same time, each one addressed
+// to its offset within the document with a Content-Range header.  This is
+// much faster than NewDocument's sequential patches over high-latency
+// connections, but it requires an io.ReaderAt (such as an *os.File) so that
+// chunks can be read out of order.
+func (f *Folder) NewDocumentFromReaderAt(c *Client, name string, content io.ReaderAt, size int64, workers int, options ...DocumentOption) (d Document, err error) {
+	if err = ValidateName(name); err != nil {
+		return Document{}, err
+	}
+	o, err := makeDocumentOptions(options)
+	if err != nil {
+		return Document{}, err
+	}
+	if size < 0 {
+		return Document{}, errors.Errorf(
+			"size must be non-negative, not %d", size)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ctx := o.context()
+	res, err := f.createNewLargeDocument(c, name, o.contentTypeOf(name))
+	if err != nil {
+		return Document{}, errors.ErrorfWithCause(
+			err, "failed to create new document request: %v", err)
+	}
+	defer abortLargeDocumentOnError(c, res, &err)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		done     = make(chan struct{})
+		offsets  = make(chan int64)
+
+		// mutex protects sent and serializes calls to the progress
+		// function.
+		mutex sync.Mutex
+		sent  int64
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, int(o.patchSize))
+			for offset := range offsets {
+				n, err := patchAt(ctx, c, res, content, buf, offset, size)
+				if err != nil {
+					fail(err)
+					return
+				}
+				mutex.Lock()
+				sent += n
+				o.progress.call(sent, size)
+				mutex.Unlock()
+			}
+		}()
+	}
+feed:
+	for offset := int64(0); offset < size; offset += int64(o.patchSize) {
+		select {
+		case offsets <- offset:
+		case <-done:
+			break feed
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break feed
+		}
+	}
+	close(offsets)
+	wg.Wait()
+	if ctxErr := ctx.Err(); firstErr != nil && ctxErr != nil {
+		return Document{}, UploadCanceled{Name: name, Sent: sent, Err: ctxErr}
+	}
+	if firstErr != nil {
+		return Document{}, errors.ErrorfWithCause(
+			firstErr, "failed to patch document %q: %v", name, firstErr)
+	}
+	res.CurrentSize = uint64(size)
+	return f.finishLargeDocument(c, res, o.fields)
+}
+
+// patchAt uploads the chunk of content starting at offset into the large
+// document upload described by res.  buf must be the size of a single patch.
+func patchAt(ctx context.Context, c *Client, res NewLargeDocumentResponse, content io.ReaderAt, buf []byte, offset, size int64) (int64, error) {
+	length := int64(len(buf))
+	if remaining := size - offset; remaining < length {
+		length = remaining
+	}
+	chunk := buf[:length]
+	if n, err := content.ReadAt(chunk, offset); err != nil && !(err == io.EOF && int64(n) == length) {
+		return 0, errors.ErrorfWithCause(
+			err, "failed to read %d bytes at offset %d: %v",
+			length, offset, err)
+	}
+	jsonBuffer := getBuffer()
+	defer putBuffer(jsonBuffer)
+	err := c.request(
+		http.MethodPatch,
+		res.Links.Location,
+		bytes.NewReader(chunk),
+		jsonBuffer,
+		setHeader("Content-Range", fmt.Sprintf(
+			"bytes %d-%d/%d", offset, offset+length-1, size)),
+		withContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	var cur NewLargeDocumentResponse
+	if err = json.Unmarshal(jsonBuffer.Bytes(), &cur); err != nil {
+		return 0, errors.ErrorfWithCause(
+			err, "failed to unmarshal updated upload info: %v", err)
+	}
+	if cur.CurrentSize == 0 {
+		return 0, errors.Errorf(
+			"patch at offset %d of document %q uploaded nothing.",
+			offset, res.FileName)
+	}
+	return length, nil
+}
+
 // DocumentWriter creates a new document writer with the given document name
 // under the current folder.  The DocumentWriter must be closed after writing!
-func (f *Folder) DocumentWriter(c *Client, name string) (w *DocumentWriter, err error) {
-	res, err := f.createNewLargeDocument(c, name)
+func (f *Folder) DocumentWriter(c *Client, name string, options ...DocumentOption) (w *DocumentWriter, err error) {
+	if err = ValidateName(name); err != nil {
+		return nil, err
+	}
+	o, err := makeDocumentOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f.createNewLargeDocument(c, name, o.contentTypeOf(name))
 	if err != nil {
 		return nil, err
 	}
 	w = &DocumentWriter{
-		Client: c,
-		Folder: f,
+		Client:                   c,
+		Folder:                   f,
 		NewLargeDocumentResponse: res,
-		dataBuffer:               bytes.Buffer{},
-		jsonBuffer:               bytes.Buffer{},
+		Progress:                 o.progress,
+		PatchSize:                o.patchSize,
+		ctx:                      o.context(),
+		fields:                   o.fields,
+		dataBuffer:               getBuffer(),
+		jsonBuffer:               getBuffer(),
 	}
-	w.dataBuffer.Grow(int(PatchSize))
+	w.dataBuffer.Grow(int(w.PatchSize))
 	return
 }
 
@@ -371,21 +1395,67 @@ type DocumentWriter struct {
 	*Client
 	*Folder
 	NewLargeDocumentResponse
-	dataBuffer bytes.Buffer
-	jsonBuffer bytes.Buffer
+
+	// Progress is an optional callback called after every patch is
+	// uploaded.  The total size of a document written through a
+	// DocumentWriter isn't known, so totalBytes is always -1.
+	Progress ProgressFunc
+
+	// PatchSize is the size of the patches uploaded by the writer.  It
+	// defaults to the PatchSize constant and must not be changed after
+	// the first Write.
+	PatchSize Size
+
+	// ctx is the context from WithContext.  When it's done, Write and
+	// Close abort the upload.
+	ctx context.Context
+
+	// fields are the index field values from WithFields.
+	fields map[string]string
+
+	// dataBuffer and jsonBuffer come from bufferPool and are put back
+	// (and set to nil) when the writer is finished.
+	dataBuffer *bytes.Buffer
+	jsonBuffer *bytes.Buffer
+
+	// finished is set after a successful Close or an Abort.
+	finished bool
+
+	// sent is the number of bytes successfully patched so far.
+	sent int64
+
+	// written is the number of bytes written so far, including the ones
+	// that are still buffered.
+	written int64
 }
 
-// Close finalizes the document upload.
+// Close finalizes the document upload.  If the size that ShareBase reports
+// receiving isn't the number of bytes written, a SizeMismatch error is
+// returned without finalizing the document.  If Close fails, the upload can
+// still be aborted.
 func (w *DocumentWriter) Close() error {
+	if w.finished {
+		return errors.Errorf("%T is already closed", w)
+	}
+	if err := w.checkContext(); err != nil {
+		return err
+	}
 	if w.dataBuffer.Len() > 0 {
 		if err := w.patch(); err != nil {
 			return err
 		}
 	}
-	return w.Client.requestJSON(
+	if actual := int64(w.NewLargeDocumentResponse.CurrentSize); actual != w.written {
+		return SizeMismatch{
+			Name:     w.NewLargeDocumentResponse.FileName,
+			Expected: w.written,
+			Actual:   actual,
+		}
+	}
+	err := w.Client.requestJSON(
 		http.MethodPost,
 		w.Folder.Links.Documents,
-		nil,
+		largeDocumentFields(w.NewLargeDocumentResponse, w.fields),
 		nil,
 		func(req *http.Request) error {
 			b, err := json.Marshal(w.NewLargeDocumentResponse)
@@ -394,26 +1464,87 @@ func (w *DocumentWriter) Close() error {
 			}
 			req.Header["x-sharebase-fileref"] = []string{string(b)}
 			return nil
-		})
+		},
+		withContext(w.ctx))
+	if err != nil {
+		return err
+	}
+	w.finish()
+	return nil
+}
+
+// Size gets the number of bytes written so far.
+func (w *DocumentWriter) Size() int64 { return w.written }
+
+// Abort stops the upload and deletes its temporary file from ShareBase.
+// Aborting a writer that was already aborted or closed does nothing.
+func (w *DocumentWriter) Abort() error {
+	if w.finished {
+		return nil
+	}
+	w.finish()
+	return abortLargeDocument(w.Client, w.NewLargeDocumentResponse)
+}
+
+// checkContext aborts the upload if the writer's context is done and returns
+// an UploadCanceled error.
+func (w *DocumentWriter) checkContext() error {
+	err := w.ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if err2 := w.Abort(); err2 != nil {
+		logger.Warn(
+			"failed to delete temporary file of canceled upload %q: %v",
+			w.NewLargeDocumentResponse.FileName, err2)
+	}
+	return UploadCanceled{
+		Name: w.NewLargeDocumentResponse.FileName,
+		Sent: w.sent,
+		Err:  err,
+	}
+}
+
+// finish marks the writer as finished and puts its buffers back into
+// bufferPool.
+func (w *DocumentWriter) finish() {
+	w.finished = true
+	putBuffer(w.dataBuffer)
+	putBuffer(w.jsonBuffer)
+	w.dataBuffer, w.jsonBuffer = nil, nil
 }
 
 // Write implements the io.Writer interface.  It writes a chunk of a document
 // to ShareBase with a PATCH.
 func (w *DocumentWriter) Write(p []byte) (n int, err error) {
+	if w.finished {
+		return 0, errors.Errorf("cannot write to closed %T", w)
+	}
+	if err = w.checkContext(); err != nil {
+		return 0, err
+	}
+	if err = validatePatchSize(w.PatchSize); err != nil {
+		return 0, err
+	}
 	if w.available() >= len(p) {
-		return w.dataBuffer.Write(p)
+		n, err = w.dataBuffer.Write(p)
+		w.written += int64(n)
+		return
 	}
+	written := 0
 	for remaining := p; len(remaining) > 0; remaining = remaining[n:] {
 		limit := w.available()
 		if limit > len(remaining) {
 			limit = len(remaining)
 		}
 		n, err = w.dataBuffer.Write(remaining[:limit])
+		written += n
+		w.written += int64(n)
 		if err != nil {
-			return
+			return written, err
 		}
 		if err = w.patch(); err != nil {
-			return
+			return written, err
 		}
 	}
 	return len(p), nil
@@ -422,45 +1553,68 @@ func (w *DocumentWriter) Write(p []byte) (n int, err error) {
 // available returns the amount of space available in the patch buffer.
 func (w *DocumentWriter) available() int {
 	length := w.dataBuffer.Len()
-	a := int(PatchSize) - length
+	a := int(w.PatchSize) - length
 	if a < 0 {
 		panic(errors.Errorf(
-			"%T data buffer larger than patch size (%d)", w, PatchSize))
+			"%T data buffer larger than patch size (%d)", w, w.PatchSize))
 	}
 	return a
 }
 
 func (w *DocumentWriter) patch() (err error) {
-	if err = w.Client.request(http.MethodPatch, w.NewLargeDocumentResponse.Links.Location, &w.dataBuffer, &w.jsonBuffer); err != nil {
+	length := int64(w.dataBuffer.Len())
+	if err = w.Client.request(http.MethodPatch, w.NewLargeDocumentResponse.Links.Location, w.dataBuffer, w.jsonBuffer, withContext(w.ctx)); err != nil {
+		if err2 := w.checkContext(); err2 != nil {
+			// the patch was interrupted by the context.
+			return err2
+		}
 		return errors.ErrorfWithCause(
 			err, "failed to patch document %q: %v", w.NewLargeDocumentResponse.FileName, err)
 	}
-	if err = json.Unmarshal(w.jsonBuffer.Bytes(), &w.NewLargeDocumentResponse); err != nil {
+	// only the size is taken from the patch's response so that the
+	// Location to patch is kept even if the response leaves it out.
+	var cur NewLargeDocumentResponse
+	if err = json.Unmarshal(w.jsonBuffer.Bytes(), &cur); err != nil {
 		return errors.ErrorfWithCause(
 			err, "failed to unmarshal updated upload info: %v", err)
 	}
+	w.NewLargeDocumentResponse.CurrentSize = cur.CurrentSize
 	if w.NewLargeDocumentResponse.CurrentSize == 0 {
 		return errors.Errorf(
 			"Last patch of document %q uploaded nothing.", w.NewLargeDocumentResponse.FileName)
 	}
 	w.jsonBuffer.Reset()
+	w.sent += length
+	w.Progress.call(w.sent, -1)
 	return nil
 }
 
 // createNewLargeDocument posts a request for a temporary file in the folder
-// with the given name.
-func (f *Folder) createNewLargeDocument(c *Client, name string) (r NewLargeDocumentResponse, err error) {
+// with the given name and content type.
+func (f *Folder) createNewLargeDocument(c *Client, name, contentType string) (r NewLargeDocumentResponse, err error) {
 	err = c.requestJSON(
 		http.MethodPost,
 		Concat(
 			f.Links.Self,
 			"/temp?filename=",
-			url.QueryEscape(name)),
+			url.QueryEscape(name),
+			"&contentType=",
+			url.QueryEscape(contentType)),
 		nil,
 		&r)
 	return
 }
 
+// largeDocumentFields gets the body of the request that finishes a large
+// document upload.  It's nil unless there are fields to send so that the
+// request stays empty otherwise.
+func largeDocumentFields(res NewLargeDocumentResponse, fields map[string]string) interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	return NewDocumentRequest{DocumentName: res.FileName, Fields: fields}
+}
+
 // simpleReader is similar to a bytes.Reader but shouldn't require an
 // allocation.
 type simpleReader struct {
@@ -494,11 +1648,21 @@ type Document struct {
 	// DocumentName holds the name of the document in its parent.
 	DocumentName string
 
+	// FolderID holds the ID of the folder that contains the document.
+	FolderID int `json:"FolderId"`
+
 	// DateModified stores the date that the Document was last modified.
 	DateModified time.Time
 
+	// Size is the size of the document's content in bytes.
+	Size int64 `json:"Size"`
+
+	// ContentType is the MIME type of the document's content, if
+	// ShareBase reports it.
+	ContentType string `json:",omitempty"`
+
 	// Hash is a base-64 encoded SHA-1 hash of the file's contents.
-	Hash []byte
+	Hash []byte `json:"Hash"`
 
 	// Links holds links that the document has to other ShareBase objects.
 	Links DocumentLinks
@@ -511,6 +1675,39 @@ type DocumentLinks struct {
 
 	// Content holds a link to the document's content.
 	Content string
+
+	// Shares holds a link to get this document's shares.
+	Shares string
+}
+
+// MetadataLoaded checks if the document's Size and Hash were populated when
+// it was listed.  Only the Hash is checked because an empty document's Size
+// is 0 even after it's populated, but every document has a hash.
+func (d *Document) MetadataLoaded() bool {
+	return len(d.Hash) != 0
+}
+
+// Metadata gets the document with its Size and Hash populated.  If they were
+// already populated when the document was listed (see MetadataLoaded), the
+// document is returned without making a request.  Otherwise the document is
+// requested from its Self link.
+func (d *Document) Metadata(c *Client) (Document, error) {
+	if d.MetadataLoaded() {
+		return *d, nil
+	}
+	var d2 Document
+	err := c.requestJSON(http.MethodGet, d.Links.Self, nil, &d2)
+	if err != nil {
+		if nf, ok := err.(NotFound); ok {
+			return Document{}, nf.describe(
+				DocumentKind, d.DocumentID, d.DocumentName)
+		}
+		return Document{}, err
+	}
+	if d2.Links.Self == "" {
+		d2.Links = d.Links
+	}
+	return d2, nil
 }
 
 // Content retrieves the document content.  It must be closed after it is
@@ -520,37 +1717,345 @@ func (d *Document) Content(c *Client) (DocumentContent, error) {
 	if err != nil {
 		return DocumentContent{}, err
 	}
-	lengths := head["Content-Length"]
-	if len(lengths) == 0 {
+	content, err := d.newDocumentContent(head, body)
+	if err != nil {
+		body.Close()
+		return DocumentContent{}, err
+	}
+	return content, nil
+}
+
+// ContentInfo describes a document's content without its body.  It's
+// returned from Document.Head.
+type ContentInfo struct {
+	// Length is the length of the content in bytes or -1 if it isn't
+	// known.
+	Length int64
+
+	ContentType        string
+	ContentDisposition string
+}
+
+// Filename gets the filename from the Content-Disposition header or an
+// empty string if there isn't one.
+func (ci ContentInfo) Filename() string {
+	return dispositionFilename(ci.ContentDisposition)
+}
+
+// Head gets the length, type, and disposition of the document's content
+// without downloading it, for example to decide whether to download it or to
+// know the total for a progress display.  If the server doesn't support HEAD
+// requests, the first byte of the content is requested instead and the
+// length is taken from the response's Content-Range.
+func (d *Document) Head(c *Client) (ContentInfo, error) {
+	res, err := c.requestResponse(http.MethodHead, d.Links.Content, nil)
+	if err == nil {
+		res.Body.Close()
+		return d.newContentInfo(res.Header)
+	}
+	if !isUnsupported(err) {
+		return ContentInfo{}, err
+	}
+	logger.Debug2(
+		"HEAD of %v content is not supported (%v).  Requesting a "+
+			"range instead.", d, err)
+	res, err = c.requestResponse(
+		http.MethodGet, d.Links.Content, nil,
+		setHeader("Range", "bytes=0-0"))
+	if err != nil {
+		if err, ok := err.(StatusError); ok && err.Code == http.StatusRequestedRangeNotSatisfiable {
+			// even the first byte is out of range, so the
+			// content is empty.
+			return ContentInfo{Length: 0}, nil
+		}
+		return ContentInfo{}, err
+	}
+	res.Body.Close()
+	ci, err := d.newContentInfo(res.Header)
+	if err != nil || res.StatusCode != http.StatusPartialContent {
+		// a server that ignores the range sends the whole
+		// length.
+		return ci, err
+	}
+	if ci.Length, err = parseContentRangeTotal(res.Header.Get("Content-Range")); err != nil {
+		return ContentInfo{}, errors.ErrorfWithCause(
+			err, "invalid range response for %v: %v", d, err)
+	}
+	return ci, nil
+}
+
+// newContentInfo gets the ContentInfo from a content response's header.
+func (d *Document) newContentInfo(head http.Header) (ContentInfo, error) {
+	content, err := d.newDocumentContent(head, nil)
+	if err != nil {
+		return ContentInfo{}, err
+	}
+	return ContentInfo{
+		Length:             content.Length,
+		ContentType:        content.ContentType,
+		ContentDisposition: content.ContentDisposition,
+	}, nil
+}
+
+// ContentWithLength is like Content but fails if the response has no
+// Content-Length header, for callers that need to know the length of the
+// content before reading it.
+func (d *Document) ContentWithLength(c *Client) (DocumentContent, error) {
+	content, err := d.Content(c)
+	if err != nil {
+		return DocumentContent{}, err
+	}
+	if content.Length < 0 {
+		content.Close()
 		return DocumentContent{}, errors.Errorf(
 			"%v content has no Content-Length", d)
 	}
-	bigLen := big.NewInt(0)
-	if _, ok := bigLen.SetString(lengths[0], 10); !ok || !bigLen.IsInt64() {
+	return content, nil
+}
+
+// ContentRange retrieves the document content from byte offset start through
+// byte offset end, inclusive.  If end is negative, the content from start
+// through the end of the document is retrieved.  Like Content, the result
+// must be closed.
+//
+// Servers that don't support range requests respond with the full content.
+// When that happens, the returned DocumentContent's Start is 0 and its Length
+// is the length of the whole document, so callers must check Start before
+// assuming they got only the requested range.
+func (d *Document) ContentRange(c *Client, start, end int64) (DocumentContent, error) {
+	if start < 0 {
+		return DocumentContent{}, errors.Errorf(
+			"range start must be non-negative, not %d", start)
+	}
+	if end >= 0 && end < start {
+		return DocumentContent{}, errors.Errorf(
+			"range end (%d) cannot come before start (%d)",
+			end, start)
+	}
+	byteRange := fmt.Sprintf("bytes=%d-", start)
+	if end >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	res, err := c.requestResponse(
+		http.MethodGet, d.Links.Content, nil,
+		setHeader("Range", byteRange))
+	if err != nil {
+		return DocumentContent{}, err
+	}
+	content, err := d.newDocumentContent(res.Header, res.Body)
+	if err != nil {
+		res.Body.Close()
+		return DocumentContent{}, err
+	}
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		first, err := parseContentRangeStart(content.ContentRange)
+		if err != nil {
+			content.Close()
+			return DocumentContent{}, errors.ErrorfWithCause(
+				err, "invalid range response for %v: %v", d, err)
+		}
+		if first != start {
+			content.Close()
+			return DocumentContent{}, errors.Errorf(
+				"requested %v content from offset %d but got "+
+					"content from offset %d",
+				d, start, first)
+		}
+		content.Start = first
+	case http.StatusOK:
+		logger.Debug1(
+			"server ignored range request for %v and returned "+
+				"the full content", d)
+	default:
+		content.Close()
 		return DocumentContent{}, errors.Errorf(
-			"failed to parse %v length %q to integer",
-			d, lengths[0])
+			"unexpected status for %v range request: %v",
+			d, res.Status)
+	}
+	return content, nil
+}
+
+// parseContentRangeStart parses the first byte offset out of a Content-Range
+// header value such as "bytes 100-199/1000".
+func parseContentRangeStart(v string) (int64, error) {
+	const prefix = "bytes "
+	if !strings.HasPrefix(v, prefix) {
+		return 0, errors.Errorf(
+			"unsupported Content-Range: %q", v)
 	}
-	length := bigLen.Int64()
-	var contentType string
-	contentTypes := head["Content-Type"]
-	if len(contentTypes) != 0 {
-		contentType = contentTypes[0]
+	v = v[len(prefix):]
+	i := strings.IndexByte(v, '-')
+	if i < 0 {
+		return 0, errors.Errorf(
+			"unsupported Content-Range: %q", v)
 	}
-	var contentDisposition string
-	contentDispositions := head["Content-Disposition"]
-	if len(contentDispositions) > 0 {
-		contentDisposition = contentDispositions[0]
+	return strconv.ParseInt(v[:i], 10, 64)
+}
+
+// parseContentRangeTotal parses the complete length out of a Content-Range
+// header value such as "bytes 100-199/1000".  It's -1 if the complete length
+// is unknown ("*").
+func parseContentRangeTotal(v string) (int64, error) {
+	i := strings.LastIndexByte(v, '/')
+	if !strings.HasPrefix(v, "bytes ") || i < 0 {
+		return 0, errors.Errorf(
+			"unsupported Content-Range: %q", v)
+	}
+	if v[i+1:] == "*" {
+		return -1, nil
+	}
+	return strconv.ParseInt(v[i+1:], 10, 64)
+}
+
+// newDocumentContent creates a DocumentContent from a content response's
+// header and body.
+func (d *Document) newDocumentContent(head http.Header, body io.ReadCloser) (DocumentContent, error) {
+	length := int64(-1)
+	if lengths := head["Content-Length"]; len(lengths) > 0 {
+		bigLen := big.NewInt(0)
+		if _, ok := bigLen.SetString(lengths[0], 10); !ok || !bigLen.IsInt64() {
+			return DocumentContent{}, errors.Errorf(
+				"failed to parse %v length %q to integer",
+				d, lengths[0])
+		}
+		length = bigLen.Int64()
 	}
 	return DocumentContent{
 		Document:           d,
 		ReadCloser:         body,
 		Length:             length,
-		ContentType:        contentType,
-		ContentDisposition: contentDisposition,
+		ContentType:        head.Get("Content-Type"),
+		ContentDisposition: head.Get("Content-Disposition"),
+		ContentRange:       head.Get("Content-Range"),
 	}, nil
 }
 
+// Delete deletes the document from ShareBase.
+func (d *Document) Delete(c *Client) error {
+	err := c.requestJSON(http.MethodDelete, d.Links.Self, nil, nil)
+	if nf, ok := err.(NotFound); ok {
+		return nf.describe(DocumentKind, d.DocumentID, d.DocumentName)
+	}
+	return err
+}
+
+// Shares gets the shares that have been created for the document.
+func (d *Document) Shares(c *Client) (shares []Share, err error) {
+	err = c.requestJSON(http.MethodGet, d.sharesLink(), nil, &shares)
+	return
+}
+
+// CreateShare creates a new share link to the document.
+func (d *Document) CreateShare(c *Client, opts ShareOptions) (share Share, err error) {
+	err = c.requestJSON(http.MethodPost, d.sharesLink(), opts, &share)
+	return
+}
+
+// Fields gets the document's index fields, the ones that
+// Library.SearchFields filters by, keyed by field name.  If ShareBase doesn't
+// support index fields, ErrFieldsUnsupported is returned.
+func (d *Document) Fields(c *Client) (fields map[string]string, err error) {
+	err = c.requestJSON(
+		http.MethodGet, Concat(d.Links.Self, "/fields"), nil, &fields)
+	if isUnsupported(err) {
+		return nil, ErrFieldsUnsupported
+	}
+	return fields, err
+}
+
+// SetFields sets the given index fields of the document.  Fields that aren't
+// in the map are left alone.  If ShareBase doesn't support index fields,
+// ErrFieldsUnsupported is returned.
+func (d *Document) SetFields(c *Client, fields map[string]string) error {
+	err := c.requestJSON(
+		http.MethodPut, Concat(d.Links.Self, "/fields"), fields, nil)
+	if isUnsupported(err) {
+		return ErrFieldsUnsupported
+	}
+	return err
+}
+
+// sharesLink gets the link to the document's shares.  Not all ShareBase
+// responses include it, so it falls back to the conventional location
+// relative to the document's Self link.
+func (d *Document) sharesLink() string {
+	if d.Links.Shares != "" {
+		return d.Links.Shares
+	}
+	return Concat(d.Links.Self, "/shares")
+}
+
+// CopyDocumentRequest is marshaled when asking ShareBase to copy a document
+// into another folder.
+type CopyDocumentRequest struct {
+	// FolderID is the ID of the folder that the copy is created in.
+	FolderID int `json:"FolderId"`
+
+	// DocumentName is the name of the new copy of the document.
+	DocumentName string
+}
+
+// CopyTo copies the document into the dst folder with the given newName.  If
+// newName is empty, the copy keeps the document's current name.  ShareBase is
+// asked to copy the document server-side first.  If the API doesn't support
+// that, the content is downloaded and uploaded again into dst.
+func (d *Document) CopyTo(c *Client, dst *Folder, newName string) (Document, error) {
+	if newName == "" {
+		newName = d.DocumentName
+	}
+	copied, err := d.CopyOnServer(c, dst, newName)
+	if err != ErrServerCopyUnsupported {
+		return copied, err
+	}
+	logger.Debug1(
+		"server-side copy of %v is not supported.  "+
+			"Falling back to download and upload.", d)
+	content, err := d.Content(c)
+	if err != nil {
+		return Document{}, errors.ErrorfWithCause(
+			err, "failed to get content of %v: %v", d, err)
+	}
+	defer content.Close()
+	copied, err = dst.NewDocumentWithSize(c, newName, content, content.Length)
+	if err != nil {
+		return Document{}, errors.ErrorfWithCause(
+			err, "failed to upload copy of %v: %v", d, err)
+	}
+	return copied, nil
+}
+
+// CopyOnServer is like CopyTo but only asks ShareBase to copy the document
+// server-side.  If the API doesn't support that, ErrServerCopyUnsupported is
+// returned and nothing is copied.
+func (d *Document) CopyOnServer(c *Client, dst *Folder, newName string) (Document, error) {
+	if newName == "" {
+		newName = d.DocumentName
+	} else if err := ValidateName(newName); err != nil {
+		return Document{}, err
+	}
+	var copied Document
+	err := c.requestJSON(
+		http.MethodPost,
+		Concat(d.Links.Self, "/copy"),
+		CopyDocumentRequest{
+			FolderID:     dst.FolderID,
+			DocumentName: newName,
+		},
+		&copied)
+	if err == nil {
+		logger.Debug2("copied %v server-side to %v", d, copied)
+		return copied, nil
+	}
+	if isUnsupported(err) {
+		logger.Debug2(
+			"server-side copy of %v is not supported: %v", d, err)
+		return Document{}, ErrServerCopyUnsupported
+	}
+	return Document{}, errors.ErrorfWithCause(
+		err, "failed to copy %v: %v", d, err)
+}
+
 // String gets a string representation of the document.
 func (d Document) String() string {
 	return fmt.Sprintf("Document %q, (ID: %d)", d.DocumentName, d.DocumentID)
@@ -561,9 +2066,22 @@ func (d Document) String() string {
 type DocumentContent struct {
 	*Document
 	io.ReadCloser
-	Length             int64
+
+	// Length is the length of the content in bytes or -1 if the
+	// response didn't have a Content-Length (e.g. when it's chunked).
+	// Content of unknown length must be read until EOF.
+	Length int64
+
 	ContentType        string
 	ContentDisposition string
+
+	// ContentRange is the Content-Range header of a partial content
+	// response returned from ContentRange.
+	ContentRange string
+
+	// Start is the offset within the document of the first byte of
+	// content.
+	Start int64
 }
 
 // Close wraps the io.ReadCloser's Close function but adds more context to the
@@ -577,8 +2095,96 @@ func (d DocumentContent) Close() error {
 	return nil
 }
 
+// Filename gets the name that ShareBase gave the content in its
+// Content-Disposition header.  Both the filename parameter and RFC 5987's
+// extended filename* parameter are supported (filename* takes precedence).
+// Any directories in the name are removed.  If the header is missing or has no
+// filename, the document's name is returned.
+func (d DocumentContent) Filename() string {
+	if name := dispositionFilename(d.ContentDisposition); name != "" {
+		return name
+	}
+	if d.Document == nil {
+		return ""
+	}
+	return d.DocumentName
+}
+
+// dispositionFilename gets the base name of the filename in a
+// Content-Disposition header value or an empty string if it doesn't have a
+// usable one.
+func dispositionFilename(cd string) string {
+	if cd == "" {
+		return ""
+	}
+	// mime.ParseMediaType decodes filename* into filename.
+	_, params, err := mime.ParseMediaType(cd)
+	if err != nil {
+		logger.Debug2(
+			"failed to parse Content-Disposition %q: %v", cd, err)
+		return ""
+	}
+	if name := params["filename"]; name != "" {
+		name = path.Base(strings.Replace(name, "\\", "/", -1))
+		if name != "." && name != "/" && name != ".." {
+			return name
+		}
+	}
+	return ""
+}
+
+// TrackProgress returns a copy of the document content that calls f after
+// every read with the total number of bytes read so far and the content's
+// Length.  The original DocumentContent shouldn't be read from or closed
+// after calling TrackProgress; use the returned copy instead.
+func (d DocumentContent) TrackProgress(f ProgressFunc) DocumentContent {
+	if f == nil {
+		return d
+	}
+	d.ReadCloser = &progressReader{
+		ReadCloser: d.ReadCloser,
+		progress:   f,
+		total:      d.Length,
+	}
+	return d
+}
+
+// progressReader wraps an io.ReadCloser and calls a ProgressFunc after every
+// non-empty read up until it is closed.
+type progressReader struct {
+	io.ReadCloser
+	progress ProgressFunc
+	read     int64
+	total    int64
+	closed   bool
+}
+
+// Read implements io.Reader.
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 && !r.closed {
+		r.read += int64(n)
+		r.progress(r.read, r.total)
+	}
+	return
+}
+
+// Close implements io.Closer.
+func (r *progressReader) Close() error {
+	r.closed = true
+	return r.ReadCloser.Close()
+}
+
 // Len gets the document content's length as an int64 (A normal int isn't large
 // enough on a 32-bit platform for file sizes >2GiB).
 func (d DocumentContent) Len() int64 {
 	return d.Length
 }
+
+// Len64 implements Lener64 so that the content can be passed to
+// Folder.NewDocument to upload it again.  It's the same as Len, which can't
+// implement Lener because it returns an int64.  It's -1 if the length isn't
+// known.
+func (d DocumentContent) Len64() int64 {
+	return d.Length
+}
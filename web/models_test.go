@@ -0,0 +1,751 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestNewDocumentWithSize(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		size    int64
+		large   bool
+		options []web.DocumentOption
+	}{
+		{"small", 1 * int64(web.K), false, nil},
+		{"unknown", -1, true, nil},
+		{"lowered", 1 * int64(web.K), true, []web.DocumentOption{
+			web.WithSmallFileCutoff(web.K),
+		}},
+		{"raised", 6 * int64(web.M), false, []web.DocumentOption{
+			web.WithSmallFileCutoff(10 * web.M),
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &fakeLargeUpload{}
+			srv := u.serve(t)
+			defer srv.Close()
+
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := web.Folder{
+				FolderID: 1,
+				Links: web.FolderLinks{
+					Self:      srv.URL + "/folders/1",
+					Documents: srv.URL + "/folders/1/documents",
+				},
+			}
+			// hide bytes.Reader's Len method so that only the
+			// given size can be used to pick the upload method.
+			length := tc.size
+			if length < 0 {
+				length = int64(web.K)
+			}
+			content := struct{ io.Reader }{
+				bytes.NewReader(make([]byte, length)),
+			}
+			if _, err = f.NewDocumentWithSize(
+				c, "sized.bin", content, tc.size, tc.options...); err != nil {
+				t.Fatal(err)
+			}
+			if large := u.started > 0; large != tc.large {
+				t.Fatalf(
+					"expected large upload: %v, actual: %v",
+					tc.large, large)
+			}
+		})
+	}
+}
+
+func TestNewDocumentInvalidName(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{
+		FolderID:   1,
+		FolderName: "Folder",
+		Links:      web.FolderLinks{Documents: srv.URL + "/folders/1/documents"},
+	}
+	for _, tc := range []struct {
+		name  string
+		char  rune
+		clean string
+	}{
+		{"a/b.txt", '/', "ab.txt"},
+		{"a\\b.txt", '\\', "ab.txt"},
+		{"tab\t.txt", '\t', "tab.txt"},
+		{"nul\x00.txt", 0, "nul.txt"},
+		{"", 0, ""},
+	} {
+		_, err := f.NewDocument(c, tc.name, strings.NewReader("content"))
+		invalid, ok := err.(web.InvalidName)
+		if !ok {
+			t.Fatalf("expected InvalidName for %q, got %v", tc.name, err)
+		}
+		if invalid.Name != tc.name || invalid.Char != tc.char {
+			t.Fatalf(
+				"expected InvalidName{%q, %q}, got %#v",
+				tc.name, tc.char, invalid)
+		}
+		if clean := web.CleanName(tc.name); clean != tc.clean {
+			t.Fatalf("expected %q cleaned to %q, got %q", tc.name, tc.clean, clean)
+		}
+	}
+	if _, err = f.DocumentWriter(c, "a/b.txt"); err == nil {
+		t.Fatal("expected DocumentWriter to reject a name with a slash")
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no requests, got %d", n)
+	}
+	if err = web.ValidateName("résumé (1).txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithSmallFileCutoff(t *testing.T) {
+	var f web.Folder
+	for _, size := range []web.Size{0, -1} {
+		_, err := f.NewDocument(
+			nil, "a.txt", strings.NewReader("a"),
+			web.WithSmallFileCutoff(size))
+		if err == nil {
+			t.Fatalf("expected an error for a cutoff of %d", size)
+		}
+	}
+	if web.IsSmallDocumentWithCutoff(int64(web.K), web.K) ||
+		!web.IsSmallDocumentWithCutoff(int64(web.K), web.K+1) {
+		t.Fatal("expected sizes under the cutoff to be small")
+	}
+}
+
+// hugeReader pretends to be a reader of a file larger than 4GiB on a 32-bit
+// platform where its Len wraps around to a small size.
+type hugeReader struct{ io.Reader }
+
+var hugeLength = 4*int64(web.G) + int64(web.K)
+
+func (hugeReader) Len() int { return int(int32(hugeLength)) }
+
+func (hugeReader) Len64() int64 { return hugeLength }
+
+var _ web.Lener64 = web.DocumentContent{}
+
+func TestNewDocumentLen64(t *testing.T) {
+	if web.IsSmallDocument(hugeLength) || !web.IsSmallDocument(int64(hugeReader{}.Len())) {
+		t.Fatal("expected only the wrapped length to look small")
+	}
+	u := &fakeLargeUpload{}
+	srv := u.serve(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{
+		FolderID: 1,
+		Links: web.FolderLinks{
+			Self:      srv.URL + "/folders/1",
+			Documents: srv.URL + "/folders/1/documents",
+		},
+	}
+	// the content is much shorter than its claimed length, so the
+	// upload fails; only the upload method it picked matters.
+	_, _ = f.NewDocument(
+		c, "huge.bin", hugeReader{bytes.NewReader(make([]byte, web.K))})
+	if u.started == 0 {
+		t.Fatal("expected a large upload")
+	}
+}
+
+func TestDocumentContentFilename(t *testing.T) {
+	d := &web.Document{DocumentName: "fallback.txt"}
+	for _, tc := range []struct {
+		disposition string
+		expected    string
+	}{
+		{"", "fallback.txt"},
+		{"attachment", "fallback.txt"},
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename*=UTF-8''na%C3%AFve%20file.txt`, "naïve file.txt"},
+		{`attachment; filename="plain.txt"; filename*=UTF-8''fancy%E2%82%AC.txt`, "fancy€.txt"},
+		{`attachment; filename="..\\..\\evil.txt"`, "evil.txt"},
+		{`attachment; filename="../../evil.txt"`, "evil.txt"},
+		{`attachment; filename=`, "fallback.txt"},
+	} {
+		content := web.DocumentContent{
+			Document:           d,
+			ContentDisposition: tc.disposition,
+		}
+		if actual := content.Filename(); actual != tc.expected {
+			t.Errorf(
+				"%q: expected %q, actual %q",
+				tc.disposition, tc.expected, actual)
+		}
+	}
+}
+
+func TestDocumentHead(t *testing.T) {
+	const text = "content that shouldn't be downloaded"
+	for _, tc := range []struct {
+		name    string
+		head    bool
+		ranges  bool
+		content string
+		methods []string
+	}{
+		{"head", true, true, text, []string{"HEAD"}},
+		{"range", false, true, text, []string{"HEAD", "GET bytes=0-0"}},
+		{"ignoredRange", false, false, text, []string{"HEAD", "GET bytes=0-0"}},
+		{"empty", false, true, "", []string{"HEAD", "GET bytes=0-0"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var methods []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, strings.TrimSpace(r.Method+" "+r.Header.Get("Range")))
+				if r.Method == http.MethodHead && !tc.head {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if !tc.ranges {
+					r.Header.Del("Range")
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Disposition", `attachment; filename="doc.txt"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(tc.content))
+			}))
+			defer srv.Close()
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &web.Document{Links: web.DocumentLinks{Content: srv.URL}}
+			ci, err := d.Head(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ci.Length != int64(len(tc.content)) {
+				t.Fatalf("expected length %d, got %d", len(tc.content), ci.Length)
+			}
+			if tc.content != "" && (ci.ContentType != "text/plain" || ci.Filename() != "doc.txt") {
+				t.Fatalf("unexpected content info: %+v", ci)
+			}
+			if strings.Join(methods, ", ") != strings.Join(tc.methods, ", ") {
+				t.Fatalf("expected requests %q, got %q", tc.methods, methods)
+			}
+		})
+	}
+}
+
+func TestDocumentMetadata(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		json.NewEncoder(w).Encode(web.Document{DocumentID: 1, Size: 3, Hash: []byte{1}})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an empty document's metadata is already loaded if it has a hash.
+	empty := &web.Document{DocumentID: 2, Hash: []byte{2}, Links: web.DocumentLinks{Self: srv.URL}}
+	if md, err := empty.Metadata(c); err != nil || md.DocumentID != 2 {
+		t.Fatalf("expected the empty document, got %+v: %v", md, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no requests for the empty document, got %d", n)
+	}
+	listed := &web.Document{DocumentID: 1, Size: 3, Links: web.DocumentLinks{Self: srv.URL}}
+	if md, err := listed.Metadata(c); err != nil || len(md.Hash) == 0 {
+		t.Fatalf("expected the document's hash, got %+v: %v", md, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request for the document without a hash, got %d", n)
+	}
+}
+
+func TestDocumentContentUnknownLength(t *testing.T) {
+	const text = "streamed without a Content-Length"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// flushing before the handler returns makes the response
+		// chunked.
+		io.WriteString(w, text[:8])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, text[8:])
+	}))
+	defer srv.Close()
+
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &web.Document{
+		DocumentName: "streamed.txt",
+		Links:        web.DocumentLinks{Content: srv.URL + "/content"},
+	}
+	content, err := d.Content(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	if content.Length != -1 {
+		t.Fatalf("expected unknown length -1, got %d", content.Length)
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != text {
+		t.Fatalf("expected %q, got %q", text, data)
+	}
+	if _, err = d.ContentWithLength(c); err == nil {
+		t.Fatal("expected ContentWithLength to fail without a Content-Length")
+	}
+}
+
+// serveSmallUpload serves a folder that accepts small document uploads and
+// checks that their bodies are complete multipart forms.  If content is nil,
+// the uploaded content is discarded without being checked.
+func serveSmallUpload(tb testing.TB, content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength < 0 {
+			tb.Error("expected the small upload's content length")
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		var name string
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				tb.Error(err)
+				return
+			}
+			if p.FormName() == "file" && content == nil {
+				if _, err = io.Copy(ioutil.Discard, p); err != nil {
+					tb.Error(err)
+					return
+				}
+				continue
+			}
+			data, err := ioutil.ReadAll(p)
+			if err != nil {
+				tb.Error(err)
+				return
+			}
+			switch p.FormName() {
+			case "metadata":
+				var req web.NewDocumentRequest
+				if err = json.Unmarshal(data, &req); err != nil {
+					tb.Error(err)
+					return
+				}
+				name = req.DocumentName
+			case "file":
+				if !bytes.Equal(data, content) {
+					tb.Errorf("expected %d bytes of content, got %d", len(content), len(data))
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(web.Document{DocumentID: 1, DocumentName: name})
+	}))
+}
+
+func TestNewSmallDocument(t *testing.T) {
+	content := bytes.Repeat([]byte("small document\n"), 100)
+	srv := serveSmallUpload(t, content)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{FolderID: 1, Links: web.FolderLinks{Documents: srv.URL}}
+	d, err := f.NewDocumentWithSize(
+		c, "small.txt", struct{ io.Reader }{bytes.NewReader(content)},
+		int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.DocumentName != "small.txt" {
+		t.Fatalf("expected small.txt, got %q", d.DocumentName)
+	}
+}
+
+// BenchmarkNewSmallDocument shows the allocations of a small upload, which
+// don't include a copy of the content.
+func BenchmarkNewSmallDocument(b *testing.B) {
+	content := make([]byte, 4*web.M)
+	srv := serveSmallUpload(b, nil)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := web.Folder{FolderID: 1, Links: web.FolderLinks{Documents: srv.URL}}
+	b.SetBytes(int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = f.NewDocumentWithSize(
+			c, "small.bin", bytes.NewReader(content),
+			int64(len(content))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// serveFolderTree serves the Documents and Folders listings of a tree of
+// folders where each folder's documents are the given sizes.  Listing
+// folder 3's documents fails.
+func serveFolderTree(requests *int32) *httptest.Server {
+	docs := map[int][]int64{1: {10, 20}, 2: {5}, 3: {100}, 4: {1}}
+	subs := map[int][]int{1: {2, 3}, 2: {4}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[0] != "folders" {
+			http.NotFound(w, r)
+			return
+		}
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		switch parts[2] {
+		case "documents":
+			if id == 3 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var ds []web.Document
+			for _, size := range docs[id] {
+				ds = append(ds, web.Document{Size: size})
+			}
+			json.NewEncoder(w).Encode(ds)
+		case "folders":
+			var fs []web.Folder
+			for _, sub := range subs[id] {
+				fs = append(fs, testFolder(r.Host, sub))
+			}
+			json.NewEncoder(w).Encode(fs)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func testFolder(host string, id int) web.Folder {
+	base := "http://" + host + "/folders/" + strconv.Itoa(id)
+	return web.Folder{
+		FolderID:   id,
+		FolderName: "folder " + strconv.Itoa(id),
+		Links: web.FolderLinks{
+			Self:      base,
+			Documents: base + "/documents",
+			Folders:   base + "/folders",
+		},
+	}
+}
+
+func TestFolderSize(t *testing.T) {
+	var requests int32
+	srv := serveFolderTree(&requests)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	f := testFolder(host, 2)
+	size, err := f.Size(c, false)
+	if err != nil || size != 5 {
+		t.Fatalf("expected 5, got %d, %v", size, err)
+	}
+	size, err = f.Size(c, true)
+	if err != nil || size != 6 {
+		t.Fatalf("expected 6, got %d, %v", size, err)
+	}
+	before := atomic.LoadInt32(&requests)
+	if size, err = f.Size(c, true); err != nil || size != 6 {
+		t.Fatalf("expected cached 6, got %d, %v", size, err)
+	}
+	if after := atomic.LoadInt32(&requests); after != before {
+		t.Fatalf("cached size made %d requests", after-before)
+	}
+
+	f = testFolder(host, 1)
+	size, err = f.Size(c, true)
+	inc, ok := err.(web.IncompleteSize)
+	if !ok {
+		t.Fatalf("expected %T, got %v", inc, err)
+	}
+	if size != 36 || inc.Size != 36 || len(inc.Errs) != 1 {
+		t.Fatalf("expected 36 bytes and 1 error, got %d: %+v", size, inc)
+	}
+
+	f = testFolder(host, 3)
+	if _, err = f.Size(c, false); err == nil {
+		t.Fatal("expected an error sizing a folder that can't be listed")
+	}
+}
+
+func TestLibraryWalkDocuments(t *testing.T) {
+	folders := map[int]web.Folder{
+		1: {FolderID: 1, FolderName: "A", Embedded: web.FolderEmbedded{
+			Documents: []web.Document{{DocumentID: 10, DocumentName: "x.txt"}},
+			Folders:   []web.Folder{{FolderID: 3, FolderName: "C"}},
+		}},
+		2: {FolderID: 2, FolderName: "B", Embedded: web.FolderEmbedded{
+			// x.txt is listed again.
+			Documents: []web.Document{
+				{DocumentID: 10, DocumentName: "x.txt"},
+				{DocumentID: 11, DocumentName: "y.txt"},
+			},
+		}},
+		3: {FolderID: 3, FolderName: "C", Embedded: web.FolderEmbedded{
+			Documents: []web.Document{{DocumentID: 12, DocumentName: "z.txt"}},
+			// a folder that lists its parent isn't walked twice.
+			Folders: []web.Folder{{FolderID: 1, FolderName: "A"}},
+		}},
+	}
+	var inFlight, maxInFlight int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/libraries/1/folders", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]web.Folder{folders[1], folders[2]})
+	})
+	mux.HandleFunc("/api/folders/", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/folders/"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(folders[id])
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := web.Library{Links: web.LibraryLinks{Folders: srv.URL + "/libraries/1/folders"}}
+	paths := make(map[int]string)
+	err = lib.WalkDocuments(c, func(path []string, d web.Document) error {
+		if _, ok := paths[d.DocumentID]; ok {
+			t.Errorf("document %d walked twice", d.DocumentID)
+		}
+		paths[d.DocumentID] = strings.Join(path, "/")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 || paths[11] != "B" || paths[12] != "A/C" {
+		t.Fatalf("unexpected documents: %v", paths)
+	}
+	if got := c.NumRequests(); got != 4 {
+		t.Fatalf("expected 4 requests, got %d", got)
+	}
+	if maxInFlight > web.MaxConcurrentFolderListings {
+		t.Fatalf("expected at most %d listings at once, got %d",
+			web.MaxConcurrentFolderListings, maxInFlight)
+	}
+	docs, err := lib.AllDocuments(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("expected 3 documents, got %d", len(docs))
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	fields := map[string]string{"Invoice": "42", "Vendor": "ACME"}
+	var (
+		srv      *httptest.Server
+		received []map[string]string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/folders/1/temp", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{
+			Links:    web.NewLargeDocumentResponseLinks{Location: srv.URL + "/temp/1"},
+			FileName: r.URL.Query().Get("filename"),
+		})
+	})
+	mux.HandleFunc("/temp/1", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{CurrentSize: uint64(n)})
+	})
+	mux.HandleFunc("/folders/1/documents", func(w http.ResponseWriter, r *http.Request) {
+		var req web.NewDocumentRequest
+		if r.Header.Get("x-sharebase-fileref") != "" {
+			// finishing a large upload, which only has a body when
+			// there are fields to set.
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				t.Error(err)
+			}
+		} else {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := json.Unmarshal([]byte(r.FormValue("metadata")), &req); err != nil {
+				t.Error(err)
+			}
+		}
+		received = append(received, req.Fields)
+		json.NewEncoder(w).Encode(web.Document{DocumentID: 1, DocumentName: req.DocumentName})
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{FolderID: 1, Links: web.FolderLinks{
+		Self:      srv.URL + "/folders/1",
+		Documents: srv.URL + "/folders/1/documents",
+	}}
+	for _, size := range []int64{int64(web.K), -1} {
+		if _, err = f.NewDocumentWithSize(
+			c, "invoice.pdf", bytes.NewReader(make([]byte, web.K)), size,
+			web.WithFields(fields)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = f.NewDocumentWithSize(
+		c, "plain.bin", bytes.NewReader(make([]byte, web.K)), -1); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 || received[2] != nil {
+		t.Fatalf("expected 2 uploads with fields and 1 without, got %v", received)
+	}
+	for i, got := range received[:2] {
+		if got["Invoice"] != "42" || got["Vendor"] != "ACME" || len(got) != 2 {
+			t.Fatalf("upload %d: expected %v, got %v", i, fields, got)
+		}
+	}
+
+	defs := []web.FieldDefinition{{FieldName: "Invoice", Required: true}, {FieldName: "Notes"}}
+	if err = web.CheckFields(defs, fields); err != nil {
+		t.Fatal(err)
+	}
+	err = web.CheckFields(defs, map[string]string{"Notes": "x"})
+	if missing, ok := err.(web.MissingFields); !ok || len(missing.Names) != 1 || missing.Names[0] != "Invoice" {
+		t.Fatalf("expected Invoice to be missing, got %v", err)
+	}
+}
+
+func TestFolderWithEmbed(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/folders/7" {
+			t.Errorf("unexpected path: %v", r.URL.Path)
+		}
+		queries = append(queries, r.URL.RawQuery)
+		json.NewEncoder(w).Encode(web.Folder{FolderID: 7})
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lib web.Library
+	if _, err = lib.Folder(c, 7); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []web.Embed{{Folders: true}, {Documents: true}, {}} {
+		if _, err = lib.FolderWithEmbed(c, 7, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"embed=d,f", "embed=f", "embed=d", ""}
+	if strings.Join(queries, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected queries %q, got %q", expected, queries)
+	}
+}
+
+func TestFolderRenameAndMove(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		req := r.Method + " " + r.URL.Path
+		switch {
+		case req == "PUT /api/folders/7" && body["FolderName"] == "Taken":
+			w.WriteHeader(http.StatusConflict)
+			return
+		case req == "PUT /api/folders/7":
+			req += " " + body["FolderName"].(string)
+		case req == "POST /api/folders/7/move":
+			req += " " + strconv.Itoa(int(body["ParentFolderId"].(float64)))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		requests = append(requests, req)
+	}))
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{FolderID: 7, FolderName: "Old", LibraryID: 1}
+	if err = f.Rename(c, "New"); err != nil {
+		t.Fatal(err)
+	}
+	if f.FolderName != "New" {
+		t.Fatalf("expected the folder to be renamed, got %q", f.FolderName)
+	}
+	if err = f.Rename(c, "Taken"); err != (web.AlreadyExists{Kind: web.FolderKind, Name: "Taken"}) {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if err = f.MoveToFolder(c, &web.Folder{FolderID: 8, LibraryID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.MoveToLibrary(c, &web.Library{LibraryID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.MoveToLibrary(c, &web.Library{LibraryID: 2}); err == nil {
+		t.Fatal("expected an error moving to another library")
+	}
+	expected := []string{"PUT /api/folders/7 New", "POST /api/folders/7/move 8", "POST /api/folders/7/move 0"}
+	if strings.Join(requests, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected requests %q, got %q", expected, requests)
+	}
+}
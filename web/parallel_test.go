@@ -0,0 +1,340 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/skillian/sharebase/web"
+)
+
+// fakeLargeUpload is a fake ShareBase large document upload endpoint that
+// accepts offset-addressed patches.
+type fakeLargeUpload struct {
+	mutex sync.Mutex
+	data  []byte
+	done  bool
+
+	// started counts the large uploads that were started.
+	started int
+
+	// aborted counts the DELETEs of the temporary file.
+	aborted int
+
+	// truncate is subtracted from the current size reported after each
+	// patch.
+	truncate int
+}
+
+func (u *fakeLargeUpload) serve(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/folders/1/temp", func(w http.ResponseWriter, r *http.Request) {
+		u.mutex.Lock()
+		u.started++
+		// each upload starts a new temporary file.
+		u.data = nil
+		u.mutex.Unlock()
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{
+			Links: web.NewLargeDocumentResponseLinks{
+				Location: srv.URL + "/temp/1",
+			},
+			FileName: r.URL.Query().Get("filename"),
+		})
+	})
+	mux.HandleFunc("/temp/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			u.mutex.Lock()
+			u.aborted++
+			u.mutex.Unlock()
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read patch: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u.mutex.Lock()
+		// sequential patches without a Content-Range are appended.
+		start, end, size := len(u.data), len(u.data)+len(body)-1, len(u.data)+len(body)
+		if cr := r.Header.Get("Content-Range"); cr != "" {
+			if _, err := fmt.Sscanf(
+				cr, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+				u.mutex.Unlock()
+				t.Errorf("bad Content-Range: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if len(body) != end-start+1 {
+			u.mutex.Unlock()
+			t.Errorf("expected %d bytes, got %d", end-start+1, len(body))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(u.data) < size {
+			u.data = append(u.data, make([]byte, size-len(u.data))...)
+		}
+		copy(u.data[start:], body)
+		u.mutex.Unlock()
+		u.mutex.Lock()
+		cur := u.data
+		if u.truncate > 0 && len(cur) > 0 {
+			// pretend the end of the patch was lost.
+			cur = cur[:len(cur)-u.truncate]
+		}
+		u.mutex.Unlock()
+		json.NewEncoder(w).Encode(web.NewLargeDocumentResponse{
+			CurrentSize: uint64(len(cur)),
+		})
+	})
+	mux.HandleFunc("/folders/1/documents", func(w http.ResponseWriter, r *http.Request) {
+		u.mutex.Lock()
+		u.done = true
+		u.mutex.Unlock()
+		json.NewEncoder(w).Encode(web.Document{DocumentID: 1})
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestNewDocumentFromReaderAt(t *testing.T) {
+	u := &fakeLargeUpload{}
+	srv := u.serve(t)
+	defer srv.Close()
+
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{
+		FolderID: 1,
+		Links: web.FolderLinks{
+			Self:      srv.URL + "/folders/1",
+			Documents: srv.URL + "/folders/1/documents",
+		},
+	}
+
+	source := make([]byte, 3*web.M+123)
+	rand.New(rand.NewSource(1)).Read(source)
+
+	var sent int64
+	d, err := f.NewDocumentFromReaderAt(
+		c, "parallel.bin", bytes.NewReader(source), int64(len(source)), 4,
+		web.WithPatchSize(256*web.K),
+		web.WithProgress(func(bytesSent, totalBytes int64) {
+			if totalBytes != int64(len(source)) {
+				t.Errorf("expected total %d, got %d", len(source), totalBytes)
+			}
+			sent = bytesSent
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.done {
+		t.Fatal("upload was never finalized")
+	}
+	if d.DocumentID != 1 {
+		t.Fatalf("expected the new document's ID: 1, got %d", d.DocumentID)
+	}
+	if sent != int64(len(source)) {
+		t.Fatalf("progress reported %d bytes, expected %d", sent, len(source))
+	}
+	if sha512.Sum512(u.data) != sha512.Sum512(source) {
+		t.Fatal("uploaded content hash doesn't match the source hash")
+	}
+}
+
+// failingReader returns err after n bytes of zeros.
+type failingReader struct {
+	n   int
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, r.err
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestLargeDocumentAbort(t *testing.T) {
+	u := &fakeLargeUpload{}
+	srv := u.serve(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{
+		FolderID: 1,
+		Links: web.FolderLinks{
+			Self:      srv.URL + "/folders/1",
+			Documents: srv.URL + "/folders/1/documents",
+		},
+	}
+	readErr := fmt.Errorf("disk on fire")
+	_, err = f.NewDocumentWithSize(
+		c, "fails.bin", &failingReader{n: int(web.M), err: readErr}, -1)
+	if err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if u.aborted != 1 || u.done {
+		t.Fatalf("expected the failed upload to be aborted, aborted: %d, done: %v", u.aborted, u.done)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := f.DocumentWriter(c, "canceled.bin", web.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(make([]byte, web.K)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	_, err = w.Write(make([]byte, web.K))
+	if uc, ok := err.(web.UploadCanceled); !ok || uc.Err != context.Canceled || uc.Sent != 0 {
+		t.Fatalf("expected %v after 0 bytes, got %v", context.Canceled, err)
+	}
+	if u.aborted != 2 {
+		t.Fatalf("expected the canceled upload to be aborted, aborted: %d", u.aborted)
+	}
+	// aborting again and closing after aborting don't do anything.
+	if err = w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err == nil {
+		t.Fatal("expected an error closing an aborted writer")
+	}
+	if u.aborted != 2 || u.done {
+		t.Fatalf("expected only 2 aborts and no finished upload, aborted: %d, done: %v", u.aborted, u.done)
+	}
+
+	w, err = f.DocumentWriter(c, "closed.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(make([]byte, web.K)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if u.aborted != 2 || !u.done {
+		t.Fatalf("expected the closed upload to be finished, aborted: %d, done: %v", u.aborted, u.done)
+	}
+}
+
+func TestLargeDocumentCancel(t *testing.T) {
+	u := &fakeLargeUpload{}
+	srv := u.serve(t)
+	defer srv.Close()
+	c, err := web.NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := web.Folder{
+		FolderID: 1,
+		Links: web.FolderLinks{
+			Self:      srv.URL + "/folders/1",
+			Documents: srv.URL + "/folders/1/documents",
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// cancel the upload as soon as its first patch is sent.
+	_, err = f.NewDocumentWithSize(
+		c, "canceled.bin", bytes.NewReader(make([]byte, 4*web.K)), -1,
+		web.WithContext(ctx),
+		web.WithPatchSize(web.K),
+		web.WithProgress(func(sent, total int64) { cancel() }))
+	uc, ok := err.(web.UploadCanceled)
+	if !ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the upload to be canceled, got %v", err)
+	}
+	if uc.Sent != int64(web.K) {
+		t.Fatalf("expected %d bytes to be sent, got %d", web.K, uc.Sent)
+	}
+	if u.aborted != 1 || u.done {
+		t.Fatalf("expected the canceled upload to be aborted, aborted: %d, done: %v", u.aborted, u.done)
+	}
+}
+
+func TestDocumentWriterSize(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		truncate int
+	}{
+		{"complete", 0},
+		{"truncated", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &fakeLargeUpload{truncate: tc.truncate}
+			srv := u.serve(t)
+			defer srv.Close()
+			c, err := web.NewClient(srv.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := web.Folder{
+				FolderID: 1,
+				Links: web.FolderLinks{
+					Self:      srv.URL + "/folders/1",
+					Documents: srv.URL + "/folders/1/documents",
+				},
+			}
+			w, err := f.DocumentWriter(c, "sized.bin", web.WithPatchSize(web.K))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if _, err = w.Write(make([]byte, 700)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if w.Size() != 2100 {
+				t.Fatalf("expected size 2100, got %d", w.Size())
+			}
+			err = w.Close()
+			if tc.truncate == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(u.data) != 2100 || !u.done {
+					t.Fatalf("expected 2100 bytes uploaded and finished, got %d, %v", len(u.data), u.done)
+				}
+				return
+			}
+			sm, ok := err.(web.SizeMismatch)
+			if !ok || sm.Expected != 2100 || sm.Actual != 2099 {
+				t.Fatalf("expected a size mismatch, got %v", err)
+			}
+			if u.done {
+				t.Fatal("expected the mismatched upload not to be finished")
+			}
+			if err = w.Abort(); err != nil || u.aborted != 1 {
+				t.Fatalf("expected the upload to be aborted, got %v, %d", err, u.aborted)
+			}
+		})
+	}
+}
@@ -1,9 +1,19 @@
 package web
 
 import (
+	"context"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skillian/errors"
 )
 
+// ErrPoolClosed is returned when requesting a client from a ClientPool that
+// has been closed.
+var ErrPoolClosed = errors.New("client pool is closed")
+
 // ClientPool holds a pool of ShareBase clients.  Clients for multiple data
 // centers with multiple authentication tokens can be stored in the same
 // pool.  ClientPools have a mutex to make accessing and caching clients safe
@@ -11,24 +21,198 @@ import (
 type ClientPool struct {
 	mutex    sync.Mutex
 	subPools map[clientPoolKey]*clientSubPool
+
+	// config holds the client limit and transport settings of the pool.
+	config ClientPoolConfig
+
+	// transports holds the transports shared by all the clients of each
+	// data center.
+	transports map[string]*http.Transport
+
+	// closed is set after the pool is closed.
+	closed bool
+
+	// retiredRequests is the number of requests made by clients that
+	// have been evicted or released by Close so that NumRequests still
+	// counts them.
+	retiredRequests uint64
 }
 
+// ClientPoolConfig configures a ClientPool created with
+// NewClientPoolWithConfig.  All of the clients in the pool for the same data
+// center share a single http.Transport so that they share a single pool of
+// keep-alive connections.
+type ClientPoolConfig struct {
+	// MaxClients is the maximum number of clients the pool creates for
+	// each data center and token pair.  0 means there's no limit.  See
+	// NewClientPoolWithLimit.
+	MaxClients int
+
+	// MaxIdleConnsPerHost is the number of idle keep-alive connections
+	// to each data center kept open.  If 0, http.DefaultMaxIdleConnsPerHost
+	// is used.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept open.  If
+	// 0, the http.DefaultTransport's timeout is used.
+	IdleConnTimeout time.Duration
+
+	// ValidateTokens makes the pool check that a client's token hasn't
+	// expired before handing the client out.  The first time, the token
+	// is checked with Whoami and, after that, against the expiration date
+	// that Whoami returned, so most clients don't need an extra request.
+	// Short-lived programs can leave this off.
+	ValidateTokens bool
+
+	// TokenProvider, if set, gets a new token for clients whose tokens
+	// have expired when ValidateTokens is set.  Without it, requesting a
+	// client with an expired token is an error.
+	TokenProvider TokenProvider
+
+	// Metrics, if set, is the Metrics of every client the pool creates.
+	Metrics Metrics
+}
+
+// TokenProvider gets new authentication tokens for a ClientPool.
+type TokenProvider interface {
+	// Token gets a new AuthToken for the given data center.
+	Token(dataCenter string) (AuthToken, error)
+}
+
+// TokenProviderFunc is a function that implements TokenProvider.
+type TokenProviderFunc func(dataCenter string) (AuthToken, error)
+
+// Token implements TokenProvider.
+func (f TokenProviderFunc) Token(dataCenter string) (AuthToken, error) {
+	return f(dataCenter)
+}
+
+// tokenExpiryMargin is how long before its expiration date a token is
+// treated as expired so that it doesn't expire in the middle of a request.
+const tokenExpiryMargin = time.Minute
+
 // NewClientPool creates a new pool of Clients.
 func NewClientPool() *ClientPool {
+	return NewClientPoolWithConfig(ClientPoolConfig{})
+}
+
+// NewClientPoolWithLimit creates a new pool of Clients that creates at most n
+// clients for each data center and token pair.  When all n clients are in use,
+// requesting another client blocks until one is returned with Cache.  Clients
+// that are never returned to the pool still count toward the limit.  If n is
+// 0, there is no limit.
+func NewClientPoolWithLimit(n int) *ClientPool {
+	return NewClientPoolWithConfig(ClientPoolConfig{MaxClients: n})
+}
+
+// NewClientPoolWithConfig creates a new pool of Clients with the given
+// configuration.
+func NewClientPoolWithConfig(config ClientPoolConfig) *ClientPool {
+	if config.MaxClients < 0 {
+		config.MaxClients = 0
+	}
 	return &ClientPool{
-		mutex:    sync.Mutex{},
-		subPools: make(map[clientPoolKey]*clientSubPool),
+		mutex:      sync.Mutex{},
+		subPools:   make(map[clientPoolKey]*clientSubPool),
+		config:     config,
+		transports: make(map[string]*http.Transport),
 	}
 }
 
 // Client gets an existing cached client or creates one.
 func (p *ClientPool) Client(dataCenter, token string) (*Client, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	if c, ok := p.getOrCreateSubPool(clientPoolKey{dataCenter, token}).getClient(); ok {
-		return c, nil
+	return p.ClientContext(context.Background(), dataCenter, token)
+}
+
+// ClientContext gets an existing cached client or creates one.  If the pool
+// has a limit and the limit has been reached, ClientContext waits until
+// another client is returned to the pool or ctx is done.  If the pool
+// validates its tokens, the client's token is checked and, if necessary,
+// refreshed before the client is returned.
+func (p *ClientPool) ClientContext(ctx context.Context, dataCenter, token string) (*Client, error) {
+	c, err := p.clientContext(ctx, dataCenter, token)
+	if err != nil || !p.config.ValidateTokens {
+		return c, err
+	}
+	if err = p.validate(c); err != nil {
+		// the client is still usable if the token is refreshed later.
+		p.Cache(c)
+		return nil, err
 	}
-	return NewClient(dataCenter, token)
+	return c, nil
+}
+
+func (p *ClientPool) clientContext(ctx context.Context, dataCenter, token string) (*Client, error) {
+	// Cache keys clients by their resolved DataCenter, so the lookup has
+	// to use the same key for "us" or a URL with a trailing slash to find
+	// them again.
+	dataCenterURL, err := ResolveDataCenter(dataCenter)
+	if err != nil {
+		return nil, err
+	}
+	key := clientPoolKey{dataCenterURL.String(), token}
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, ErrPoolClosed
+		}
+		sp := p.getOrCreateSubPool(key)
+		if c, ok := sp.getClient(); ok {
+			p.mutex.Unlock()
+			return c, nil
+		}
+		if p.config.MaxClients == 0 || len(sp.clients) < p.config.MaxClients {
+			c, err := newClientWithTransport(
+				dataCenter, token, p.getOrCreateTransport(key.dataCenter))
+			if err == nil {
+				c.poolToken = token
+				c.Metrics = p.config.Metrics
+				sp.addClient(c)
+			}
+			p.mutex.Unlock()
+			return c, err
+		}
+		cached := sp.cached
+		p.mutex.Unlock()
+		select {
+		case <-cached:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// validate checks that c's token hasn't expired and refreshes it with the
+// pool's TokenProvider if it has.  c must not be in use by anything else.
+func (p *ClientPool) validate(c *Client) error {
+	if !c.expires.IsZero() && time.Now().Add(tokenExpiryMargin).Before(c.expires) {
+		return nil
+	}
+	authToken, err := c.Whoami()
+	if err == nil {
+		c.expires = authToken.ExpirationDate
+		return nil
+	}
+	if _, ok := err.(Unauthorized); !ok {
+		return errors.ErrorfWithCause(
+			err, "failed to validate token: %v", err)
+	}
+	if p.config.TokenProvider == nil {
+		return err
+	}
+	dataCenter := c.DataCenter.String()
+	authToken, err = p.config.TokenProvider.Token(dataCenter)
+	if err != nil {
+		return errors.ErrorfWithCause(
+			err, "failed to refresh token for %v: %v",
+			dataCenter, err)
+	}
+	logger.Info1("refreshed expired token for %v", dataCenter)
+	c.phoenixToken = PhoenixTokenPrefix + authToken.Token
+	c.expires = authToken.ExpirationDate
+	atomic.StoreUint32(&c.loggedOut, 0)
+	return nil
 }
 
 // Cache the given client in the pool.  It is not necessary for the client to
@@ -39,13 +223,138 @@ func (p *ClientPool) Client(dataCenter, token string) (*Client, error) {
 func (p *ClientPool) Cache(c *Client) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
+	if p.closed {
+		c.httpClient.CloseIdleConnections()
+		return
+	}
 	key := clientPoolKey{
 		dataCenter: c.DataCenter.String(),
-		token:      c.phoenixToken[len(PhoenixTokenPrefix)+1:],
+		token:      c.poolToken,
+	}
+	if key.token == "" {
+		key.token = c.phoenixToken[len(PhoenixTokenPrefix):]
 	}
 	p.getOrCreateSubPool(key).cacheClient(c)
 }
 
+// Close releases all of the clients in the pool, both cached and in use, and
+// closes their idle connections.  Requesting a client from a closed pool
+// returns ErrPoolClosed.  Clients still in use may finish their requests but
+// are not put back into the pool when they are cached.  Closing a pool more
+// than once has no effect.
+func (p *ClientPool) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	subPools := p.subPools
+	p.subPools = make(map[clientPoolKey]*clientSubPool)
+	for _, sp := range subPools {
+		// wake up anything waiting so it sees the pool is closed.
+		sp.notify()
+	}
+	transports := p.transports
+	p.transports = make(map[string]*http.Transport)
+	for _, sp := range subPools {
+		p.retire(sp.clients)
+	}
+	p.mutex.Unlock()
+	for _, sp := range subPools {
+		for _, c := range sp.clients {
+			c.httpClient.CloseIdleConnections()
+		}
+	}
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+// EvictIdle removes cached clients that have been idle in the pool for longer
+// than maxIdle and closes their idle connections.  Clients that are in use are
+// never evicted.  It returns the number of evicted clients.
+func (p *ClientPool) EvictIdle(maxIdle time.Duration) int {
+	p.mutex.Lock()
+	now := time.Now()
+	var evicted []*Client
+	for _, sp := range p.subPools {
+		evicted = append(evicted, sp.evictIdle(now, maxIdle)...)
+	}
+	p.retire(evicted)
+	p.mutex.Unlock()
+	for _, c := range evicted {
+		c.httpClient.CloseIdleConnections()
+	}
+	return len(evicted)
+}
+
+// NumRequests returns the total number of requests issued to the ShareBase
+// API by all of the clients the pool has created or cached, including the
+// ones that it has since evicted or released with Close.
+func (p *ClientPool) NumRequests() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	n := p.retiredRequests
+	for _, sp := range p.subPools {
+		for _, c := range sp.clients {
+			n += c.NumRequests()
+		}
+	}
+	return n
+}
+
+// retire adds the requests made by clients that are being removed from the
+// pool to retiredRequests.  It must only be called while holding the pool's
+// mutex.
+func (p *ClientPool) retire(clients []*Client) {
+	for _, c := range clients {
+		p.retiredRequests += c.NumRequests()
+	}
+}
+
+// EvictIdleEvery starts a background goroutine that calls EvictIdle with
+// maxIdle every interval until the returned stop function is called.
+func (p *ClientPool) EvictIdleEvery(interval, maxIdle time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.EvictIdle(maxIdle)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// getOrCreateTransport gets the transport shared by the pool's clients for
+// the given resolved data center URL.  It must only be called while holding
+// the pool's lock.
+func (p *ClientPool) getOrCreateTransport(dataCenter string) *http.Transport {
+	t, ok := p.transports[dataCenter]
+	if ok {
+		return t
+	}
+	t = http.DefaultTransport.(*http.Transport).Clone()
+	if p.config.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = p.config.MaxIdleConnsPerHost
+	}
+	if p.config.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = p.config.IdleConnTimeout
+	}
+	p.transports[dataCenter] = t
+	return t
+}
+
 func (p *ClientPool) getOrCreateSubPool(k clientPoolKey) *clientSubPool {
 	sp, ok := p.subPools[k]
 	if !ok {
@@ -64,16 +373,32 @@ type clientSubPool struct {
 	clients []*Client
 	cache   []int
 	inuse   map[*Client]int
+
+	// lastUsed holds the time that each cached client was returned to
+	// the pool.
+	lastUsed map[*Client]time.Time
+
+	// cached is closed and replaced whenever a client is cached to wake
+	// up anything waiting for a client.
+	cached chan struct{}
 }
 
 func newClientSubPool() *clientSubPool {
 	return &clientSubPool{
-		clients: make([]*Client, 0, 1),
-		cache:   make([]int, 0, 1),
-		inuse:   make(map[*Client]int, 1),
+		clients:  make([]*Client, 0, 1),
+		cache:    make([]int, 0, 1),
+		inuse:    make(map[*Client]int, 1),
+		lastUsed: make(map[*Client]time.Time, 1),
+		cached:   make(chan struct{}),
 	}
 }
 
+// addClient adds a newly created client to the subpool as in use.
+func (sp *clientSubPool) addClient(c *Client) {
+	sp.inuse[c] = len(sp.clients)
+	sp.clients = append(sp.clients, c)
+}
+
 // getClient pulls a cached client from the subpool.  This function is not
 // threadsafe; make sure you only use it while holding the ClientPool's lock.
 func (sp *clientSubPool) getClient() (*Client, bool) {
@@ -85,6 +410,7 @@ func (sp *clientSubPool) getClient() (*Client, bool) {
 	sp.cache = sp.cache[:length-1]
 	client := sp.clients[index]
 	sp.inuse[client] = index
+	delete(sp.lastUsed, client)
 	return client, true
 }
 
@@ -97,4 +423,53 @@ func (sp *clientSubPool) cacheClient(c *Client) {
 	}
 	delete(sp.inuse, c)
 	sp.cache = append(sp.cache, index)
+	sp.lastUsed[c] = time.Now()
+	sp.notify()
+}
+
+// notify wakes up anything waiting for a client from the subpool.
+func (sp *clientSubPool) notify() {
+	close(sp.cached)
+	sp.cached = make(chan struct{})
+}
+
+// evictIdle removes the cached clients that were returned to the subpool more
+// than maxIdle before now and returns them.
+func (sp *clientSubPool) evictIdle(now time.Time, maxIdle time.Duration) (evicted []*Client) {
+	idle := make(map[int]bool)
+	cache := make([]int, 0, len(sp.cache))
+	for _, index := range sp.cache {
+		c := sp.clients[index]
+		if now.Sub(sp.lastUsed[c]) > maxIdle {
+			idle[index] = true
+			evicted = append(evicted, c)
+			delete(sp.lastUsed, c)
+			continue
+		}
+		cache = append(cache, index)
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+	// evicted clients are removed from the clients slice so the indexes
+	// of the remaining clients have to be updated.
+	remap := make([]int, len(sp.clients))
+	clients := make([]*Client, 0, len(sp.clients)-len(evicted))
+	for i, c := range sp.clients {
+		if idle[i] {
+			continue
+		}
+		remap[i] = len(clients)
+		clients = append(clients, c)
+	}
+	for i, index := range cache {
+		cache[i] = remap[index]
+	}
+	for c, index := range sp.inuse {
+		sp.inuse[c] = remap[index]
+	}
+	sp.clients = clients
+	sp.cache = cache
+	sp.notify()
+	return evicted
 }
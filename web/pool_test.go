@@ -0,0 +1,338 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+const (
+	testDataCenter = "https://app.sharebase.com/sharebaseapi"
+	testToken      = "abc123"
+)
+
+func TestClientPoolCacheThenClient(t *testing.T) {
+	p := web.NewClientPool()
+	c, err := web.NewClient(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Cache(c)
+	c2, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 != c {
+		t.Fatalf("expected cached client %p, got %p", c, c2)
+	}
+}
+
+func TestClientPoolCacheThenClientResolved(t *testing.T) {
+	for _, dataCenter := range []string{
+		"us",
+		testDataCenter + "/",
+		"app.sharebase.com",
+	} {
+		t.Run(dataCenter, func(t *testing.T) {
+			p := web.NewClientPoolWithLimit(1)
+			defer p.Close()
+			c, err := p.Client(dataCenter, testToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Cache(c)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			c2, err := p.ClientContext(ctx, dataCenter, testToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c2 != c {
+				t.Fatalf("expected cached client %p, got %p", c, c2)
+			}
+		})
+	}
+}
+
+func TestClientPoolLimit(t *testing.T) {
+	const limit = 3
+	p := web.NewClientPoolWithLimit(limit)
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		inUse   int
+		maxUse  int
+		clients = make(map[*web.Client]struct{})
+	)
+	for i := 0; i < 4*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := p.Client(testDataCenter, testToken)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			inUse++
+			if inUse > maxUse {
+				maxUse = inUse
+			}
+			clients[c] = struct{}{}
+			mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			inUse--
+			mutex.Unlock()
+			p.Cache(c)
+		}()
+	}
+	wg.Wait()
+	if maxUse > limit {
+		t.Fatalf("%d clients were in use at once; limit is %d", maxUse, limit)
+	}
+	if len(clients) > limit {
+		t.Fatalf("%d clients were created; limit is %d", len(clients), limit)
+	}
+}
+
+func TestClientPoolLimitContext(t *testing.T) {
+	p := web.NewClientPoolWithLimit(1)
+	c, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Cache(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = p.ClientContext(ctx, testDataCenter, testToken); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientPoolEvictIdle(t *testing.T) {
+	p := web.NewClientPool()
+	idle, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Cache(idle)
+	time.Sleep(5 * time.Millisecond)
+	if n := p.EvictIdle(time.Millisecond); n != 1 {
+		t.Fatalf("expected 1 evicted client, got %d", n)
+	}
+	c, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == idle || c == busy {
+		t.Fatal("expected a new client after eviction")
+	}
+	p.Cache(busy)
+	c2, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 != busy {
+		t.Fatal("in-use client should not have been evicted")
+	}
+}
+
+func TestClientPoolClose(t *testing.T) {
+	p := web.NewClientPoolWithLimit(1)
+	c, err := p.Client(testDataCenter, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitErr := make(chan error)
+	go func() {
+		_, err := p.Client(testDataCenter, testToken)
+		waitErr <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-waitErr; err != web.ErrPoolClosed {
+		t.Fatalf("expected waiting client request to get %v, got %v", web.ErrPoolClosed, err)
+	}
+	p.Cache(c)
+	if _, err = p.Client(testDataCenter, testToken); err != web.ErrPoolClosed {
+		t.Fatalf("expected %v, got %v", web.ErrPoolClosed, err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientPoolSharedTransport(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	p := web.NewClientPoolWithConfig(web.ClientPoolConfig{
+		MaxIdleConnsPerHost: 1,
+	})
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		// Get all of the clients before using them so they're all
+		// different clients.
+		var clients [3]*web.Client
+		for j := range clients {
+			c, err := p.Client(srv.URL, testToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients[j] = c
+		}
+		for _, c := range clients {
+			if _, err := c.Libraries(); err != nil {
+				t.Fatal(err)
+			}
+			p.Cache(c)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("expected pooled clients to share 1 connection, got %d", n)
+	}
+}
+
+func TestClientPoolValidateTokens(t *testing.T) {
+	var checks int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&checks, 1)
+		if r.Header.Get("Authorization") != web.PhoenixTokenPrefix+"new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(web.AuthToken{
+			Token: "new", ExpirationDate: time.Now().Add(time.Hour),
+		})
+	}))
+	defer srv.Close()
+
+	p := web.NewClientPoolWithConfig(web.ClientPoolConfig{ValidateTokens: true})
+	if _, err := p.Client(srv.URL, "old"); err != web.ErrUnauthorized {
+		t.Fatalf("expected %v without a token provider, got %v", web.ErrUnauthorized, err)
+	}
+
+	var refreshes int
+	p = web.NewClientPoolWithConfig(web.ClientPoolConfig{
+		ValidateTokens: true,
+		TokenProvider: web.TokenProviderFunc(func(dataCenter string) (web.AuthToken, error) {
+			refreshes++
+			return web.AuthToken{Token: "new", ExpirationDate: time.Now().Add(time.Hour)}, nil
+		}),
+	})
+	c, err := p.Client(srv.URL, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshes != 1 {
+		t.Fatalf("expected 1 refresh, got %d", refreshes)
+	}
+	if _, err = c.Whoami(); err != nil {
+		t.Fatal(err)
+	}
+	p.Cache(c)
+	atomic.StoreInt32(&checks, 0)
+	// the known expiration date is checked without another request.
+	c2, err := p.Client(srv.URL, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&checks); c2 != c || refreshes != 1 || n != 0 {
+		t.Fatalf(
+			"expected the refreshed client without a request, got %p "+
+				"(%d refreshes, %d checks)", c2, refreshes, n)
+	}
+}
+
+func TestClientPoolValidateTokensDataCenterPath(t *testing.T) {
+	var checks int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sharebaseapi/api/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&checks, 1)
+		json.NewEncoder(w).Encode(web.AuthToken{
+			Token: testToken, ExpirationDate: time.Now().Add(time.Hour),
+		})
+	}))
+	defer srv.Close()
+
+	p := web.NewClientPoolWithConfig(web.ClientPoolConfig{ValidateTokens: true})
+	defer p.Close()
+	c, err := p.Client(srv.URL+"/sharebaseapi", testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&checks); n != 1 {
+		t.Fatalf("expected the token to be checked once, got %d", n)
+	}
+	p.Cache(c)
+}
+
+func TestClientPoolNumRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	p := web.NewClientPool()
+	idle, err := p.Client(srv.URL, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := p.Client(srv.URL, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*web.Client{idle, busy, busy} {
+		if _, err = c.Libraries(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := p.NumRequests(); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+	// evicted clients' requests are still counted.
+	p.Cache(idle)
+	time.Sleep(5 * time.Millisecond)
+	if n := p.EvictIdle(time.Millisecond); n != 1 {
+		t.Fatalf("expected 1 evicted client, got %d", n)
+	}
+	if n := p.NumRequests(); n != 3 {
+		t.Fatalf("expected 3 requests after eviction, got %d", n)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.NumRequests(); n != 3 {
+		t.Fatalf("expected 3 requests after closing, got %d", n)
+	}
+}
@@ -0,0 +1,170 @@
+package web
+
+import (
+	"context"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skillian/errors"
+)
+
+// RateLimiter limits the number of bytes per second that can be read or
+// written through the Readers and Writers it wraps.  They all share the
+// limiter's one budget, so a single RateLimiter used for several transfers at
+// the same time (like parallel uploads) caps their combined rate.  To limit
+// each transfer separately, give each one its own RateLimiter.
+//
+// RateLimiters are safe to use from multiple goroutines.
+type RateLimiter struct {
+	rate Size
+
+	// mutex protects next.
+	mutex sync.Mutex
+
+	// next is when the bytes that have been transferred so far would
+	// have been transferred at the limiter's rate.
+	next time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows up to bytesPerSecond
+// bytes per second.  bytesPerSecond must be positive.
+func NewRateLimiter(bytesPerSecond Size) (*RateLimiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, errors.Errorf(
+			"rate limit must be positive, not %d", int64(bytesPerSecond))
+	}
+	return &RateLimiter{rate: bytesPerSecond}, nil
+}
+
+// Rate is the limiter's bytes per second.
+func (l *RateLimiter) Rate() Size { return l.rate }
+
+// chunkSize is the most that's read or written at once so that transfers
+// are spread out over time instead of happening in bursts of one buffer.
+func (l *RateLimiter) chunkSize() int {
+	n := int64(l.rate / 10)
+	if n < 1 {
+		return 1
+	}
+	if n > int64(MaxPatchSize) {
+		return int(MaxPatchSize)
+	}
+	return int(n)
+}
+
+// wait accounts for n bytes and waits until the limiter's rate allows them.
+// It returns early with ctx's error if ctx is done first.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return ctx.Err()
+	}
+	d := time.Duration(int64(n) * int64(time.Second) / int64(l.rate))
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		// don't let idle time build up into a burst.
+		l.next = now
+	}
+	l.next = l.next.Add(d)
+	delay := l.next.Sub(now)
+	l.mutex.Unlock()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader wraps r so that reading from it is limited by l.  Reads return
+// ctx's error if it's canceled while they're being throttled.
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &rateLimitedReader{ctx: ctx, l: l, r: r}
+}
+
+// Writer wraps w so that writing to it is limited by l.  Writes return ctx's
+// error if it's canceled while they're being throttled.
+func (l *RateLimiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &rateLimitedWriter{ctx: ctx, l: l, w: w}
+}
+
+type rateLimitedReader struct {
+	ctx context.Context
+	l   *RateLimiter
+	r   io.Reader
+}
+
+// Read implements io.Reader.  The bytes are waited for after they're read
+// so that only the bytes actually read count against the limit.
+func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if max := r.l.chunkSize(); len(p) > max {
+		p = p[:max]
+	}
+	n, err = r.r.Read(p)
+	if err2 := r.l.wait(r.ctx, n); err2 != nil {
+		return n, err2
+	}
+	return n, err
+}
+
+type rateLimitedWriter struct {
+	ctx context.Context
+	l   *RateLimiter
+	w   io.Writer
+}
+
+// Write implements io.Writer.
+func (w *rateLimitedWriter) Write(p []byte) (n int, err error) {
+	max := w.l.chunkSize()
+	for len(p) > 0 {
+		chunk := p[:minInt(len(p), max)]
+		if err = w.l.wait(w.ctx, len(chunk)); err != nil {
+			return n, err
+		}
+		var m int
+		m, err = w.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// ParseSize parses a size like the ones formatted by Size.Human: a number
+// optionally followed by one of the B, K, M, or G units (e.g. "500K" or
+// "1.5M").  The units are case-insensitive and a trailing "B" or "iB" after
+// K, M, or G is allowed.
+func ParseSize(s string) (Size, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "IB"), "B")
+	unit := B
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			unit = K
+		case 'M':
+			unit = M
+		case 'G':
+			unit = G
+		}
+		if unit != B {
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.Errorf("invalid size: %q", s)
+	}
+	return Size(f * float64(unit)), nil
+}
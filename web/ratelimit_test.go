@@ -0,0 +1,108 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skillian/sharebase/web"
+)
+
+func TestRateLimiterReader(t *testing.T) {
+	l, err := web.NewRateLimiter(10 * web.K)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{'x'}, int(5*web.K))
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, l.Reader(context.Background(), bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("expected %d bytes, got %d", len(data), n)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("5K at 10K/s took only %v", elapsed)
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	l, err := web.NewRateLimiter(20 * web.K)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{'x'}, int(5*web.K))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := l.Writer(context.Background(), ioutil.Discard)
+			if _, err := w.Write(data); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// 10K in total at 20K/s has to take about half a second even though
+	// each writer alone would only take a quarter of one.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("2 * 5K at a shared 20K/s took only %v", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l, err := web.NewRateLimiter(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := l.Reader(ctx, bytes.NewReader([]byte("abc")))
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(r)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read was not canceled while throttled")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		size web.Size
+		ok   bool
+	}{
+		{"100", 100, true},
+		{"100B", 100, true},
+		{"500k", 500 * web.K, true},
+		{"1.5M", 3 * web.M / 2, true},
+		{"2MiB", 2 * web.M, true},
+		{"1GB", web.G, true},
+		{"", 0, false},
+		{"fast", 0, false},
+		{"-1K", 0, false},
+	} {
+		size, err := web.ParseSize(tc.s)
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseSize(%q): unexpected error: %v", tc.s, err)
+			continue
+		}
+		if size != tc.size {
+			t.Errorf("ParseSize(%q): expected %d, got %d", tc.s, tc.size, size)
+		}
+	}
+}
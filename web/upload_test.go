@@ -202,7 +202,7 @@ func TestUploadSmall(t *testing.T) {
 		t.Fatal(errPlusConfig("getting folder: "+folderName, err))
 	}
 
-	err = fld.NewDocument(c, filename, bytes.NewReader(expectedData))
+	_, err = fld.NewDocument(c, filename, bytes.NewReader(expectedData))
 	if err != nil {
 		t.Fatal(errPlusConfig("uploading "+filename, err))
 	}
@@ -235,7 +235,7 @@ func TestUploadLarge(t *testing.T) {
 	}
 
 	filename := path.Base(large.Name())
-	err = fld.NewDocument(c, filename, large)
+	_, err = fld.NewDocument(c, filename, large)
 	if err != nil {
 		t.Fatal(errPlusConfig("uploading "+filename, err))
 	}